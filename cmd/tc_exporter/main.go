@@ -21,8 +21,8 @@ var cli App
 
 // Config datasructure representing the configuration file
 type Config struct {
-	NetNS   map[string]NS
-	Filters tcexporter.FilterHolder `mapstructure:"filters"`
+	NetNS     map[string]NS
+	Collector tcexporter.Config `mapstructure:",squash"`
 }
 
 // NS holds a type alias so we can use it in the config file
@@ -38,16 +38,28 @@ type App struct {
 	QdiscEnable   bool   `help:"enable the qdisc collector" negatable:"" default:"true" name:"collector-qdisc"`
 	ClassEnable   bool   `help:"enable the class collector" negatable:"" default:"true" name:"collector-class"`
 	CbqEnable     bool   `help:"enable the cbq collector" negatable:"" default:"false" name:"collector-cbq"`
+	CbsEnable     bool   `help:"enable the cbs collector" negatable:"" default:"false" name:"collector-cbs"`
 	ChokeEnable   bool   `help:"enable the choke collector" negatable:"" default:"false" name:"collector-choke"`
 	CodelEnable   bool   `help:"enable the codel collector" negatable:"" default:"false" name:"collector-codel"`
+	EtfEnable     bool   `help:"enable the etf collector" negatable:"" default:"false" name:"collector-etf"`
+	FifoEnable    bool   `help:"enable the fifo collector" negatable:"" default:"false" name:"collector-fifo"`
 	FqEnable      bool   `help:"enable the fq collector" negatable:"" default:"false" name:"collector-fq"`
 	FqcodelEnable bool   `help:"enable the fqcodel collector" negatable:"" default:"false" name:"collector-fqcodel"`
+	GredEnable    bool   `help:"enable the gred collector" negatable:"" default:"false" name:"collector-gred"`
 	HfscEnable    bool   `help:"enable the hfsc collector" negatable:"" default:"false" name:"collector-hfsc"`
 	HtbEnable     bool   `help:"enable the htb collector" negatable:"" default:"false" name:"collector-htb"`
 	PieEnable     bool   `help:"enable the pie collector" negatable:"" default:"false" name:"collector-pie"`
+	QfqEnable     bool   `help:"enable the qfq collector" negatable:"" default:"false" name:"collector-qfq"`
 	RedEnable     bool   `help:"enable the red collector" negatable:"" default:"false" name:"collector-red"`
+	SkbprioEnable bool   `help:"enable the skbprio collector" negatable:"" default:"false" name:"collector-skbprio"`
 	SfbEnable     bool   `help:"enable the sfb collector" negatable:"" default:"false" name:"collector-sfb"`
 	SfqEnable     bool   `help:"enable the sfq collector" negatable:"" default:"false" name:"collector-sfq"`
+	AqmEnable     bool   `help:"enable the normalized aqm collector" negatable:"" default:"false" name:"collector-aqm"`
+	FilterEnable  bool   `help:"enable the filter collector" negatable:"" default:"false" name:"collector-filter"`
+	OffloadEnable bool   `help:"enable the hardware offload collector" negatable:"" default:"false" name:"collector-offload"`
+	ActionEnable  bool   `help:"enable the action table collector" negatable:"" default:"false" name:"collector-action"`
+	InfoEnable    bool   `help:"enable the qdisc and class info collector" negatable:"" default:"false" name:"collector-info"`
+	TreeEnable    bool   `help:"enable the tree info collector" negatable:"" default:"false" name:"collector-tree"`
 }
 
 func (a *App) Run(logger *slog.Logger, cfg Config) error {
@@ -69,21 +81,33 @@ func (a *App) Run(logger *slog.Logger, cfg Config) error {
 		"qdisc":         a.QdiscEnable,
 		"class":         a.ClassEnable,
 		"cbq":           a.CbqEnable,
+		"cbs":           a.CbsEnable,
 		"choke":         a.ChokeEnable,
 		"codel":         a.CodelEnable,
+		"etf":           a.EtfEnable,
+		"fifo":          a.FifoEnable,
 		"fq":            a.FqEnable,
 		"fq_codel":      a.FqcodelEnable,
+		"gred":          a.GredEnable,
 		"hfsc":          a.HfscEnable,
 		"service_curve": a.HfscEnable,
 		"htb":           a.HtbEnable,
 		"pie":           a.PieEnable,
+		"qfq":           a.QfqEnable,
 		"red":           a.RedEnable,
+		"skbprio":       a.SkbprioEnable,
 		"sfb":           a.SfbEnable,
 		"sfq":           a.SfqEnable,
+		"aqm":           a.AqmEnable,
+		"filter":        a.FilterEnable,
+		"offload":       a.OffloadEnable,
+		"info":          a.InfoEnable,
+		"action":        a.ActionEnable,
+		"tree":          a.TreeEnable,
 	}
 
 	// initialise the collector with the configured subcollectors
-	collector, err := tcexporter.NewTcCollector(netns, enabledCollectors, cfg.Filters, logger)
+	collector, err := tcexporter.NewTcCollector(netns, enabledCollectors, cfg.Collector, logger)
 	if err != nil {
 		slog.Error("failed to create TC collector", "err", err.Error())
 		return err
@@ -0,0 +1,474 @@
+package tccollector
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"math/bits"
+	"net/netip"
+	"strings"
+
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	actionLabels       []string = []string{"host", "netns", "kind", "index"}
+	actionSourceLabels []string = []string{"host", "netns", "kind", "index", "source"}
+	actionInfoLabels   []string = []string{"host", "netns", "kind", "index", "control", "config"}
+
+	// actionKinds are the action kinds whose tables are dumped
+	actionKinds = []string{
+		"police", "gact", "mirred", "skbedit", "pedit", "ct", "nat", "vlan", "tunnel_key", "sample",
+		"connmark", "csum",
+	}
+)
+
+// actParmsAttrs is the attribute that holds the parameters of the action, per action. They start
+// with the tc_gen fields, except for police.
+var actParmsAttrs = map[string]uint16{
+	"connmark":   1,
+	"csum":       1,
+	"ct":         1,
+	"gact":       2,
+	"mirred":     2,
+	"nat":        1,
+	"pedit":      2,
+	"police":     1,
+	"sample":     2,
+	"skbedit":    2,
+	"tunnel_key": 2,
+	"vlan":       2,
+}
+
+// action netlink attributes and parameters
+const (
+	// tcGenLen is the size of the tc_gen fields: index, capab, action, refcnt and bindcnt
+	tcGenLen = 20
+
+	// the offsets in struct tc_police
+	policeAction   = 4
+	policeMtu      = 16
+	policeRate     = 28
+	policePeakrate = 40
+	policeRefcnt   = 44
+	policeBindcnt  = 48
+	policeLen      = 52
+
+	tcaPoliceRate64     = 8
+	tcaPolicePeakrate64 = 9
+	tcaPeditParmsEx     = 4
+
+	tcaGactProb = 3
+
+	tcaSkbeditPriority     = 3
+	tcaSkbeditQueueMapping = 4
+	tcaSkbeditMark         = 5
+
+	tcaVlanPushVlanID       = 3
+	tcaVlanPushVlanProtocol = 4
+	tcaVlanPushVlanPriority = 6
+
+	tcaTunnelKeyEncIPv4Src = 3
+	tcaTunnelKeyEncIPv4Dst = 4
+	tcaTunnelKeyEncIPv6Src = 5
+	tcaTunnelKeyEncIPv6Dst = 6
+	tcaTunnelKeyEncKeyID   = 7
+	tcaTunnelKeyEncDstPort = 9
+
+	tcaSampleRate         = 3
+	tcaSamplePsampleGroup = 5
+
+	tcaCtAction = 3
+	tcaCtZone   = 4
+	tcaCtMark   = 5
+
+	// TC_ACT_JUMP and TC_ACT_GOTO_CHAIN carry the count or the chain in the low bits
+	tcActExtOpcodeMax = 0xf0000000
+	tcActJump         = 0x10000000
+	tcActGotoChain    = 0x20000000
+)
+
+// actionControlNames are the names tc uses for the TC_ACT_* control actions
+var actionControlNames = map[int32]string{
+	-1: "continue",
+	0:  "pass",
+	1:  "reclassify",
+	2:  "drop",
+	3:  "pipe",
+	4:  "stolen",
+	5:  "queued",
+	6:  "repeat",
+	7:  "redirect",
+	8:  "trap",
+}
+
+// ActionCollector exports the action tables of a netns. Actions can be shared by several filters
+// or exist without any filter, their counters are only in the table of the kind.
+type ActionCollector struct {
+	logger         slog.Logger
+	netns          map[string][]rtnetlink.LinkMessage
+	info           *prometheus.Desc
+	bytes          *prometheus.Desc
+	packets        *prometheus.Desc
+	drops          *prometheus.Desc
+	overlimits     *prometheus.Desc
+	bindCount      *prometheus.Desc
+	refCount       *prometheus.Desc
+	lastUsed       *prometheus.Desc
+	policeRate     *prometheus.Desc
+	policePeakrate *prometheus.Desc
+	policeMtu      *prometheus.Desc
+}
+
+// NewActionCollector create a new ActionCollector
+func NewActionCollector(netns map[string][]rtnetlink.LinkMessage, log *slog.Logger) (NamespaceCollector, error) {
+	log = log.With("collector", "action")
+	log.Info("making action collector", "kinds", actionKinds)
+
+	return &ActionCollector{
+		logger: *log,
+		netns:  netns,
+		info: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "action", "info"),
+			"Action in the action table, with its control action and its configuration",
+			actionInfoLabels, nil,
+		),
+		bytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "action", "bytes"),
+			"Bytes handled by the action, by the hardware or the software",
+			actionSourceLabels, nil,
+		),
+		packets: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "action", "packets"),
+			"Packets handled by the action, by the hardware or the software",
+			actionSourceLabels, nil,
+		),
+		drops: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "action", "drops"),
+			"Packets dropped by the action",
+			actionLabels, nil,
+		),
+		overlimits: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "action", "overlimits"),
+			"Packets over the limit of the action",
+			actionLabels, nil,
+		),
+		bindCount: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "action", "bind_count"),
+			"Number of filters the action is bound to, 0 for unused actions",
+			actionLabels, nil,
+		),
+		refCount: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "action", "ref_count"),
+			"Number of references to the action, the table and the filters",
+			actionLabels, nil,
+		),
+		lastUsed: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "action", "last_used_seconds"),
+			"Time since the action was last used",
+			actionLabels, nil,
+		),
+		policeRate: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "action", "police_rate_bytes"),
+			"Rate of the police action in bytes per second",
+			actionLabels, nil,
+		),
+		policePeakrate: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "action", "police_peakrate_bytes"),
+			"Peak rate of the police action in bytes per second",
+			actionLabels, nil,
+		),
+		policeMtu: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "action", "police_mtu_bytes"),
+			"Largest packet the police action accepts",
+			actionLabels, nil,
+		),
+	}, nil
+}
+
+// Describe implements Collector
+func (col *ActionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ds := []*prometheus.Desc{
+		col.info,
+		col.bytes,
+		col.packets,
+		col.drops,
+		col.overlimits,
+		col.bindCount,
+		col.refCount,
+		col.lastUsed,
+		col.policeRate,
+		col.policePeakrate,
+		col.policeMtu,
+	}
+
+	for _, d := range ds {
+		ch <- d
+	}
+}
+
+// CollectNamespace dumps the table of every action kind of the netns. The kinds of which the
+// module is not loaded have an empty table.
+func (col *ActionCollector) CollectNamespace(ch chan<- prometheus.Metric, host, ns string, links []rtnetlink.LinkMessage) {
+	names := make(map[uint32]string, len(links))
+	for _, link := range links {
+		names[link.Index] = link.Attributes.Name
+	}
+	for _, kind := range actionKinds {
+		actions, err := getActions(ns, kind)
+		if err != nil {
+			col.logger.Error("failed to get actions", "kind", kind, "err", err)
+			continue
+		}
+		for _, act := range actions {
+			col.collectAction(ch, host, ns, act, names)
+		}
+	}
+}
+
+// collectAction exports the metrics of a single action of the table
+func (col *ActionCollector) collectAction(ch chan<- prometheus.Metric, host, ns string, act rawAction, names map[uint32]string) {
+	parms := act.parms()
+	index := act.Index
+	// older kernels only report the index in the parameters
+	if index == 0 && len(parms) >= 4 {
+		index = binary.NativeEndian.Uint32(parms[0:4])
+	}
+	labels := []string{host, ns, act.Kind, fmt.Sprintf("%d", index)}
+
+	control, refcnt, bindcnt, ok := actionParms(act.Kind, parms)
+	if !ok {
+		col.logger.Debug("action without parameters", "kind", act.Kind, "index", index)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(
+		col.info,
+		prometheus.GaugeValue,
+		1,
+		append(labels, actionControlName(control), decodeActionConfig(act, names))...,
+	)
+	ch <- prometheus.MustNewConstMetric(col.bindCount, prometheus.GaugeValue, float64(bindcnt), labels...)
+	ch <- prometheus.MustNewConstMetric(col.refCount, prometheus.GaugeValue, float64(refcnt), labels...)
+
+	var counters splitCounters
+	hasHw := counters.add(act.Stats)
+	ch <- prometheus.MustNewConstMetric(col.bytes, prometheus.CounterValue, counters.swBytes, append(labels, "sw")...)
+	ch <- prometheus.MustNewConstMetric(col.packets, prometheus.CounterValue, counters.swPackets, append(labels, "sw")...)
+	if hasHw {
+		ch <- prometheus.MustNewConstMetric(col.bytes, prometheus.CounterValue, counters.hwBytes, append(labels, "hw")...)
+		ch <- prometheus.MustNewConstMetric(col.packets, prometheus.CounterValue, counters.hwPackets, append(labels, "hw")...)
+	}
+	// struct gnet_stats_queue: qlen, backlog, drops, requeues and overlimits
+	if queue, ok := act.Stats[tcaStatsQueue]; ok && len(queue) >= 20 {
+		ch <- prometheus.MustNewConstMetric(col.drops, prometheus.CounterValue, float64(binary.NativeEndian.Uint32(queue[8:12])), labels...)
+		ch <- prometheus.MustNewConstMetric(col.overlimits, prometheus.CounterValue, float64(binary.NativeEndian.Uint32(queue[16:20])), labels...)
+	}
+	if age, ok := act.lastUsed(); ok {
+		ch <- prometheus.MustNewConstMetric(col.lastUsed, prometheus.GaugeValue, age, labels...)
+	}
+	if act.Kind == "police" {
+		col.collectPolice(ch, labels, act, parms)
+	}
+}
+
+// collectPolice exports the rates and the mtu of a police action. The 64 bit rates are only sent
+// when the rate does not fit the rate spec.
+func (col *ActionCollector) collectPolice(ch chan<- prometheus.Metric, labels []string, act rawAction, parms []byte) {
+	rate := uint64(binary.NativeEndian.Uint32(parms[policeRate : policeRate+4]))
+	if v, ok := act.Options[tcaPoliceRate64]; ok && len(v) >= 8 {
+		rate = binary.NativeEndian.Uint64(v)
+	}
+	peakrate := uint64(binary.NativeEndian.Uint32(parms[policePeakrate : policePeakrate+4]))
+	if v, ok := act.Options[tcaPolicePeakrate64]; ok && len(v) >= 8 {
+		peakrate = binary.NativeEndian.Uint64(v)
+	}
+	ch <- prometheus.MustNewConstMetric(col.policeRate, prometheus.GaugeValue, float64(rate), labels...)
+	ch <- prometheus.MustNewConstMetric(col.policePeakrate, prometheus.GaugeValue, float64(peakrate), labels...)
+	ch <- prometheus.MustNewConstMetric(col.policeMtu, prometheus.GaugeValue, float64(binary.NativeEndian.Uint32(parms[policeMtu:policeMtu+4])), labels...)
+}
+
+// parms returns the parameters of the action. pedit sends them in another attribute when it has
+// extended keys.
+func (act rawAction) parms() []byte {
+	if parms, ok := act.Options[actParmsAttrs[act.Kind]]; ok {
+		return parms
+	}
+	if act.Kind == "pedit" {
+		return act.Options[tcaPeditParmsEx]
+	}
+	return nil
+}
+
+// actionParms reads the control action and the reference counts from the parameters of the action
+func actionParms(kind string, parms []byte) (control int32, refcnt, bindcnt int32, ok bool) {
+	if kind == "police" {
+		if len(parms) < policeLen {
+			return 0, 0, 0, false
+		}
+		return int32(binary.NativeEndian.Uint32(parms[policeAction : policeAction+4])),
+			int32(binary.NativeEndian.Uint32(parms[policeRefcnt : policeRefcnt+4])),
+			int32(binary.NativeEndian.Uint32(parms[policeBindcnt : policeBindcnt+4])), true
+	}
+	if len(parms) < tcGenLen {
+		return 0, 0, 0, false
+	}
+	return int32(binary.NativeEndian.Uint32(parms[8:12])),
+		int32(binary.NativeEndian.Uint32(parms[12:16])),
+		int32(binary.NativeEndian.Uint32(parms[16:20])), true
+}
+
+// actionControlName returns the name tc uses for the control action
+func actionControlName(control int32) string {
+	switch uint32(control) & tcActExtOpcodeMax {
+	case tcActJump:
+		return fmt.Sprintf("jump %d", uint32(control)&^tcActExtOpcodeMax)
+	case tcActGotoChain:
+		return fmt.Sprintf("goto chain %d", uint32(control)&^tcActExtOpcodeMax)
+	}
+	if name, found := actionControlNames[control]; found {
+		return name
+	}
+	return fmt.Sprintf("%d", control)
+}
+
+// decodeActionConfig returns the configuration of the action in a tc like notation, like
+// `egress redirect dev eth1` for mirred. The control action is not part of it.
+func decodeActionConfig(act rawAction, names map[uint32]string) string {
+	opts := act.Options
+	parms := act.parms()
+	u32 := func(b []byte, off int) (uint32, bool) {
+		if len(b) < off+4 {
+			return 0, false
+		}
+		return binary.NativeEndian.Uint32(b[off : off+4]), true
+	}
+
+	var parts []string
+	switch act.Kind {
+	case "gact":
+		// struct tc_gact_p: ptype, pval and paction
+		if p, ok := opts[tcaGactProb]; ok && len(p) >= 8 {
+			ptype := "netrand"
+			if binary.NativeEndian.Uint16(p[0:2]) == 2 {
+				ptype = "determ"
+			}
+			parts = append(parts, fmt.Sprintf("random type %s %s val %d", ptype,
+				actionControlName(int32(binary.NativeEndian.Uint32(p[4:8]))), binary.NativeEndian.Uint16(p[2:4])))
+		}
+	case "mirred":
+		eaction, ok := u32(parms, tcGenLen)
+		ifindex, _ := u32(parms, tcGenLen+4)
+		if ok && eaction >= 1 && eaction <= 4 {
+			parts = append(parts, []string{"egress redirect", "egress mirror", "ingress redirect", "ingress mirror"}[eaction-1])
+		}
+		if ifindex != 0 {
+			dev, found := names[ifindex]
+			if !found {
+				dev = fmt.Sprintf("%d", ifindex)
+			}
+			parts = append(parts, "dev "+dev)
+		}
+	case "skbedit":
+		if v, ok := u32(opts[tcaSkbeditPriority], 0); ok {
+			maj, min := HandleStr(v)
+			parts = append(parts, fmt.Sprintf("priority %x:%x", maj, min))
+		}
+		if v, ok := opts[tcaSkbeditQueueMapping]; ok && len(v) >= 2 {
+			parts = append(parts, fmt.Sprintf("queue_mapping %d", binary.NativeEndian.Uint16(v)))
+		}
+		if v, ok := u32(opts[tcaSkbeditMark], 0); ok {
+			parts = append(parts, fmt.Sprintf("mark 0x%x", v))
+		}
+	case "pedit":
+		if len(parms) > tcGenLen {
+			parts = append(parts, fmt.Sprintf("keys %d", parms[tcGenLen]))
+		}
+	case "ct":
+		if v, ok := opts[tcaCtAction]; ok && len(v) >= 2 {
+			flags := binary.NativeEndian.Uint16(v)
+			for bit, name := range []string{"commit", "force", "clear", "nat"} {
+				if flags&(1<<bit) != 0 {
+					parts = append(parts, name)
+				}
+			}
+		}
+		if v, ok := opts[tcaCtZone]; ok && len(v) >= 2 {
+			parts = append(parts, fmt.Sprintf("zone %d", binary.NativeEndian.Uint16(v)))
+		}
+		if v, ok := u32(opts[tcaCtMark], 0); ok {
+			parts = append(parts, fmt.Sprintf("mark 0x%x", v))
+		}
+	case "nat":
+		// struct tc_nat: old_addr, new_addr and mask in network byte order, and the flags
+		if len(parms) >= tcGenLen+16 {
+			dir := "ingress"
+			if binary.NativeEndian.Uint32(parms[tcGenLen+12:tcGenLen+16])&1 != 0 {
+				dir = "egress"
+			}
+			old := netip.AddrFrom4([4]byte(parms[tcGenLen : tcGenLen+4]))
+			mask := binary.BigEndian.Uint32(parms[tcGenLen+8 : tcGenLen+12])
+			if !isPrefixMask(mask) {
+				break
+			}
+			prefix := netip.PrefixFrom(old, bits.OnesCount32(mask))
+			parts = append(parts, dir, prefix.String(), netip.AddrFrom4([4]byte(parms[tcGenLen+4:tcGenLen+8])).String())
+		}
+	case "vlan":
+		if v, ok := u32(parms, tcGenLen); ok && v >= 1 && v <= 5 {
+			parts = append(parts, []string{"pop", "push", "modify", "pop_eth", "push_eth"}[v-1])
+		}
+		if v, ok := opts[tcaVlanPushVlanID]; ok && len(v) >= 2 {
+			parts = append(parts, fmt.Sprintf("id %d", binary.NativeEndian.Uint16(v)))
+		}
+		if v, ok := opts[tcaVlanPushVlanProtocol]; ok && len(v) >= 2 {
+			parts = append(parts, "protocol "+protocolName(binary.BigEndian.Uint16(v)))
+		}
+		if v, ok := opts[tcaVlanPushVlanPriority]; ok && len(v) >= 1 {
+			parts = append(parts, fmt.Sprintf("priority %d", v[0]))
+		}
+	case "tunnel_key":
+		if v, ok := u32(parms, tcGenLen); ok && v >= 1 && v <= 2 {
+			parts = append(parts, []string{"set", "unset"}[v-1])
+		}
+		for _, addr := range []struct {
+			dir  string
+			attr uint16
+		}{
+			{"src", tcaTunnelKeyEncIPv4Src},
+			{"dst", tcaTunnelKeyEncIPv4Dst},
+			{"src", tcaTunnelKeyEncIPv6Src},
+			{"dst", tcaTunnelKeyEncIPv6Dst},
+		} {
+			if ip, ok := netip.AddrFromSlice(opts[addr.attr]); ok {
+				parts = append(parts, fmt.Sprintf("%s %s", addr.dir, ip))
+			}
+		}
+		if v, ok := opts[tcaTunnelKeyEncKeyID]; ok && len(v) >= 4 {
+			parts = append(parts, fmt.Sprintf("id %d", binary.BigEndian.Uint32(v)))
+		}
+		if v, ok := opts[tcaTunnelKeyEncDstPort]; ok && len(v) >= 2 {
+			parts = append(parts, fmt.Sprintf("dst_port %d", binary.BigEndian.Uint16(v)))
+		}
+	case "sample":
+		if v, ok := u32(opts[tcaSampleRate], 0); ok {
+			parts = append(parts, fmt.Sprintf("rate %d", v))
+		}
+		if v, ok := u32(opts[tcaSamplePsampleGroup], 0); ok {
+			parts = append(parts, fmt.Sprintf("group %d", v))
+		}
+	case "connmark":
+		if len(parms) >= tcGenLen+2 {
+			parts = append(parts, fmt.Sprintf("zone %d", binary.NativeEndian.Uint16(parms[tcGenLen:tcGenLen+2])))
+		}
+	case "csum":
+		if flags, ok := u32(parms, tcGenLen); ok {
+			for bit, name := range []string{"iph", "icmp", "igmp", "tcp", "udp", "udplite", "sctp"} {
+				if flags&(1<<bit) != 0 {
+					parts = append(parts, name)
+				}
+			}
+		}
+	}
+	return strings.Join(parts, " ")
+}
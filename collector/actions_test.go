@@ -0,0 +1,355 @@
+package tccollector_test
+
+import (
+	"encoding/binary"
+	"log/slog"
+	"os"
+	"testing"
+
+	tcexporter "github.com/fbegyn/tc_exporter/collector"
+	"github.com/mdlayher/netlink"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// tcGen encodes the struct tc_gen that starts the parameters of most actions, followed by the
+// fields of the action
+func tcGen(index uint32, action int32, refcnt, bindcnt uint32, extra ...byte) []byte {
+	b := make([]byte, 20, 20+len(extra))
+	binary.NativeEndian.PutUint32(b[0:], index)
+	binary.NativeEndian.PutUint32(b[8:], uint32(action))
+	binary.NativeEndian.PutUint32(b[12:], refcnt)
+	binary.NativeEndian.PutUint32(b[16:], bindcnt)
+	return append(b, extra...)
+}
+
+// tcPolice encodes a struct tc_police
+func tcPolice(index uint32, action int32, mtu, rate, peakrate, refcnt, bindcnt uint32) []byte {
+	b := make([]byte, 52)
+	binary.NativeEndian.PutUint32(b[0:], index)
+	binary.NativeEndian.PutUint32(b[4:], uint32(action))
+	binary.NativeEndian.PutUint32(b[16:], mtu)
+	binary.NativeEndian.PutUint32(b[28:], rate)
+	binary.NativeEndian.PutUint32(b[40:], peakrate)
+	binary.NativeEndian.PutUint32(b[44:], refcnt)
+	binary.NativeEndian.PutUint32(b[48:], bindcnt)
+	return b
+}
+
+// gnetQueue encodes a struct gnet_stats_queue with the drops and the overlimits
+func gnetQueue(drops, overlimits uint32) []byte {
+	return u32s(0, 0, drops, 0, overlimits)
+}
+
+// encodeActionMessage encodes the data of an action table dump message: a struct tcamsg and the
+// action table
+func encodeActionMessage(t *testing.T, acts ...testAction) []byte {
+	t.Helper()
+	ae := netlink.NewAttributeEncoder()
+	ae.Bytes(1, encodeActions(t, acts...))
+	b, err := ae.Encode()
+	if err != nil {
+		t.Fatalf("failed to encode action message: %v", err)
+	}
+	return append(make([]byte, 4), b...)
+}
+
+func TestActionCollector(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	col, err := tcexporter.NewActionCollector(nil, logger)
+	if err != nil {
+		t.Fatalf("failed to create action collector: %v", err)
+	}
+	names := map[uint32]string{3: "eth1"}
+
+	tests := []struct {
+		name    string
+		act     testAction
+		index   string
+		control string
+		config  string
+		want    map[string]float64
+		metrics int
+	}{
+		{
+			name: "police",
+			act: testAction{
+				kind:  "police",
+				index: 1,
+				options: func(ae *netlink.AttributeEncoder) {
+					ae.Bytes(1, tcPolice(1, 2, 1514, 125000, 250000, 2, 1))
+					// the 64 bit rate is only sent when the rate does not fit the rate spec
+					ae.Uint64(8, 1<<33)
+					ae.Bytes(6, tcfT(250))
+				},
+				basic: gnetBasic(15000, 10),
+				queue: gnetQueue(4, 6),
+			},
+			index:   "1",
+			control: "drop",
+			want: map[string]float64{
+				"tc_action_bind_count":            1,
+				"tc_action_ref_count":             2,
+				"tc_action_bytes":                 15000,
+				"tc_action_packets":               10,
+				"tc_action_drops":                 4,
+				"tc_action_overlimits":            6,
+				"tc_action_last_used_seconds":     2.5,
+				"tc_action_police_rate_bytes":     1 << 33,
+				"tc_action_police_peakrate_bytes": 250000,
+				"tc_action_police_mtu_bytes":      1514,
+			},
+			metrics: 11,
+		},
+		{
+			name: "mirred",
+			act: testAction{
+				kind:  "mirred",
+				index: 2,
+				options: func(ae *netlink.AttributeEncoder) {
+					ae.Bytes(2, tcGen(2, 4, 1, 1, u32s(1, 3)...))
+				},
+				basic:   gnetBasic(3000, 2),
+				basicHw: gnetBasic(1000, 1),
+			},
+			index:   "2",
+			control: "stolen",
+			config:  "egress redirect dev eth1",
+			want: map[string]float64{
+				"tc_action_bind_count": 1,
+				"tc_action_ref_count":  1,
+				"tc_action_bytes":      2000,
+				"tc_action_packets":    1,
+			},
+			metrics: 7,
+		},
+		{
+			name: "mirred to an unknown link",
+			act: testAction{
+				kind:  "mirred",
+				index: 3,
+				options: func(ae *netlink.AttributeEncoder) {
+					ae.Bytes(2, tcGen(3, 3, 1, 0, u32s(2, 7)...))
+				},
+			},
+			index:   "3",
+			control: "pipe",
+			config:  "egress mirror dev 7",
+			metrics: 5,
+		},
+		{
+			name: "gact",
+			act: testAction{
+				kind:  "gact",
+				index: 4,
+				options: func(ae *netlink.AttributeEncoder) {
+					ae.Bytes(2, tcGen(4, 0, 3, 2))
+					// struct tc_gact_p: determ, every 10th packet is dropped
+					prob := make([]byte, 8)
+					binary.NativeEndian.PutUint16(prob[0:], 2)
+					binary.NativeEndian.PutUint16(prob[2:], 10)
+					binary.NativeEndian.PutUint32(prob[4:], 2)
+					ae.Bytes(3, prob)
+				},
+			},
+			index:   "4",
+			control: "pass",
+			config:  "random type determ drop val 10",
+			want: map[string]float64{
+				"tc_action_bind_count": 2,
+				"tc_action_ref_count":  3,
+			},
+			metrics: 5,
+		},
+		{
+			// older kernels do not send the index attribute
+			name: "gact goto chain without index",
+			act: testAction{
+				kind: "gact",
+				options: func(ae *netlink.AttributeEncoder) {
+					ae.Bytes(2, tcGen(5, 0x20000000|7, 1, 1))
+				},
+			},
+			index:   "5",
+			control: "goto chain 7",
+			metrics: 5,
+		},
+		{
+			name: "nat",
+			act: testAction{
+				kind:  "nat",
+				index: 6,
+				options: func(ae *netlink.AttributeEncoder) {
+					parms := tcGen(6, 0, 1, 1, 10, 0, 0, 0, 192, 0, 2, 1, 255, 0, 0, 0)
+					ae.Bytes(1, append(parms, u32s(1)...))
+				},
+			},
+			index:   "6",
+			control: "pass",
+			config:  "egress 10.0.0.0/8 192.0.2.1",
+			metrics: 5,
+		},
+		{
+			name: "vlan",
+			act: testAction{
+				kind:  "vlan",
+				index: 7,
+				options: func(ae *netlink.AttributeEncoder) {
+					ae.Bytes(2, tcGen(7, 3, 1, 1, u32s(2)...))
+					ae.Uint16(3, 100)
+					ae.Bytes(4, []byte{0x81, 0x00})
+					ae.Uint8(6, 3)
+				},
+			},
+			index:   "7",
+			control: "pipe",
+			config:  "push id 100 protocol 802.1Q priority 3",
+			metrics: 5,
+		},
+		{
+			name: "tunnel_key",
+			act: testAction{
+				kind:  "tunnel_key",
+				index: 8,
+				options: func(ae *netlink.AttributeEncoder) {
+					ae.Bytes(2, tcGen(8, 3, 1, 1, u32s(1)...))
+					ae.Bytes(3, []byte{192, 0, 2, 1})
+					ae.Bytes(4, []byte{192, 0, 2, 2})
+					ae.Bytes(7, []byte{0, 0, 0, 42})
+					ae.Bytes(9, []byte{0x12, 0xb5})
+				},
+			},
+			index:   "8",
+			control: "pipe",
+			config:  "set src 192.0.2.1 dst 192.0.2.2 id 42 dst_port 4789",
+			metrics: 5,
+		},
+		{
+			name: "tunnel_key unset",
+			act: testAction{
+				kind:  "tunnel_key",
+				index: 9,
+				options: func(ae *netlink.AttributeEncoder) {
+					ae.Bytes(2, tcGen(9, 3, 1, 1, u32s(2)...))
+				},
+			},
+			index:   "9",
+			control: "pipe",
+			config:  "unset",
+			metrics: 5,
+		},
+		{
+			name: "police parameters too short",
+			act: testAction{
+				kind:  "police",
+				index: 10,
+				options: func(ae *netlink.AttributeEncoder) {
+					ae.Bytes(1, tcGen(10, 2, 1, 1))
+				},
+			},
+			metrics: 0,
+		},
+		{
+			name:    "no parameters",
+			act:     testAction{kind: "mirred", index: 11},
+			metrics: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := encodeActionMessage(t, tt.act)
+			metrics := collectMetrics(t, func(ch chan<- prometheus.Metric) {
+				if err := tcexporter.CollectActionMessages(col, ch, "host", "default", names, msg); err != nil {
+					t.Errorf("failed to parse action message: %v", err)
+				}
+			})
+			if len(metrics) != tt.metrics {
+				t.Fatalf("unexpected number of metrics: got %d, want %d", len(metrics), tt.metrics)
+			}
+			if tt.metrics == 0 {
+				return
+			}
+			labels := map[string]string{"kind": tt.act.kind, "index": tt.index}
+			info := map[string]string{"kind": tt.act.kind, "index": tt.index, "control": tt.control, "config": tt.config}
+			if _, ok := findMetric(metrics, "tc_action_info", info); !ok {
+				t.Fatalf("missing info with control %q and config %q: %v", tt.control, tt.config, metrics)
+			}
+			for name, want := range tt.want {
+				l := labels
+				if name == "tc_action_bytes" || name == "tc_action_packets" {
+					l = map[string]string{"kind": tt.act.kind, "index": tt.index, "source": "sw"}
+				}
+				got, ok := findMetric(metrics, name, l)
+				if !ok || got != want {
+					t.Fatalf("unexpected %s: got %v (%t), want %v", name, got, ok, want)
+				}
+			}
+		})
+	}
+}
+
+func TestActionMessages(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	col, err := tcexporter.NewActionCollector(nil, logger)
+	if err != nil {
+		t.Fatalf("failed to create action collector: %v", err)
+	}
+	gact := func(index uint32) testAction {
+		return testAction{
+			kind:  "gact",
+			index: index,
+			options: func(ae *netlink.AttributeEncoder) {
+				ae.Bytes(2, tcGen(index, 2, 1, 0))
+			},
+		}
+	}
+	// a message without an action table
+	empty := make([]byte, 4)
+
+	tests := []struct {
+		name    string
+		msgs    [][]byte
+		indexes []string
+		err     bool
+	}{
+		{
+			name:    "several messages",
+			msgs:    [][]byte{encodeActionMessage(t, gact(1), gact(2)), empty, encodeActionMessage(t, gact(3))},
+			indexes: []string{"1", "2", "3"},
+		},
+		{
+			name: "message too short",
+			msgs: [][]byte{encodeActionMessage(t, gact(1)), {0, 0}},
+			err:  true,
+		},
+		{
+			name: "truncated attributes",
+			msgs: [][]byte{append(make([]byte, 4), 8, 0, 1, 0)},
+			err:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			metrics := collectMetrics(t, func(ch chan<- prometheus.Metric) {
+				err = tcexporter.CollectActionMessages(col, ch, "host", "default", nil, tt.msgs...)
+			})
+			if (err != nil) != tt.err {
+				t.Fatalf("unexpected error: got %v, want error %t", err, tt.err)
+			}
+			if tt.err {
+				if len(metrics) != 0 {
+					t.Fatalf("expected no metrics for a broken dump, got %d", len(metrics))
+				}
+				return
+			}
+			if len(metrics) != 5*len(tt.indexes) {
+				t.Fatalf("unexpected number of metrics: got %d, want %d", len(metrics), 5*len(tt.indexes))
+			}
+			for _, index := range tt.indexes {
+				if _, ok := findMetric(metrics, "tc_action_info", map[string]string{"kind": "gact", "index": index, "control": "drop"}); !ok {
+					t.Fatalf("missing gact action %s", index)
+				}
+			}
+		})
+	}
+}
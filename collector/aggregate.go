@@ -0,0 +1,261 @@
+package tccollector
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+
+	"github.com/florianl/go-tc"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	aggregateLabels []string = []string{"host", "netns", "linkindex", "link", "type", "mode", "group"}
+)
+
+// AggregationConfig configures the aggregation of classes into groups
+type AggregationConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Mode is parent, depth or name
+	Mode string `mapstructure:"mode"`
+	// Depth is the depth in the tree the classes are grouped on in depth mode
+	Depth int `mapstructure:"depth"`
+	// Pattern is the regular expression matched against the class names in name mode
+	Pattern string `mapstructure:"pattern"`
+	// Keep are the handles of the classes that are still exported one by one
+	Keep []string `mapstructure:"keep"`
+}
+
+// groupKey identifies a group of aggregated classes on an interface
+type groupKey struct {
+	kind  string
+	group string
+}
+
+type groupStats struct {
+	objects int
+	stats   objectStats
+}
+
+// Aggregator sums the classes of an interface into groups, so hosts with a class per subscriber
+// do not export a series per class. Only the kept classes are passed to the class collectors.
+type Aggregator struct {
+	logger   slog.Logger
+	mode     string
+	depth    int
+	pattern  *regexp.Regexp
+	names    *NameResolver
+	keep     map[uint32]bool
+	objects  *prometheus.Desc
+	counters stats
+}
+
+// NewAggregator creates an Aggregator, the NameResolver is only used in name mode
+func NewAggregator(cfg AggregationConfig, names *NameResolver, log *slog.Logger) (*Aggregator, error) {
+	log = log.With("collector", "aggregation")
+	log.Info("making class aggregator", "mode", cfg.Mode)
+
+	agg := &Aggregator{
+		logger: *log,
+		mode:   cfg.Mode,
+		depth:  cfg.Depth,
+		names:  names,
+		keep:   make(map[uint32]bool, len(cfg.Keep)),
+	}
+	switch cfg.Mode {
+	case "":
+		agg.mode = "parent"
+	case "parent":
+	case "depth":
+		if cfg.Depth < 1 {
+			return nil, fmt.Errorf("aggregation depth must be at least 1, got %d", cfg.Depth)
+		}
+	case "name":
+		pattern, err := regexp.Compile(cfg.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid aggregation pattern %q: %w", cfg.Pattern, err)
+		}
+		agg.pattern = pattern
+	default:
+		return nil, fmt.Errorf("invalid aggregation mode %q", cfg.Mode)
+	}
+	for _, h := range cfg.Keep {
+		handle, err := ParseHandle(h)
+		if err != nil {
+			return nil, err
+		}
+		agg.keep[handle] = true
+	}
+
+	agg.objects = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "aggregated_objects"),
+		"Number of classes that are summed into the group",
+		aggregateLabels, nil,
+	)
+	agg.counters = stats{
+		bytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "class_aggregated", "bytes_total"),
+			"Byte counter of the aggregated classes",
+			aggregateLabels, nil,
+		),
+		packets: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "class_aggregated", "packets_total"),
+			"Packet counter of the aggregated classes",
+			aggregateLabels, nil,
+		),
+		drops: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "class_aggregated", "drops_total"),
+			"Drops of the aggregated classes",
+			aggregateLabels, nil,
+		),
+		overlimits: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "class_aggregated", "overlimits_total"),
+			"Overlimits of the aggregated classes",
+			aggregateLabels, nil,
+		),
+		requeues: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "class_aggregated", "requeues_total"),
+			"Requeues of the aggregated classes",
+			aggregateLabels, nil,
+		),
+		qlen: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "class_aggregated", "qlen"),
+			"Queue length of the aggregated classes",
+			aggregateLabels, nil,
+		),
+		backlog: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "class_aggregated", "backlog_bytes"),
+			"Backlog of the aggregated classes",
+			aggregateLabels, nil,
+		),
+	}
+	return agg, nil
+}
+
+// Describe implements Collector
+func (agg *Aggregator) Describe(ch chan<- *prometheus.Desc) {
+	ds := []*prometheus.Desc{
+		agg.objects,
+		agg.counters.bytes,
+		agg.counters.packets,
+		agg.counters.drops,
+		agg.counters.overlimits,
+		agg.counters.requeues,
+		agg.counters.qlen,
+		agg.counters.backlog,
+	}
+
+	for _, d := range ds {
+		ch <- d
+	}
+}
+
+// Group returns the group the class is summed into. ok is false for classes that have to be
+// exported one by one: the kept classes, classes at or above the depth in depth mode and classes
+// whose name does not match in name mode.
+func (agg *Aggregator) Group(cl tc.Object, ancestors []uint32) (string, bool) {
+	if agg.keep[cl.Handle] {
+		return "", false
+	}
+	switch agg.mode {
+	case "depth":
+		// ancestors are nearest first, the path from the top has to leave out root and ingress
+		var path []uint32
+		for i := len(ancestors) - 1; i >= 0; i-- {
+			if ancestors[i] != tc.HandleRoot && ancestors[i] != tc.HandleIngress {
+				path = append(path, ancestors[i])
+			}
+		}
+		if len(path) <= agg.depth {
+			return "", false
+		}
+		return FmtTcHandle(path[agg.depth]), true
+	case "name":
+		if agg.names == nil {
+			return "", false
+		}
+		name, found := agg.names.Name(cl.Handle)
+		if !found {
+			return "", false
+		}
+		match := agg.pattern.FindStringSubmatch(name)
+		switch {
+		case match == nil:
+			return "", false
+		case len(match) > 1:
+			return match[1], true
+		default:
+			return match[0], true
+		}
+	default:
+		if len(ancestors) == 0 {
+			return FmtTcHandle(cl.Parent), true
+		}
+		return FmtTcHandle(ancestors[0]), true
+	}
+}
+
+// Fold sums the class into its group, ok is false when the class has to be exported one by one.
+// In depth mode the inner classes below the depth are folded without being summed, their counters
+// already include the traffic of the classes below them. In name mode the inner classes with a
+// matching name are exported one by one for the same reason.
+func (agg *Aggregator) Fold(groups map[groupKey]*groupStats, cl tc.Object, ancestors []uint32, leaf bool) bool {
+	group, ok := agg.Group(cl, ancestors)
+	if !ok {
+		return false
+	}
+	if !leaf {
+		switch agg.mode {
+		case "depth":
+			return true
+		case "name":
+			return false
+		}
+	}
+	key := groupKey{kind: cl.Kind, group: group}
+	if groups[key] == nil {
+		groups[key] = &groupStats{}
+	}
+	groups[key].add(cl)
+	return true
+}
+
+// CollectInterface exports the sums of the groups the classes of the interface were folded into
+func (agg *Aggregator) CollectInterface(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, groups map[groupKey]*groupStats) {
+	for key, g := range groups {
+		labels := []string{
+			host,
+			ns,
+			fmt.Sprintf("%d", interf.Index),
+			interf.Attributes.Name,
+			key.kind,
+			agg.mode,
+			key.group,
+		}
+		ch <- prometheus.MustNewConstMetric(agg.objects, prometheus.GaugeValue, float64(g.objects), labels...)
+		ch <- prometheus.MustNewConstMetric(agg.counters.bytes, prometheus.CounterValue, g.stats.bytes, labels...)
+		ch <- prometheus.MustNewConstMetric(agg.counters.packets, prometheus.CounterValue, g.stats.packets, labels...)
+		ch <- prometheus.MustNewConstMetric(agg.counters.drops, prometheus.CounterValue, g.stats.drops, labels...)
+		ch <- prometheus.MustNewConstMetric(agg.counters.overlimits, prometheus.CounterValue, g.stats.overlimits, labels...)
+		ch <- prometheus.MustNewConstMetric(agg.counters.requeues, prometheus.CounterValue, g.stats.requeues, labels...)
+		ch <- prometheus.MustNewConstMetric(agg.counters.qlen, prometheus.GaugeValue, g.stats.qlen, labels...)
+		ch <- prometheus.MustNewConstMetric(agg.counters.backlog, prometheus.GaugeValue, g.stats.backlog, labels...)
+	}
+}
+
+// add folds the stats of the class into the group
+func (g *groupStats) add(cl tc.Object) {
+	g.objects++
+	s, ok := readStats(cl)
+	if !ok {
+		return
+	}
+	g.stats.bytes += s.bytes
+	g.stats.packets += s.packets
+	g.stats.drops += s.drops
+	g.stats.overlimits += s.overlimits
+	g.stats.requeues += s.requeues
+	g.stats.qlen += s.qlen
+	g.stats.backlog += s.backlog
+}
@@ -0,0 +1,197 @@
+package tccollector_test
+
+import (
+	"log/slog"
+	"os"
+	"slices"
+	"testing"
+
+	tcexporter "github.com/fbegyn/tc_exporter/collector"
+	"github.com/florianl/go-tc"
+	"github.com/florianl/go-tc/core"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestAggregatorGroup(t *testing.T) {
+	qdiscs, classes := testTree()
+	tree := tcexporter.NewObjectTree(qdiscs, classes)
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	names, err := tcexporter.NewNameResolver(tcexporter.NamesConfig{
+		File:    "/nonexistent/tc_cls",
+		Handles: map[string]string{"1:10": "plan-gold-1", "1:20": "uplink"},
+	}, logger)
+	if err != nil {
+		t.Fatalf("failed to create name resolver: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		cfg     tcexporter.AggregationConfig
+		class   int
+		group   string
+		grouped bool
+	}{
+		{name: "parent", cfg: tcexporter.AggregationConfig{Mode: "parent"},
+			class: 1, group: "1:1", grouped: true},
+		{name: "parent of top level class", cfg: tcexporter.AggregationConfig{},
+			class: 0, group: "1:", grouped: true},
+		{name: "keep", cfg: tcexporter.AggregationConfig{Mode: "parent", Keep: []string{"1:10"}},
+			class: 1, grouped: false},
+		{name: "depth", cfg: tcexporter.AggregationConfig{Mode: "depth", Depth: 1},
+			class: 2, group: "1:1", grouped: true},
+		{name: "depth above", cfg: tcexporter.AggregationConfig{Mode: "depth", Depth: 1},
+			class: 0, grouped: false},
+		{name: "name submatch", cfg: tcexporter.AggregationConfig{Mode: "name", Pattern: `^plan-(\w+)-`},
+			class: 1, group: "gold", grouped: true},
+		{name: "name no match", cfg: tcexporter.AggregationConfig{Mode: "name", Pattern: `^plan-(\w+)-`},
+			class: 2, grouped: false},
+		{name: "name missing", cfg: tcexporter.AggregationConfig{Mode: "name", Pattern: `^plan-(\w+)-`},
+			class: 0, grouped: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agg, err := tcexporter.NewAggregator(tt.cfg, names, logger)
+			if err != nil {
+				t.Fatalf("failed to create aggregator: %v", err)
+			}
+			cl := classes[tt.class]
+			group, grouped := agg.Group(cl, tree.Ancestors(cl.Handle, cl.Parent))
+			if grouped != tt.grouped || group != tt.group {
+				t.Fatalf("unexpected group: got %q (%t), want %q (%t)", group, grouped, tt.group, tt.grouped)
+			}
+		})
+	}
+}
+
+func TestAggregatorInvalid(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	tests := []struct {
+		name string
+		cfg  tcexporter.AggregationConfig
+	}{
+		{name: "mode", cfg: tcexporter.AggregationConfig{Mode: "kind"}},
+		{name: "depth", cfg: tcexporter.AggregationConfig{Mode: "depth"}},
+		{name: "pattern", cfg: tcexporter.AggregationConfig{Mode: "name", Pattern: "plan-("}},
+		{name: "keep", cfg: tcexporter.AggregationConfig{Keep: []string{"10"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tcexporter.NewAggregator(tt.cfg, nil, logger); err == nil {
+				t.Fatalf("expected an error for %+v", tt.cfg)
+			}
+		})
+	}
+}
+
+func TestAggregatorDepthSums(t *testing.T) {
+	// a customer class with two leaves below a top level class, next to a leaf customer
+	//
+	//	root -> 1: htb -> 1:1 -> 1:10 -> 1:100
+	//	                              -> 1:101
+	//	                       -> 1:20
+	class := func(handle, parent uint32, bytes uint64) tc.Object {
+		return tc.Object{
+			Msg:       tc.Msg{Handle: handle, Parent: parent},
+			Attribute: tc.Attribute{Kind: "htb", Stats: &tc.Stats{Bytes: bytes}},
+		}
+	}
+	qdiscs := []tc.Object{{Msg: tc.Msg{Handle: core.BuildHandle(1, 0), Parent: tc.HandleRoot}, Attribute: tc.Attribute{Kind: "htb"}}}
+	classes := []tc.Object{
+		class(core.BuildHandle(1, 1), tc.HandleRoot, 1000),
+		class(core.BuildHandle(1, 0x10), core.BuildHandle(1, 1), 600),
+		class(core.BuildHandle(1, 0x100), core.BuildHandle(1, 0x10), 400),
+		class(core.BuildHandle(1, 0x101), core.BuildHandle(1, 0x10), 200),
+		class(core.BuildHandle(1, 0x20), core.BuildHandle(1, 1), 400),
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	agg, err := tcexporter.NewAggregator(tcexporter.AggregationConfig{Mode: "depth", Depth: 1}, nil, logger)
+	if err != nil {
+		t.Fatalf("failed to create aggregator: %v", err)
+	}
+	interf := rtnetlink.LinkMessage{Index: 2, Attributes: &rtnetlink.LinkAttributes{Name: "eth0"}}
+	metrics := collectMetrics(t, func(ch chan<- prometheus.Metric) {
+		agg.CollectClasses(ch, "host", "default", interf, qdiscs, classes)
+	})
+
+	// the inner class 1:10 is not summed, its counters already hold those of its leaves
+	group := map[string]string{"group": "1:1"}
+	if bytes, ok := findMetric(metrics, "tc_class_aggregated_bytes_total", group); !ok || bytes != 1000 {
+		t.Fatalf("unexpected bytes of the group: got %v (%t), want 1000", bytes, ok)
+	}
+	if objects, ok := findMetric(metrics, "tc_aggregated_objects", group); !ok || objects != 3 {
+		t.Fatalf("unexpected number of classes in the group: got %v (%t), want 3", objects, ok)
+	}
+}
+
+func TestAggregatorNameSums(t *testing.T) {
+	// a customer class with two leaves that match the same group as the customer class itself
+	//
+	//	root -> 1: htb -> 1:1 -> 1:10 (plan-gold-a) -> 1:100 (plan-gold-b)
+	//	                                           -> 1:101 (plan-gold-c)
+	//	                       -> 1:20 (plan-silver-a)
+	class := func(handle, parent uint32, bytes uint64) tc.Object {
+		return tc.Object{
+			Msg:       tc.Msg{Handle: handle, Parent: parent},
+			Attribute: tc.Attribute{Kind: "htb", Stats: &tc.Stats{Bytes: bytes}},
+		}
+	}
+	qdiscs := []tc.Object{{Msg: tc.Msg{Handle: core.BuildHandle(1, 0), Parent: tc.HandleRoot}, Attribute: tc.Attribute{Kind: "htb"}}}
+	classes := []tc.Object{
+		class(core.BuildHandle(1, 1), tc.HandleRoot, 1000),
+		class(core.BuildHandle(1, 0x10), core.BuildHandle(1, 1), 600),
+		class(core.BuildHandle(1, 0x100), core.BuildHandle(1, 0x10), 400),
+		class(core.BuildHandle(1, 0x101), core.BuildHandle(1, 0x10), 200),
+		class(core.BuildHandle(1, 0x20), core.BuildHandle(1, 1), 400),
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	names, err := tcexporter.NewNameResolver(tcexporter.NamesConfig{
+		File: "/nonexistent/tc_cls",
+		Handles: map[string]string{
+			"1:10":  "plan-gold-a",
+			"1:100": "plan-gold-b",
+			"1:101": "plan-gold-c",
+			"1:20":  "plan-silver-a",
+		},
+	}, logger)
+	if err != nil {
+		t.Fatalf("failed to create name resolver: %v", err)
+	}
+	agg, err := tcexporter.NewAggregator(tcexporter.AggregationConfig{Mode: "name", Pattern: `^plan-(\w+)-`}, names, logger)
+	if err != nil {
+		t.Fatalf("failed to create aggregator: %v", err)
+	}
+	interf := rtnetlink.LinkMessage{Index: 2, Attributes: &rtnetlink.LinkAttributes{Name: "eth0"}}
+	var single []uint32
+	metrics := collectMetrics(t, func(ch chan<- prometheus.Metric) {
+		single = agg.CollectClasses(ch, "host", "default", interf, qdiscs, classes)
+	})
+
+	// the inner class 1:10 is not summed with its leaves, its counters already hold theirs
+	tests := []struct {
+		group   string
+		bytes   float64
+		objects float64
+	}{
+		{group: "gold", bytes: 600, objects: 2},
+		{group: "silver", bytes: 400, objects: 1},
+	}
+	for _, tt := range tests {
+		group := map[string]string{"group": tt.group}
+		if bytes, ok := findMetric(metrics, "tc_class_aggregated_bytes_total", group); !ok || bytes != tt.bytes {
+			t.Fatalf("unexpected bytes of group %s: got %v (%t), want %v", tt.group, bytes, ok, tt.bytes)
+		}
+		if objects, ok := findMetric(metrics, "tc_aggregated_objects", group); !ok || objects != tt.objects {
+			t.Fatalf("unexpected number of classes in group %s: got %v (%t), want %v", tt.group, objects, ok, tt.objects)
+		}
+	}
+	want := []uint32{core.BuildHandle(1, 1), core.BuildHandle(1, 0x10)}
+	if !slices.Equal(single, want) {
+		t.Fatalf("unexpected classes exported one by one: got %x, want %x", single, want)
+	}
+}
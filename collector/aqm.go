@@ -0,0 +1,193 @@
+package tccollector
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/florianl/go-tc"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	aqmLabels     []string = []string{"host", "netns", "linkindex", "link", "type", "handle", "parent"}
+	aqmDropLabels []string = []string{"host", "netns", "linkindex", "link", "type", "handle", "parent", "reason"}
+)
+
+// AqmCollector exports the drops, ECN marks and queue delay of the AQM qdiscs (codel, fq_codel,
+// pie, red, choke and sfb) under the same metric names, so they can be compared regardless of the
+// AQM that is in use. The drop reasons are normalized to:
+//   - aqm: dropped by the AQM algorithm itself (early or probabilistic drops)
+//   - overlimit: dropped because the queue was full
+//   - overmemory: dropped because the memory limit was reached
+//   - other: dropped for other reasons
+//
+// and a few reasons that only exist for a single AQM (matched for choke, bucket, penalty and
+// child for sfb).
+type AqmCollector struct {
+	logger   slog.Logger
+	netns    map[string][]rtnetlink.LinkMessage
+	drops    *prometheus.Desc
+	ecnMarks *prometheus.Desc
+	delay    *prometheus.Desc
+}
+
+// NewAqmCollector create a new AqmCollector given a network interface
+func NewAqmCollector(netns map[string][]rtnetlink.LinkMessage, log *slog.Logger) (ObjectCollector, error) {
+	// Setup logger for qdisc collector
+	log = log.With("collector", "aqm")
+	log.Info("making aqm collector")
+
+	return &AqmCollector{
+		logger: *log,
+		netns:  netns,
+		drops: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "aqm", "drops_total"),
+			"AQM drops by reason",
+			aqmDropLabels, nil,
+		),
+		ecnMarks: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "aqm", "ecn_marks_total"),
+			"AQM ECN marks",
+			aqmLabels, nil,
+		),
+		delay: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "aqm", "queue_delay_seconds"),
+			"AQM queue delay",
+			aqmLabels, nil,
+		),
+	}, nil
+}
+
+// Describe implements Collector
+func (col *AqmCollector) Describe(ch chan<- *prometheus.Desc) {
+	ds := []*prometheus.Desc{
+		col.drops,
+		col.ecnMarks,
+		col.delay,
+	}
+
+	for _, d := range ds {
+		ch <- d
+	}
+}
+
+// CollectObject fetches and updates the data the collector is exporting
+func (col *AqmCollector) CollectObject(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, qd tc.Object) {
+	if qd.XStats == nil {
+		return
+	}
+	handleMaj, handleMin := HandleStr(qd.Handle)
+	parentMaj, parentMin := HandleStr(qd.Parent)
+
+	var totalDrops uint32
+	if qd.Stats2 != nil {
+		totalDrops = qd.Stats2.Drops
+	} else if qd.Stats != nil {
+		totalDrops = qd.Stats.Drops
+	}
+
+	drops := map[string]uint32{}
+	var marks uint32
+	var delay *uint32
+	switch {
+	case qd.Kind == "codel" && qd.XStats.Codel != nil:
+		xs := qd.XStats.Codel
+		drops["overlimit"] = xs.DropOverlimit
+		drops["aqm"] = subClamp(totalDrops, xs.DropOverlimit)
+		marks = xs.EcnMark + xs.CeMark
+		delay = &xs.LDelay
+	case qd.Kind == "fq_codel" && qd.XStats.FqCodel != nil && qd.XStats.FqCodel.Qd != nil:
+		xs := qd.XStats.FqCodel.Qd
+		// the drops over the memory limit are counted in the overlimit drops as well
+		drops["overlimit"] = subClamp(xs.DropOverlimit, xs.DropOvermemory)
+		drops["overmemory"] = xs.DropOvermemory
+		drops["aqm"] = subClamp(totalDrops, xs.DropOverlimit)
+		marks = xs.EcnMark + xs.CeMark
+	case qd.Kind == "pie" && qd.XStats.Pie != nil:
+		xs := qd.XStats.Pie
+		drops["aqm"] = xs.Dropped
+		drops["overlimit"] = xs.Overlimit
+		marks = xs.EcnMark
+		delay = &xs.Delay
+	case qd.Kind == "red" && qd.XStats.Red != nil:
+		xs := qd.XStats.Red
+		drops["aqm"] = xs.Early
+		drops["overlimit"] = xs.PDrop
+		drops["other"] = xs.Other
+		marks = xs.Marked
+	case qd.Kind == "choke" && qd.XStats.Choke != nil:
+		xs := qd.XStats.Choke
+		drops["aqm"] = xs.Early
+		drops["overlimit"] = xs.PDrop
+		drops["other"] = xs.Other
+		drops["matched"] = xs.Matched
+		marks = xs.Marked
+	case qd.Kind == "sfb" && qd.XStats.Sfb != nil:
+		xs := qd.XStats.Sfb
+		drops["aqm"] = xs.EarlyDrop
+		drops["overlimit"] = xs.QueueDrop
+		drops["bucket"] = xs.BucketDrop
+		drops["penalty"] = xs.PenaltyDrop
+		drops["child"] = xs.ChildDrop
+		marks = xs.Marked
+	default:
+		return
+	}
+
+	for reason, value := range drops {
+		ch <- prometheus.MustNewConstMetric(
+			col.drops,
+			prometheus.CounterValue,
+			float64(value),
+			host,
+			ns,
+			fmt.Sprintf("%d", interf.Index),
+			interf.Attributes.Name,
+			qd.Kind,
+			fmt.Sprintf("%x:%x", handleMaj, handleMin),
+			fmt.Sprintf("%x:%x", parentMaj, parentMin),
+			reason,
+		)
+	}
+	ch <- prometheus.MustNewConstMetric(
+		col.ecnMarks,
+		prometheus.CounterValue,
+		float64(marks),
+		host,
+		ns,
+		fmt.Sprintf("%d", interf.Index),
+		interf.Attributes.Name,
+		qd.Kind,
+		fmt.Sprintf("%x:%x", handleMaj, handleMin),
+		fmt.Sprintf("%x:%x", parentMaj, parentMin),
+	)
+	// codel and pie report the delay in microseconds
+	if delay != nil {
+		ch <- prometheus.MustNewConstMetric(
+			col.delay,
+			prometheus.GaugeValue,
+			float64(*delay)/1e6,
+			host,
+			ns,
+			fmt.Sprintf("%d", interf.Index),
+			interf.Attributes.Name,
+			qd.Kind,
+			fmt.Sprintf("%x:%x", handleMaj, handleMin),
+			fmt.Sprintf("%x:%x", parentMaj, parentMin),
+		)
+	}
+}
+
+// subClamp subtracts b from a without wrapping around below zero
+func subClamp(a, b uint32) uint32 {
+	if b > a {
+		return 0
+	}
+	return a - b
+}
+
+// counters implements counterCollector
+func (col *AqmCollector) counters() []*prometheus.Desc {
+	return []*prometheus.Desc{col.drops, col.ecnMarks}
+}
@@ -0,0 +1,146 @@
+package tccollector_test
+
+import (
+	"log/slog"
+	"os"
+	"testing"
+
+	tcexporter "github.com/fbegyn/tc_exporter/collector"
+	"github.com/florianl/go-tc"
+	"github.com/florianl/go-tc/core"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestAqmCollector(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	col, err := tcexporter.NewAqmCollector(nil, logger)
+	if err != nil {
+		t.Fatalf("failed to create aqm collector: %v", err)
+	}
+	interf := rtnetlink.LinkMessage{Index: 2, Attributes: &rtnetlink.LinkAttributes{Name: "eth0"}}
+
+	tests := []struct {
+		name    string
+		kind    string
+		drops   uint32
+		xstats  *tc.XStats
+		want    map[string]float64
+		marks   float64
+		delay   float64
+		metrics int
+	}{
+		{
+			name:    "codel",
+			kind:    "codel",
+			drops:   50,
+			xstats:  &tc.XStats{Codel: &tc.CodelXStats{DropOverlimit: 20, EcnMark: 3, CeMark: 4, LDelay: 5000}},
+			want:    map[string]float64{"overlimit": 20, "aqm": 30},
+			marks:   7,
+			delay:   0.005,
+			metrics: 4,
+		},
+		{
+			// the memory drops are part of the overlimit drops
+			name:    "fq_codel",
+			kind:    "fq_codel",
+			drops:   100,
+			xstats:  &tc.XStats{FqCodel: &tc.FqCodelXStats{Qd: &tc.FqCodelQdStats{DropOverlimit: 40, DropOvermemory: 15, EcnMark: 2, CeMark: 1}}},
+			want:    map[string]float64{"overlimit": 25, "overmemory": 15, "aqm": 60},
+			marks:   3,
+			metrics: 4,
+		},
+		{
+			name:    "fq_codel only memory drops",
+			kind:    "fq_codel",
+			drops:   10,
+			xstats:  &tc.XStats{FqCodel: &tc.FqCodelXStats{Qd: &tc.FqCodelQdStats{DropOverlimit: 10, DropOvermemory: 10}}},
+			want:    map[string]float64{"overlimit": 0, "overmemory": 10, "aqm": 0},
+			metrics: 4,
+		},
+		{
+			name:    "fq_codel counters ahead of the drops",
+			kind:    "fq_codel",
+			drops:   5,
+			xstats:  &tc.XStats{FqCodel: &tc.FqCodelXStats{Qd: &tc.FqCodelQdStats{DropOverlimit: 8}}},
+			want:    map[string]float64{"overlimit": 8, "overmemory": 0, "aqm": 0},
+			metrics: 4,
+		},
+		{
+			name:    "pie",
+			kind:    "pie",
+			drops:   30,
+			xstats:  &tc.XStats{Pie: &tc.PieXStats{Dropped: 12, Overlimit: 18, EcnMark: 6, Delay: 2500}},
+			want:    map[string]float64{"aqm": 12, "overlimit": 18},
+			marks:   6,
+			delay:   0.0025,
+			metrics: 4,
+		},
+		{
+			name:    "red",
+			kind:    "red",
+			xstats:  &tc.XStats{Red: &tc.RedXStats{Early: 7, PDrop: 3, Other: 1, Marked: 9}},
+			want:    map[string]float64{"aqm": 7, "overlimit": 3, "other": 1},
+			marks:   9,
+			metrics: 4,
+		},
+		{
+			name:    "choke",
+			kind:    "choke",
+			xstats:  &tc.XStats{Choke: &tc.ChokeXStats{Early: 7, PDrop: 3, Other: 1, Matched: 4, Marked: 2}},
+			want:    map[string]float64{"aqm": 7, "overlimit": 3, "other": 1, "matched": 4},
+			marks:   2,
+			metrics: 5,
+		},
+		{
+			name:    "sfb",
+			kind:    "sfb",
+			xstats:  &tc.XStats{Sfb: &tc.SfbXStats{EarlyDrop: 5, QueueDrop: 4, BucketDrop: 3, PenaltyDrop: 2, ChildDrop: 1, Marked: 8}},
+			want:    map[string]float64{"aqm": 5, "overlimit": 4, "bucket": 3, "penalty": 2, "child": 1},
+			marks:   8,
+			metrics: 6,
+		},
+		{
+			name:    "fq_codel without qdisc stats",
+			kind:    "fq_codel",
+			xstats:  &tc.XStats{FqCodel: &tc.FqCodelXStats{}},
+			metrics: 0,
+		},
+		{
+			name:    "no xstats",
+			kind:    "codel",
+			metrics: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qd := tc.Object{
+				Msg:       tc.Msg{Handle: core.BuildHandle(1, 0), Parent: tc.HandleRoot},
+				Attribute: tc.Attribute{Kind: tt.kind, Stats2: &tc.Stats2{Drops: tt.drops}, XStats: tt.xstats},
+			}
+			metrics := collectMetrics(t, func(ch chan<- prometheus.Metric) {
+				col.CollectObject(ch, "host", "default", interf, qd)
+			})
+			if len(metrics) != tt.metrics {
+				t.Fatalf("unexpected number of metrics: got %d, want %d", len(metrics), tt.metrics)
+			}
+			if tt.metrics == 0 {
+				return
+			}
+			for reason, want := range tt.want {
+				got, ok := findMetric(metrics, "tc_aqm_drops_total", map[string]string{"type": tt.kind, "reason": reason})
+				if !ok || got != want {
+					t.Fatalf("unexpected %s drops: got %v (%t), want %v", reason, got, ok, want)
+				}
+			}
+			if got, ok := findMetric(metrics, "tc_aqm_ecn_marks_total", nil); !ok || got != tt.marks {
+				t.Fatalf("unexpected ecn marks: got %v (%t), want %v", got, ok, tt.marks)
+			}
+			if tt.delay != 0 {
+				if got, ok := findMetric(metrics, "tc_aqm_queue_delay_seconds", nil); !ok || got != tt.delay {
+					t.Fatalf("unexpected delay: got %v (%t), want %v", got, ok, tt.delay)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package tccollector
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// cls_bpf and act_bpf netlink attributes
+const (
+	tcaBpfName  = 7
+	tcaBpfFlags = 8
+	tcaBpfTag   = 10
+	tcaBpfID    = 11
+
+	tcaActBpfName = 6
+	tcaActBpfTag  = 8
+	tcaActBpfID   = 9
+
+	tcaBpfFlagActDirect = 1 << 0
+)
+
+// the offsets of the stats in struct bpf_prog_info
+const (
+	bpfProgInfoRunTimeNs = 192
+	bpfProgInfoRunCnt    = 200
+	bpfProgInfoLen       = 208
+)
+
+// bpfStatsEnabledPath is the sysctl that enables the run time accounting of the BPF programs
+const bpfStatsEnabledPath = "/proc/sys/kernel/bpf_stats_enabled"
+
+// bpfProgram is a BPF program attached by a filter or an action
+type bpfProgram struct {
+	// attach is classifier for cls_bpf and action for act_bpf
+	attach       string
+	id           uint32
+	name         string
+	tag          string
+	directAction string
+}
+
+// filterBpfPrograms returns the BPF programs of the filter, of the classifier itself and of its
+// bpf actions. The same program can be attached by several actions of the filter, it is only
+// returned once.
+func filterBpfPrograms(fl rawFilter) []bpfProgram {
+	var progs []bpfProgram
+	if fl.Kind == "bpf" {
+		prog := bpfProgram{attach: "classifier", directAction: "false"}
+		prog.id, prog.name, prog.tag = bpfIdentity(fl.Options, tcaBpfID, tcaBpfName, tcaBpfTag)
+		if flags, ok := fl.Options[tcaBpfFlags]; ok && len(flags) >= 4 &&
+			binary.NativeEndian.Uint32(flags)&tcaBpfFlagActDirect != 0 {
+			prog.directAction = "true"
+		}
+		progs = append(progs, prog)
+	}
+	for _, act := range fl.Actions {
+		if act.Kind != "bpf" {
+			continue
+		}
+		prog := bpfProgram{attach: "action"}
+		prog.id, prog.name, prog.tag = bpfIdentity(act.Options, tcaActBpfID, tcaActBpfName, tcaActBpfTag)
+		if slices.Contains(progs, prog) {
+			continue
+		}
+		progs = append(progs, prog)
+	}
+	return progs
+}
+
+// bpfIdentity reads the id, name and tag of a program from the attributes
+func bpfIdentity(attrs map[uint16][]byte, idAttr, nameAttr, tagAttr uint16) (uint32, string, string) {
+	var id uint32
+	if v, ok := attrs[idAttr]; ok && len(v) >= 4 {
+		id = binary.NativeEndian.Uint32(v)
+	}
+	return id, nullStr(attrs[nameAttr]), hex.EncodeToString(attrs[tagAttr])
+}
+
+// bpfStatsEnabled reports if the kernel accounts the run time of the BPF programs
+func bpfStatsEnabled() bool {
+	b, err := os.ReadFile(bpfStatsEnabledPath)
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(string(b)) == "1"
+}
+
+// bpfProgStats fetches the run count and run time of a BPF program through the bpf syscall. This
+// needs CAP_SYS_ADMIN or CAP_BPF.
+func bpfProgStats(id uint32) (runCnt, runTimeNs uint64, err error) {
+	getFd := struct {
+		progID    uint32
+		nextID    uint32
+		openFlags uint32
+	}{progID: id}
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_GET_FD_BY_ID, uintptr(unsafe.Pointer(&getFd)), unsafe.Sizeof(getFd))
+	if errno != 0 {
+		return 0, 0, errno
+	}
+	defer unix.Close(int(fd))
+
+	info := make([]byte, bpfProgInfoLen)
+	getInfo := struct {
+		bpfFd   uint32
+		infoLen uint32
+		info    uint64
+	}{
+		bpfFd:   uint32(fd),
+		infoLen: uint32(len(info)),
+		info:    uint64(uintptr(unsafe.Pointer(&info[0]))),
+	}
+	_, _, errno = unix.Syscall(unix.SYS_BPF, unix.BPF_OBJ_GET_INFO_BY_FD, uintptr(unsafe.Pointer(&getInfo)), unsafe.Sizeof(getInfo))
+	runtime.KeepAlive(info)
+	if errno != 0 {
+		return 0, 0, errno
+	}
+	return binary.NativeEndian.Uint64(info[bpfProgInfoRunCnt : bpfProgInfoRunCnt+8]),
+		binary.NativeEndian.Uint64(info[bpfProgInfoRunTimeNs : bpfProgInfoRunTimeNs+8]), nil
+}
@@ -0,0 +1,152 @@
+package tccollector_test
+
+import (
+	"encoding/binary"
+	"slices"
+	"testing"
+
+	tcexporter "github.com/fbegyn/tc_exporter/collector"
+	"github.com/mdlayher/netlink"
+)
+
+// encodeClsBpf encodes the options of a cls_bpf filter with the actions in TCA_BPF_ACT
+func encodeClsBpf(t *testing.T, id uint32, name string, tag []byte, flags uint32, acts []byte) []byte {
+	t.Helper()
+	ae := netlink.NewAttributeEncoder()
+	if acts != nil {
+		ae.Bytes(1, acts)
+	}
+	ae.String(7, name)
+	ae.Uint32(8, flags)
+	ae.Bytes(10, tag)
+	ae.Uint32(11, id)
+	b, err := ae.Encode()
+	if err != nil {
+		t.Fatalf("failed to encode cls_bpf options: %v", err)
+	}
+	return b
+}
+
+// actBpf returns an act_bpf action with the program in its options
+func actBpf(index, id uint32, name string, tag []byte) testAction {
+	return testAction{
+		kind:  "bpf",
+		index: index,
+		options: func(ae *netlink.AttributeEncoder) {
+			ae.Bytes(2, make([]byte, 20))
+			ae.String(6, name)
+			ae.Bytes(8, tag)
+			ae.Uint32(9, id)
+		},
+	}
+}
+
+func TestFilterBpfPrograms(t *testing.T) {
+	tag := []byte{0xde, 0xad, 0xbe, 0xef, 0x00, 0x01, 0x02, 0x03}
+	otherTag := []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}
+
+	tests := []struct {
+		name string
+		obj  tcexporter.TestRawObject
+		want []tcexporter.TestBpfProgram
+	}{
+		{
+			name: "direct action classifier",
+			obj: tcexporter.TestRawObject{
+				Handle: 1, Kind: "bpf",
+				Options: encodeClsBpf(t, 42, "tc_ingress", tag, 1, nil),
+			},
+			want: []tcexporter.TestBpfProgram{
+				{Attach: "classifier", ID: 42, Name: "tc_ingress", Tag: "deadbeef00010203", DirectAction: "true"},
+			},
+		},
+		{
+			name: "classifier with actions",
+			obj: tcexporter.TestRawObject{
+				Handle: 1, Kind: "bpf",
+				Options: encodeClsBpf(t, 42, "cls", tag, 0, encodeActions(t,
+					actBpf(1, 43, "act", otherTag),
+					testAction{kind: "gact", index: 1},
+					// the same program attached by a second action is only returned once
+					actBpf(1, 43, "act", otherTag),
+					actBpf(2, 44, "other", otherTag),
+				)),
+			},
+			want: []tcexporter.TestBpfProgram{
+				{Attach: "classifier", ID: 42, Name: "cls", Tag: "deadbeef00010203", DirectAction: "false"},
+				{Attach: "action", ID: 43, Name: "act", Tag: "0102030405060708"},
+				{Attach: "action", ID: 44, Name: "other", Tag: "0102030405060708"},
+			},
+		},
+		{
+			name: "bpf action of another classifier",
+			obj: tcexporter.TestRawObject{
+				Handle: 1, Kind: "matchall",
+				Options: func() []byte {
+					ae := netlink.NewAttributeEncoder()
+					ae.Bytes(2, encodeActions(t, actBpf(5, 50, "prog", tag)))
+					b, err := ae.Encode()
+					if err != nil {
+						t.Fatalf("failed to encode matchall options: %v", err)
+					}
+					return b
+				}(),
+			},
+			want: []tcexporter.TestBpfProgram{
+				{Attach: "action", ID: 50, Name: "prog", Tag: "deadbeef00010203"},
+			},
+		},
+		{
+			name: "no programs",
+			obj:  tcexporter.TestRawObject{Handle: 1, Kind: "u32"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			progs, err := tcexporter.FilterBpfPrograms(2, tt.obj)
+			if err != nil {
+				t.Fatalf("failed to decode filter: %v", err)
+			}
+			if !slices.Equal(progs, tt.want) {
+				t.Fatalf("unexpected programs: got %+v, want %+v", progs, tt.want)
+			}
+		})
+	}
+}
+
+func TestBpfIdentity(t *testing.T) {
+	id := binary.NativeEndian.AppendUint32(nil, 7)
+	tests := []struct {
+		name  string
+		attrs map[uint16][]byte
+		id    uint32
+		prog  string
+		tag   string
+	}{
+		{
+			name:  "complete",
+			attrs: map[uint16][]byte{7: append([]byte("prog"), 0), 10: {0xab, 0xcd}, 11: id},
+			id:    7,
+			prog:  "prog",
+			tag:   "abcd",
+		},
+		{
+			// programs loaded by old kernels have no id and tag
+			name:  "name only",
+			attrs: map[uint16][]byte{7: append([]byte("prog"), 0)},
+			prog:  "prog",
+		},
+		{
+			name:  "id too short",
+			attrs: map[uint16][]byte{11: id[:2]},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, name, tag := tcexporter.BpfIdentity(tt.attrs)
+			if id != tt.id || name != tt.prog || tag != tt.tag {
+				t.Fatalf("unexpected identity: got (%d, %q, %q), want (%d, %q, %q)", id, name, tag, tt.id, tt.prog, tt.tag)
+			}
+		})
+	}
+}
@@ -237,3 +237,13 @@ func (cc *ClassCollector) CollectObject(ch chan<- prometheus.Metric, host, ns st
 		)
 	}
 }
+
+// counters implements counterCollector
+func (cc *ClassCollector) counters() []*prometheus.Desc {
+	return cc.stats.counters()
+}
+
+// byteCounter implements byteCollector
+func (cc *ClassCollector) byteCounter() *prometheus.Desc {
+	return cc.stats.bytes
+}
@@ -1,33 +1,52 @@
 package tccollector
 
 import (
+	"fmt"
 	"log/slog"
 	"os"
 
 	"github.com/florianl/go-tc"
 	"github.com/jsimonetti/rtnetlink"
 	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
 )
 
 const namespace = "tc"
 
 // TcCollector is the object that will collect TC data for the interface
 type TcCollector struct {
-	logger     slog.Logger
-	netns      map[string][]rtnetlink.LinkMessage
-	Collectors map[string]ObjectCollector
-	Filters    FilterHolder
+	logger              slog.Logger
+	netns               map[string][]rtnetlink.LinkMessage
+	Collectors          map[string]ObjectCollector
+	InterfaceCollectors map[string]InterfaceCollector
+	NamespaceCollectors map[string]NamespaceCollector
+	qdiscRules          *RuleSet
+	classRules          *RuleSet
+	aggregator          *Aggregator
+	distributionOnly    bool
+	top                 *TopSelector
+	flows               *FlowCollector
+	generic             *GenericCollector
+	monotonic           *MonotonicTracker
+	guard               *CardinalityGuard
 }
 
-type FilterHolder struct {
-	Qdisc []Filter `name:"qdisc"`
-	Class []Filter `name:"class"`
-}
-
-type Filter struct {
-	Kind   string `name:"kind"`
-	Parent string `name:"parent"`
-	Handle string `name:"handle"`
+// Config holds the configuration of the TcCollector and its subcollectors as read from the config
+// file
+type Config struct {
+	Filters      FilterHolder       `mapstructure:"filters"`
+	Rates        RateConfig         `mapstructure:"rates"`
+	Sampler      SamplerConfig      `mapstructure:"sampler"`
+	Thresholds   []Threshold        `mapstructure:"thresholds"`
+	Monotonic    MonotonicConfig    `mapstructure:"monotonic"`
+	Names        NamesConfig        `mapstructure:"names"`
+	Aggregation  AggregationConfig  `mapstructure:"aggregation"`
+	Distribution DistributionConfig `mapstructure:"distribution"`
+	Limits       LimitsConfig       `mapstructure:"limits"`
+	Top          TopConfig          `mapstructure:"top"`
+	Flows        FlowsConfig        `mapstructure:"flows"`
+	Generic      GenericConfig      `mapstructure:"generic"`
+	Match        MatchConfig        `mapstructure:"match"`
 }
 
 type ObjectCollector interface {
@@ -35,9 +54,32 @@ type ObjectCollector interface {
 	CollectObject(ch chan<- prometheus.Metric, hostname, ns string, interf rtnetlink.LinkMessage, qd tc.Object)
 }
 
+// InterfaceCollector collects metrics about an interface as a whole. It receives all the qdiscs and
+// classes of the interface that passed the filters at once.
+type InterfaceCollector interface {
+	Describe(chan<- *prometheus.Desc)
+	CollectInterface(ch chan<- prometheus.Metric, hostname, ns string, interf rtnetlink.LinkMessage, qdiscs, classes []tc.Object)
+}
+
+// NamespaceCollector collects metrics about a netns as a whole, like the objects that are not bound
+// to an interface. It receives the interfaces of the netns.
+type NamespaceCollector interface {
+	Describe(chan<- *prometheus.Desc)
+	CollectNamespace(ch chan<- prometheus.Metric, hostname, ns string, interfaces []rtnetlink.LinkMessage)
+}
+
+// blockCollector is implemented by the interface collectors that also collect the shared filter
+// blocks of a netns. It receives every block with the interfaces it is bound to, after all the
+// interfaces of the netns were collected.
+type blockCollector interface {
+	CollectBlocks(ch chan<- prometheus.Metric, hostname, ns string, blocks map[uint32][]blockBinding)
+}
+
 // NewTcCollector create a new TcCollector given a network interface
-func NewTcCollector(netns map[string][]rtnetlink.LinkMessage, collectorEnables map[string]bool, filters FilterHolder, logger *slog.Logger) (prometheus.Collector, error) {
+func NewTcCollector(netns map[string][]rtnetlink.LinkMessage, collectorEnables map[string]bool, cfg Config, logger *slog.Logger) (prometheus.Collector, error) {
 	collectors := map[string]ObjectCollector{}
+	interfaceCollectors := map[string]InterfaceCollector{}
+	namespaceCollectors := map[string]NamespaceCollector{}
 
 	// Setup Qdisc collector for interface
 	qColl, err := NewQdiscCollector(netns, logger)
@@ -52,6 +94,81 @@ func NewTcCollector(netns map[string][]rtnetlink.LinkMessage, collectorEnables m
 	}
 	collectors["class"] = cColl
 
+	// Setup the exporter side rate calculation
+	if cfg.Rates.Enabled {
+		sampler := NewRateSampler(netns, cfg.Rates, logger)
+		go sampler.Run()
+		logger.Debug("registering collector", "collector", "rate", "key", "rate_qdisc")
+		rColl, err := NewRateCollector(sampler, "qdisc", logger)
+		if err != nil {
+			return nil, err
+		}
+		collectors["rate_qdisc"] = rColl
+		logger.Debug("registering collector", "collector", "rate", "key", "rate_class")
+		rColl, err = NewRateCollector(sampler, "class", logger)
+		if err != nil {
+			return nil, err
+		}
+		collectors["rate_class"] = rColl
+	}
+
+	// Setup the high frequency queue depth sampler, the thresholds are evaluated by it as well
+	if cfg.Sampler.Enabled || len(cfg.Thresholds) > 0 {
+		sampler, err := NewDepthSampler(netns, cfg.Sampler, cfg.Thresholds, logger)
+		if err != nil {
+			return nil, err
+		}
+		sampler.Run()
+		logger.Debug("registering collector", "collector", "depth", "key", "depth_qdisc")
+		dColl, err := NewDepthCollector(sampler, "qdisc", logger)
+		if err != nil {
+			return nil, err
+		}
+		collectors["depth_qdisc"] = dColl
+		logger.Debug("registering collector", "collector", "depth", "key", "depth_class")
+		dColl, err = NewDepthCollector(sampler, "class", logger)
+		if err != nil {
+			return nil, err
+		}
+		collectors["depth_class"] = dColl
+		interfaceCollectors["sampler"] = sampler
+	}
+
+	// Setup the name info collectors, both share the names from tc_cls and the config with the
+	// aggregation
+	var resolver *NameResolver
+	if cfg.Names.Enabled || (cfg.Aggregation.Enabled && cfg.Aggregation.Mode == "name") {
+		var err error
+		resolver, err = NewNameResolver(cfg.Names, logger)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Names.Enabled {
+		logger.Debug("registering collector", "collector", "names", "key", "names_qdisc")
+		nColl, err := NewNameCollector(resolver, "qdisc", logger)
+		if err != nil {
+			return nil, err
+		}
+		collectors["names_qdisc"] = nColl
+		logger.Debug("registering collector", "collector", "names", "key", "names_class")
+		nColl, err = NewNameCollector(resolver, "class", logger)
+		if err != nil {
+			return nil, err
+		}
+		collectors["names_class"] = nColl
+	}
+
+	// Setup the distributions over the classes of an interface
+	if cfg.Distribution.Enabled {
+		logger.Debug("registering collector", "collector", "distribution", "key", "distribution")
+		coll, err := NewDistributionCollector(netns, logger)
+		if err != nil {
+			return nil, err
+		}
+		interfaceCollectors["distribution"] = coll
+	}
+
 	// add additional collectors
 	for collector, enabled := range collectorEnables {
 		if enabled {
@@ -63,6 +180,13 @@ func NewTcCollector(netns map[string][]rtnetlink.LinkMessage, collectorEnables m
 					return nil, err
 				}
 				collectors["cbq"] = coll
+			case "cbs":
+				logger.Debug("registering collector", "collector", "cbs", "key", "cbs")
+				coll, err := NewCbsCollector(netns, logger)
+				if err != nil {
+					return nil, err
+				}
+				collectors["cbs"] = coll
 			case "choke":
 				logger.Debug("registering collector", "collector", "choke", "key", "choke")
 				coll, err := NewChokeCollector(netns, logger)
@@ -77,6 +201,20 @@ func NewTcCollector(netns map[string][]rtnetlink.LinkMessage, collectorEnables m
 					return nil, err
 				}
 				collectors["codel"] = coll
+			case "etf":
+				logger.Debug("registering collector", "collector", "etf", "key", "etf")
+				coll, err := NewEtfCollector(netns, logger)
+				if err != nil {
+					return nil, err
+				}
+				collectors["etf"] = coll
+			case "fifo":
+				logger.Debug("registering collector", "collector", "fifo", "key", "fifo")
+				coll, err := NewFifoCollector(netns, logger)
+				if err != nil {
+					return nil, err
+				}
+				collectors["fifo"] = coll
 			case "fq":
 				logger.Debug("registering collector", "collector", "fq", "key", "fq")
 				coll, err := NewFqCollector(netns, logger)
@@ -147,6 +285,13 @@ func NewTcCollector(netns map[string][]rtnetlink.LinkMessage, collectorEnables m
 					return nil, err
 				}
 				collectors["pie"] = coll
+			case "qfq":
+				logger.Debug("registering collector", "collector", "qfq", "key", "qfq")
+				coll, err := NewQfqCollector(netns, logger)
+				if err != nil {
+					return nil, err
+				}
+				collectors["qfq"] = coll
 			case "red":
 				logger.Debug("registering collector", "collector", "red", "key", "red")
 				coll, err := NewRedCollector(netns, logger)
@@ -154,6 +299,20 @@ func NewTcCollector(netns map[string][]rtnetlink.LinkMessage, collectorEnables m
 					return nil, err
 				}
 				collectors["red"] = coll
+			case "gred":
+				logger.Debug("registering collector", "collector", "gred", "key", "gred")
+				coll, err := NewGredCollector(netns, logger)
+				if err != nil {
+					return nil, err
+				}
+				collectors["gred"] = coll
+			case "skbprio":
+				logger.Debug("registering collector", "collector", "skbprio", "key", "skbprio")
+				coll, err := NewSkbprioCollector(netns, logger)
+				if err != nil {
+					return nil, err
+				}
+				collectors["skbprio"] = coll
 			case "sfb":
 				logger.Debug("registering collector", "collector", "sfb", "key", "sfb")
 				coll, err := NewSfbCollector(netns, logger)
@@ -168,15 +327,118 @@ func NewTcCollector(netns map[string][]rtnetlink.LinkMessage, collectorEnables m
 					return nil, err
 				}
 				collectors["sfq"] = coll
+			case "info":
+				logger.Debug("registering collector", "collector", "info", "key", "info")
+				coll, err := NewInfoCollector(netns, logger)
+				if err != nil {
+					return nil, err
+				}
+				interfaceCollectors["info"] = coll
+			case "filter":
+				logger.Debug("registering collector", "collector", "filter", "key", "filter")
+				coll, err := NewFilterCollector(netns, cfg.Match, logger)
+				if err != nil {
+					return nil, err
+				}
+				interfaceCollectors["filter"] = coll
+			case "offload":
+				logger.Debug("registering collector", "collector", "offload", "key", "offload")
+				coll, err := NewOffloadCollector(netns, logger)
+				if err != nil {
+					return nil, err
+				}
+				interfaceCollectors["offload"] = coll
+			case "action":
+				logger.Debug("registering collector", "collector", "action", "key", "action")
+				coll, err := NewActionCollector(netns, logger)
+				if err != nil {
+					return nil, err
+				}
+				namespaceCollectors["action"] = coll
+			case "tree":
+				logger.Debug("registering collector", "collector", "tree", "key", "tree")
+				coll, err := NewTreeCollector(netns, logger)
+				if err != nil {
+					return nil, err
+				}
+				interfaceCollectors["tree"] = coll
+			case "aqm":
+				logger.Debug("registering collector", "collector", "aqm", "key", "aqm")
+				coll, err := NewAqmCollector(netns, logger)
+				if err != nil {
+					return nil, err
+				}
+				collectors["aqm"] = coll
 			}
 		}
 	}
 
+	qdiscRules, err := NewRuleSet(cfg.Filters.Qdisc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid qdisc filter: %w", err)
+	}
+	classRules, err := NewRuleSet(cfg.Filters.Class)
+	if err != nil {
+		return nil, fmt.Errorf("invalid class filter: %w", err)
+	}
+
+	var aggregator *Aggregator
+	if cfg.Aggregation.Enabled {
+		aggregator, err = NewAggregator(cfg.Aggregation, resolver, logger)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var monotonic *MonotonicTracker
+	if cfg.Monotonic.Enabled {
+		monotonic = NewMonotonicTracker(collectors, interfaceCollectors, cfg.Monotonic, logger)
+	}
+
+	var top *TopSelector
+	if cfg.Top.Enabled {
+		top, err = NewTopSelector(cfg.Top, logger)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var flows *FlowCollector
+	if cfg.Flows.Enabled {
+		flows, err = NewFlowCollector(cfg.Flows, logger)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var generic *GenericCollector
+	if cfg.Generic.Enabled {
+		generic, err = NewGenericCollector(cfg.Generic, logger)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var guard *CardinalityGuard
+	if cfg.Limits.enabled() {
+		guard = NewCardinalityGuard(collectors, cfg.Limits, logger)
+	}
+
 	return &TcCollector{
-		logger:     *logger,
-		netns:      netns,
-		Collectors: collectors,
-		Filters:    filters,
+		logger:              *logger,
+		netns:               netns,
+		Collectors:          collectors,
+		InterfaceCollectors: interfaceCollectors,
+		NamespaceCollectors: namespaceCollectors,
+		qdiscRules:          qdiscRules,
+		classRules:          classRules,
+		aggregator:          aggregator,
+		distributionOnly:    cfg.Distribution.Enabled && cfg.Distribution.Only,
+		top:                 top,
+		flows:               flows,
+		generic:             generic,
+		monotonic:           monotonic,
+		guard:               guard,
 	}, nil
 }
 
@@ -185,6 +447,30 @@ func (t TcCollector) Describe(ch chan<- *prometheus.Desc) {
 	for _, col := range t.Collectors {
 		col.Describe(ch)
 	}
+	for _, col := range t.InterfaceCollectors {
+		col.Describe(ch)
+	}
+	for _, col := range t.NamespaceCollectors {
+		col.Describe(ch)
+	}
+	if t.aggregator != nil {
+		t.aggregator.Describe(ch)
+	}
+	if t.top != nil {
+		t.top.Describe(ch)
+	}
+	if t.flows != nil {
+		t.flows.Describe(ch)
+	}
+	if t.generic != nil {
+		t.generic.Describe(ch)
+	}
+	if t.monotonic != nil {
+		t.monotonic.Describe(ch)
+	}
+	if t.guard != nil {
+		t.guard.Describe(ch)
+	}
 }
 
 // Collect fetches and updates the data the collector is exporting
@@ -196,35 +482,101 @@ func (t TcCollector) Collect(ch chan<- prometheus.Metric) {
 	}
 
 	t.logger.Debug("starting metrics scrape")
+	if t.monotonic == nil && t.guard == nil {
+		t.collect(ch, host)
+	} else {
+		// resets can only be detected and the series can only be counted once all metrics of an
+		// object are known, so the metrics of the scrape are buffered
+		buf := make(chan prometheus.Metric)
+		done := make(chan []prometheus.Metric)
+		go func() {
+			var metrics []prometheus.Metric
+			for m := range buf {
+				metrics = append(metrics, m)
+			}
+			done <- metrics
+		}()
+		t.collect(buf, host)
+		close(buf)
+		metrics := <-done
+		if t.monotonic != nil {
+			metrics = t.monotonic.process(metrics)
+		}
+		if t.guard != nil {
+			metrics = t.guard.process(metrics)
+		}
+		for _, m := range metrics {
+			ch <- m
+		}
+	}
+}
+
+// collect passes the qdiscs and classes of all interfaces to the collectors
+func (t TcCollector) collect(ch chan<- prometheus.Metric, host string) {
 	// iterate through the netns and devices
 	for ns, devices := range t.netns {
+		// the shared filter blocks are collected once per netns
+		blocks := make(map[uint32][]blockBinding)
 		for _, interf := range devices {
 			// fetch all the the qdisc for this interface
+			// keep track of the objects that are not filtered out for the interface collectors
+			var collectedQdiscs, collectedClasses []tc.Object
+
 			qdiscs, err := getQdiscs(uint32(interf.Index), ns)
 			if err != nil {
 				t.logger.Error("failed to get qdiscs", "interface", interf.Attributes.Name, "err", err)
 			}
-		QDISCS:
+			classes, err := getClasses(uint32(interf.Index), ns)
+			if err != nil {
+				t.logger.Error("failed to get classes", "interface", interf.Attributes.Name, "err", err)
+			}
+			// the tree is needed to filter on subtrees
+			tree := NewObjectTree(qdiscs, classes)
+			// the raw messages are only dumped when a collector that decodes them needs them
+			rawQdiscs := newRawDump(ns, unix.RTM_GETQDISC, interf.Index)
+			rawClasses := newRawDump(ns, unix.RTM_GETTCLASS, interf.Index)
+
 			for _, qd := range qdiscs {
 				t.logger.Debug("qdisc type", "kind", qd.Kind, "handle", qd.Handle)
-				for _, f := range t.Filters.Qdisc {
-					kindmatch := qd.Kind == f.Kind && f.Kind != ""
-					parentmatch := FmtHandleStr(qd.Parent) == f.Parent && f.Parent != ""
-					if kindmatch || parentmatch {
-						t.logger.Debug("skipping qdisc, it is in the filter list", "qdisc", qd)
-						continue QDISCS
-					}
+				if !t.qdiscRules.Includes(FilterTarget{
+					NetNS:     ns,
+					Link:      interf.Attributes.Name,
+					Kind:      qd.Kind,
+					Handle:    qd.Handle,
+					Parent:    qd.Parent,
+					Ancestors: tree.Ancestors(qd.Handle, qd.Parent),
+				}) {
+					t.logger.Debug("skipping qdisc, it is excluded by the filters", "qdisc", qd)
+					continue
 				}
+				collectedQdiscs = append(collectedQdiscs, qd)
 				qcol, found := t.Collectors["qdisc"]
 				if !found {
 					t.logger.Error("qdisc collector is not running")
 					continue
 				}
 				qcol.CollectObject(ch, host, ns, interf, qd)
-				if qd.XStats == nil {
+				if rcol, found := t.Collectors["rate_qdisc"]; found {
+					rcol.CollectObject(ch, host, ns, interf, qd)
+				}
+				if dcol, found := t.Collectors["depth_qdisc"]; found {
+					dcol.CollectObject(ch, host, ns, interf, qd)
+				}
+				if ncol, found := t.Collectors["names_qdisc"]; found {
+					ncol.CollectObject(ch, host, ns, interf, qd)
+				}
+				if t.generic != nil && t.generic.handles(qd.Kind, specificQdiscKinds) {
+					t.logger.Debug("passing qdisc to generic collector", "qdisc", qd)
+					t.generic.CollectObject(ch, host, ns, interf, qd)
+					continue
+				}
+				if qd.XStats == nil && !rawCollected(rawQdiscKinds, t.Collectors, qd.Kind) {
 					t.logger.Debug("XStats struct is empty for this qdisc", "qdisc", qd, "interface", interf.Attributes.Name)
 					continue
 				}
+				if acol, found := t.Collectors["aqm"]; found {
+					acol.CollectObject(ch, host, ns, interf, qd)
+				}
 				t.logger.Debug("passing qdisc to qdisc collector", "qdisc", qd)
 				switch qd.Kind {
 				case "cbq":
@@ -235,6 +587,14 @@ func (t TcCollector) Collect(ch chan<- prometheus.Metric) {
 					}
 					t.logger.Debug("passing qdisc to cbq collector", "qdisc", qd)
 					col.CollectObject(ch, host, ns, interf, qd)
+				case "cbs":
+					col, found := t.Collectors["cbs"]
+					if !found {
+						t.logger.Error("cbs qdisc collector is not running")
+						continue
+					}
+					t.logger.Debug("passing qdisc to cbs collector", "qdisc", qd)
+					collectObject(col, ch, host, ns, interf, qd, rawQdiscs)
 				case "choke":
 					col, found := t.Collectors["choke"]
 					if !found {
@@ -251,6 +611,22 @@ func (t TcCollector) Collect(ch chan<- prometheus.Metric) {
 					}
 					t.logger.Debug("passing qdisc to codel collector", "qdisc", qd)
 					col.CollectObject(ch, host, ns, interf, qd)
+				case "etf":
+					col, found := t.Collectors["etf"]
+					if !found {
+						t.logger.Error("etf qdisc collector is not running")
+						continue
+					}
+					t.logger.Debug("passing qdisc to etf collector", "qdisc", qd)
+					collectObject(col, ch, host, ns, interf, qd, rawQdiscs)
+				case "pfifo", "bfifo", "pfifo_head_drop", "pfifo_fast", "noqueue":
+					col, found := t.Collectors["fifo"]
+					if !found {
+						t.logger.Error("fifo qdisc collector is not running")
+						continue
+					}
+					t.logger.Debug("passing qdisc to fifo collector", "qdisc", qd)
+					collectObject(col, ch, host, ns, interf, qd, rawQdiscs)
 				case "fq":
 					col, found := t.Collectors["fq"]
 					if !found {
@@ -307,6 +683,22 @@ func (t TcCollector) Collect(ch chan<- prometheus.Metric) {
 					}
 					t.logger.Debug("passing qdisc to red collector", "qdisc", qd)
 					col.CollectObject(ch, host, ns, interf, qd)
+				case "gred":
+					col, found := t.Collectors["gred"]
+					if !found {
+						t.logger.Error("gred qdisc collector is not running")
+						continue
+					}
+					t.logger.Debug("passing qdisc to gred collector", "qdisc", qd)
+					collectObject(col, ch, host, ns, interf, qd, rawQdiscs)
+				case "skbprio":
+					col, found := t.Collectors["skbprio"]
+					if !found {
+						t.logger.Error("skbprio qdisc collector is not running")
+						continue
+					}
+					t.logger.Debug("passing qdisc to skbprio collector", "qdisc", qd)
+					collectObject(col, ch, host, ns, interf, qd, rawQdiscs)
 				case "sfb":
 					col, found := t.Collectors["sfb"]
 					if !found {
@@ -324,32 +716,75 @@ func (t TcCollector) Collect(ch chan<- prometheus.Metric) {
 					t.logger.Debug("passing qdisc to sfq collector", "qdisc", qd)
 					col.CollectObject(ch, host, ns, interf, qd)
 				default:
-					t.logger.Info("no specific exporter for qdisc", "qdisc", qd)
+					t.logger.Debug("no specific exporter for qdisc", "qdisc", qd)
 				}
 			}
 
-			classes, err := getClasses(uint32(interf.Index), ns)
-			if err != nil {
-				t.logger.Error("failed to get qdiscs", "interface", interf.Attributes.Name, "err", err)
+			groups := make(map[groupKey]*groupStats)
+			var flowClasses []qdiscFlow
+			var flowQdiscs map[int]rawKey
+			if t.flows != nil {
+				flowQdiscs = flowOwners(qdiscs, classes)
 			}
-		CLASSES:
-			for _, cl := range classes {
+			for i, cl := range classes {
 				t.logger.Debug("class type", "kind", cl.Kind, "classid", cl.Handle)
-				for _, f := range t.Filters.Class {
-					kindmatch := cl.Kind == f.Kind && f.Kind != ""
-					parentmatch := FmtHandleStr(cl.Parent) == f.Parent && f.Parent != ""
-					if kindmatch || parentmatch {
-						t.logger.Debug("skipping class, it is in the filter list", "class", cl)
-						continue CLASSES
+				ancestors := tree.Ancestors(cl.Handle, cl.Parent)
+				if !t.classRules.Includes(FilterTarget{
+					NetNS:     ns,
+					Link:      interf.Attributes.Name,
+					Kind:      cl.Kind,
+					Handle:    cl.Handle,
+					Parent:    cl.Parent,
+					Ancestors: ancestors,
+				}) {
+					t.logger.Debug("skipping class, it is excluded by the filters", "class", cl)
+					continue
+				}
+				if t.flows != nil && isFlowClass(cl) {
+					qdisc, found := flowQdiscs[i]
+					if !found {
+						t.logger.Debug("skipping flow, its qdisc is unknown", "flow", cl)
+						continue
 					}
+					flowClasses = append(flowClasses, qdiscFlow{flow: cl, qdisc: qdisc})
+					continue
+				}
+				if t.aggregator != nil && t.aggregator.Fold(groups, cl, ancestors, tree.IsLeaf(cl.Handle)) {
+					continue
 				}
+				collectedClasses = append(collectedClasses, cl)
+			}
+
+			// only the busiest classes are exported in top-N mode
+			exportedClasses := collectedClasses
+			if t.top != nil {
+				exportedClasses = t.top.Select(ch, host, ns, interf, collectedClasses)
+			}
+			if t.distributionOnly {
+				exportedClasses = nil
+			}
+			for _, cl := range exportedClasses {
 				ccol, found := t.Collectors["class"]
 				if !found {
 					t.logger.Error("class collector is not running")
 					continue
 				}
 				ccol.CollectObject(ch, host, ns, interf, cl)
-				if cl.XStats == nil {
+				if rcol, found := t.Collectors["rate_class"]; found {
+					rcol.CollectObject(ch, host, ns, interf, cl)
+				}
+				if dcol, found := t.Collectors["depth_class"]; found {
+					dcol.CollectObject(ch, host, ns, interf, cl)
+				}
+				if ncol, found := t.Collectors["names_class"]; found {
+					ncol.CollectObject(ch, host, ns, interf, cl)
+				}
+				if t.generic != nil && t.generic.handles(cl.Kind, specificClassKinds) {
+					t.logger.Debug("passing class to generic collector", "class", cl)
+					t.generic.CollectObject(ch, host, ns, interf, cl)
+					continue
+				}
+				if cl.XStats == nil && !rawCollected(rawClassKinds, t.Collectors, cl.Kind) {
 					t.logger.Debug("XStats struct is empty for this class", "class", cl, "interface", interf.Attributes.Name)
 					continue
 				}
@@ -378,11 +813,47 @@ func (t TcCollector) Collect(ch chan<- prometheus.Metric) {
 					}
 					t.logger.Debug("passing class to hfsc service curve collector", "class", cl)
 					col.CollectObject(ch, host, ns, interf, cl)
+				case "qfq":
+					col, found := t.Collectors["qfq"]
+					if !found {
+						t.logger.Error("qfq class collector is not running")
+						continue
+					}
+					t.logger.Debug("passing class to qfq collector", "class", cl)
+					collectObject(col, ch, host, ns, interf, cl, rawClasses)
+				case "skbprio":
+					col, found := t.Collectors["skbprio"]
+					if !found {
+						t.logger.Error("skbprio class collector is not running")
+						continue
+					}
+					t.logger.Debug("passing class to skbprio collector", "class", cl)
+					col.CollectObject(ch, host, ns, interf, cl)
 				default:
-					t.logger.Info("no specific exporter for class", "class", cl)
+					t.logger.Debug("no specific exporter for class", "class", cl)
+				}
+			}
+
+			if t.aggregator != nil {
+				t.aggregator.CollectInterface(ch, host, ns, interf, groups)
+			}
+			if t.flows != nil {
+				t.flows.CollectInterface(ch, host, ns, interf, flowClasses)
+			}
+			for _, col := range t.InterfaceCollectors {
+				collectInterface(col, ch, host, ns, interf, collectedQdiscs, collectedClasses, rawQdiscs, rawClasses)
+			}
+			addBlockBindings(blocks, interf, collectedQdiscs)
+		}
+		if len(blocks) > 0 {
+			for _, col := range t.InterfaceCollectors {
+				if bcol, ok := col.(blockCollector); ok {
+					bcol.CollectBlocks(ch, host, ns, blocks)
 				}
 			}
 		}
+		for _, col := range t.NamespaceCollectors {
+			col.CollectNamespace(ch, host, ns, devices)
+		}
 	}
-	t.logger.Debug("metric scrape complete")
 }
@@ -56,7 +56,7 @@ func TestTcCollector(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 			logger = logger.With("test", "collector")
-			filters := tcexporter.FilterHolder{}
+			cfg := tcexporter.Config{}
 
 			test := make(map[string][]rtnetlink.LinkMessage)
 			con, _ := tcexporter.GetNetlinkConn("default")
@@ -69,7 +69,7 @@ func TestTcCollector(t *testing.T) {
 			links, _ = con.Link.List()
 			test["testing02"] = links
 
-			coll, err := tcexporter.NewTcCollector(test, enabledCollectors, filters, logger)
+			coll, err := tcexporter.NewTcCollector(test, enabledCollectors, cfg, logger)
 			_ = coll
 			if err != nil {
 				t.Fatalf("failed to create TC collector: %v", err)
@@ -0,0 +1,82 @@
+package tccollector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/florianl/go-tc"
+)
+
+// deltaExpiry is how long the previous sample of an object is kept when the object is not seen
+const deltaExpiry = 10 * time.Minute
+
+// deltaKey identifies a qdisc or class between scrapes
+type deltaKey struct {
+	ns      string
+	ifindex uint32
+	handle  uint32
+	kind    string
+}
+
+type deltaSample struct {
+	ts    time.Time
+	stats objectStats
+}
+
+// delta is the difference between two consecutive samples of an object
+type delta struct {
+	seconds float64
+	bytes   float64
+	packets float64
+	drops   float64
+}
+
+// deltaTracker keeps the previous sample of every object, so rates can be calculated from the
+// difference between consecutive scrapes
+type deltaTracker struct {
+	mu   sync.Mutex
+	last map[deltaKey]deltaSample
+}
+
+func newDeltaTracker() *deltaTracker {
+	return &deltaTracker{last: make(map[deltaKey]deltaSample)}
+}
+
+// update records the stats of the object and returns the difference with the previous sample. ok
+// is false for the first sample of an object and after its counters were reset.
+func (dt *deltaTracker) update(ns string, ifindex uint32, obj tc.Object, now time.Time) (delta, bool) {
+	s, found := readStats(obj)
+	if !found {
+		return delta{}, false
+	}
+	key := deltaKey{ns: ns, ifindex: ifindex, handle: obj.Handle, kind: obj.Kind}
+
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	prev, found := dt.last[key]
+	dt.last[key] = deltaSample{ts: now, stats: s}
+	if !found {
+		return delta{}, false
+	}
+	seconds := now.Sub(prev.ts).Seconds()
+	if seconds <= 0 || s.bytes < prev.stats.bytes || s.packets < prev.stats.packets || s.drops < prev.stats.drops {
+		return delta{}, false
+	}
+	return delta{
+		seconds: seconds,
+		bytes:   s.bytes - prev.stats.bytes,
+		packets: s.packets - prev.stats.packets,
+		drops:   s.drops - prev.stats.drops,
+	}, true
+}
+
+// expire forgets the objects that were not seen for a while
+func (dt *deltaTracker) expire(now time.Time) {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	for key, s := range dt.last {
+		if now.Sub(s.ts) > deltaExpiry {
+			delete(dt.last, key)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package tccollector
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/florianl/go-tc"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	distributionLabels []string = []string{"host", "netns", "linkindex", "link", "type"}
+
+	throughputBuckets  = prometheus.ExponentialBuckets(1000, 4, 12)
+	utilizationBuckets = []float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 0.95, 1, 1.5, 2, 5}
+	backlogBuckets     = prometheus.ExponentialBuckets(1500, 2, 12)
+	dropsBuckets       = []float64{0, 1, 10, 100, 1000, 10000, 100000}
+)
+
+// DistributionConfig configures the distributions over all classes of an interface
+type DistributionConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Only exports the distributions, the classes are not passed to the class collectors
+	Only bool `mapstructure:"only"`
+}
+
+// constHistogram collects observations for a const histogram
+type constHistogram struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func newConstHistogram(buckets []float64) *constHistogram {
+	return &constHistogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *constHistogram) observe(v float64) {
+	h.count++
+	h.sum += v
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		h.counts[i]++
+	}
+}
+
+func (h *constHistogram) metric(desc *prometheus.Desc, labels ...string) prometheus.Metric {
+	buckets := make(map[float64]uint64, len(h.buckets))
+	var cumulative uint64
+	for i, b := range h.buckets {
+		cumulative += h.counts[i]
+		buckets[b] = cumulative
+	}
+	return prometheus.MustNewConstHistogram(desc, h.count, h.sum, buckets, labels...)
+}
+
+// classDistribution are the histograms of the classes of one kind on an interface
+type classDistribution struct {
+	throughput      *constHistogram
+	rateUtilization *constHistogram
+	ceilUtilization *constHistogram
+	backlog         *constHistogram
+	drops           *constHistogram
+}
+
+// DistributionCollector exports histograms over all classes of an interface instead of a series
+// per class. The throughput and drops are calculated from consecutive scrapes.
+type DistributionCollector struct {
+	logger          slog.Logger
+	netns           map[string][]rtnetlink.LinkMessage
+	deltas          *deltaTracker
+	throughput      *prometheus.Desc
+	rateUtilization *prometheus.Desc
+	ceilUtilization *prometheus.Desc
+	backlog         *prometheus.Desc
+	drops           *prometheus.Desc
+}
+
+// NewDistributionCollector create a new DistributionCollector given a network interface
+func NewDistributionCollector(netns map[string][]rtnetlink.LinkMessage, log *slog.Logger) (InterfaceCollector, error) {
+	log = log.With("collector", "distribution")
+	log.Info("making distribution collector")
+
+	return &DistributionCollector{
+		logger: *log,
+		netns:  netns,
+		deltas: newDeltaTracker(),
+		throughput: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "class_distribution", "throughput_bytes_per_second"),
+			"Distribution of the throughput of the classes since the previous scrape",
+			distributionLabels, nil,
+		),
+		rateUtilization: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "class_distribution", "rate_utilization_ratio"),
+			"Distribution of the throughput of the classes relative to their rate",
+			distributionLabels, nil,
+		),
+		ceilUtilization: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "class_distribution", "ceil_utilization_ratio"),
+			"Distribution of the throughput of the classes relative to their ceil",
+			distributionLabels, nil,
+		),
+		backlog: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "class_distribution", "backlog_bytes"),
+			"Distribution of the backlog of the classes",
+			distributionLabels, nil,
+		),
+		drops: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "class_distribution", "drops"),
+			"Distribution of the drops of the classes since the previous scrape",
+			distributionLabels, nil,
+		),
+	}, nil
+}
+
+// Describe implements Collector
+func (col *DistributionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ds := []*prometheus.Desc{
+		col.throughput,
+		col.rateUtilization,
+		col.ceilUtilization,
+		col.backlog,
+		col.drops,
+	}
+
+	for _, d := range ds {
+		ch <- d
+	}
+}
+
+// CollectInterface exports the distributions of the classes of the interface per class kind
+func (col *DistributionCollector) CollectInterface(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, qdiscs, classes []tc.Object) {
+	now := time.Now()
+	dists := make(map[string]*classDistribution)
+	for _, cl := range classes {
+		d, found := dists[cl.Kind]
+		if !found {
+			d = &classDistribution{
+				throughput:      newConstHistogram(throughputBuckets),
+				rateUtilization: newConstHistogram(utilizationBuckets),
+				ceilUtilization: newConstHistogram(utilizationBuckets),
+				backlog:         newConstHistogram(backlogBuckets),
+				drops:           newConstHistogram(dropsBuckets),
+			}
+			dists[cl.Kind] = d
+		}
+
+		if s, ok := readStats(cl); ok {
+			d.backlog.observe(s.backlog)
+		}
+		delta, ok := col.deltas.update(ns, interf.Index, cl, now)
+		if !ok {
+			continue
+		}
+		throughput := delta.bytes / delta.seconds
+		d.throughput.observe(throughput)
+		d.drops.observe(delta.drops)
+		if rate, ceil, ok := classRateCeil(cl); ok {
+			if rate > 0 {
+				d.rateUtilization.observe(throughput / rate)
+			}
+			if ceil > 0 {
+				d.ceilUtilization.observe(throughput / ceil)
+			}
+		}
+	}
+	col.deltas.expire(now)
+
+	for kind, d := range dists {
+		labels := []string{host, ns, fmt.Sprintf("%d", interf.Index), interf.Attributes.Name, kind}
+		ch <- d.throughput.metric(col.throughput, labels...)
+		ch <- d.backlog.metric(col.backlog, labels...)
+		ch <- d.drops.metric(col.drops, labels...)
+		if d.rateUtilization.count > 0 {
+			ch <- d.rateUtilization.metric(col.rateUtilization, labels...)
+		}
+		if d.ceilUtilization.count > 0 {
+			ch <- d.ceilUtilization.metric(col.ceilUtilization, labels...)
+		}
+	}
+}
+
+// classRateCeil returns the configured rate and ceil of the class in bytes per second, only HTB
+// classes have them
+func classRateCeil(cl tc.Object) (rate, ceil float64, ok bool) {
+	if cl.Kind != "htb" || cl.Htb == nil || cl.Htb.Parms == nil {
+		return 0, 0, false
+	}
+	rate = float64(cl.Htb.Parms.Rate.Rate)
+	ceil = float64(cl.Htb.Parms.Ceil.Rate)
+	// the 32 bit rates are capped, faster rates are in the 64 bit attributes
+	if cl.Htb.Rate64 != nil && *cl.Htb.Rate64 > 0 {
+		rate = float64(*cl.Htb.Rate64)
+	}
+	if cl.Htb.Ceil64 != nil && *cl.Htb.Ceil64 > 0 {
+		ceil = float64(*cl.Htb.Ceil64)
+	}
+	return rate, ceil, true
+}
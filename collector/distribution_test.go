@@ -0,0 +1,89 @@
+package tccollector_test
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	tcexporter "github.com/fbegyn/tc_exporter/collector"
+	"github.com/florianl/go-tc"
+	"github.com/florianl/go-tc/core"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestDistributionHistograms(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	col, err := tcexporter.NewDistributionCollector(nil, logger)
+	if err != nil {
+		t.Fatalf("failed to create distribution collector: %v", err)
+	}
+	interf := rtnetlink.LinkMessage{Index: 1, Attributes: &rtnetlink.LinkAttributes{Name: "eth0"}}
+
+	// two HTB classes with a rate of 1000 bytes/s and a ceil of 2000 bytes/s
+	class := func(minor uint32, bytes uint64, drops, backlog uint32) tc.Object {
+		return tc.Object{
+			Msg: tc.Msg{Handle: core.BuildHandle(1, minor), Parent: core.BuildHandle(1, 1)},
+			Attribute: tc.Attribute{
+				Kind:   "htb",
+				Stats2: &tc.Stats2{Bytes: bytes, Drops: drops, Backlog: backlog},
+				Htb: &tc.Htb{Parms: &tc.HtbOpt{
+					Rate: tc.RateSpec{Rate: 1000},
+					Ceil: tc.RateSpec{Rate: 2000},
+				}},
+			},
+		}
+	}
+	collect := func(classes []tc.Object) map[string]*dto.Histogram {
+		ch := make(chan prometheus.Metric, 16)
+		col.CollectInterface(ch, "host", "default", interf, nil, classes)
+		close(ch)
+		hists := make(map[string]*dto.Histogram)
+		for m := range ch {
+			var pb dto.Metric
+			if err := m.Write(&pb); err != nil {
+				t.Fatalf("failed to write metric: %v", err)
+			}
+			desc := m.Desc().String()
+			name := desc[strings.Index(desc, `"`)+1:]
+			hists[name[:strings.Index(name, `"`)]] = pb.Histogram
+		}
+		return hists
+	}
+
+	first := collect([]tc.Object{class(0x10, 0, 0, 3000), class(0x20, 0, 0, 0)})
+	if h := first["tc_class_distribution_backlog_bytes"]; h.GetSampleCount() != 2 || h.GetSampleSum() != 3000 {
+		t.Fatalf("unexpected backlog histogram: %v", h)
+	}
+	// the throughput needs two samples
+	if h := first["tc_class_distribution_throughput_bytes_per_second"]; h.GetSampleCount() != 0 {
+		t.Fatalf("unexpected throughput histogram after one scrape: %v", h)
+	}
+	if _, found := first["tc_class_distribution_rate_utilization_ratio"]; found {
+		t.Fatalf("utilization exported without throughput")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	second := collect([]tc.Object{class(0x10, 150, 5, 0), class(0x20, 0, 0, 0)})
+	throughput := second["tc_class_distribution_throughput_bytes_per_second"]
+	if throughput.GetSampleCount() != 2 {
+		t.Fatalf("unexpected throughput histogram: %v", throughput)
+	}
+	drops := second["tc_class_distribution_drops"]
+	if drops.GetSampleCount() != 2 || drops.GetSampleSum() != 5 {
+		t.Fatalf("unexpected drops histogram: %v", drops)
+	}
+	// 150 bytes in about 100ms is above the rate and below the ceil
+	util := second["tc_class_distribution_ceil_utilization_ratio"]
+	if util.GetSampleCount() != 2 || util.GetSampleSum() < 0.5 || util.GetSampleSum() > 0.76 {
+		t.Fatalf("unexpected ceil utilization histogram: %v", util)
+	}
+	for _, b := range second["tc_class_distribution_rate_utilization_ratio"].GetBucket() {
+		if b.GetUpperBound() == 1 && b.GetCumulativeCount() != 1 {
+			t.Fatalf("expected one class at or below its rate: %v", b)
+		}
+	}
+}
@@ -0,0 +1,410 @@
+package tccollector
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/florianl/go-tc"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/mdlayher/netlink"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// this file exposes the internals of the collectors to the tests in tccollector_test
+
+var testLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// TestObject is a qdisc or class as the kernel would dump it, with its basic counters
+type TestObject struct {
+	Ifindex uint32
+	Handle  uint32
+	Parent  uint32
+	Kind    string
+	Bytes   uint64
+	Packets uint32
+	Qlen    uint32
+	Backlog uint32
+}
+
+// raw encodes the object as a rawObject with its counters in the stats2 attribute
+func (o TestObject) raw() rawObject {
+	basic := make([]byte, 16)
+	binary.NativeEndian.PutUint64(basic[0:8], o.Bytes)
+	binary.NativeEndian.PutUint32(basic[8:12], o.Packets)
+	queue := make([]byte, 20)
+	binary.NativeEndian.PutUint32(queue[0:4], o.Qlen)
+	binary.NativeEndian.PutUint32(queue[4:8], o.Backlog)
+	return rawObject{
+		Ifindex: o.Ifindex,
+		Handle:  o.Handle,
+		Parent:  o.Parent,
+		Kind:    o.Kind,
+		Stats2:  map[uint16][]byte{tcaStatsBasic: basic, tcaStatsQueue: queue},
+	}
+}
+
+// TestRateSampler feeds samples to a RateSampler without dumping the kernel objects
+type TestRateSampler struct {
+	rs *RateSampler
+}
+
+// NewTestRateSampler creates a RateSampler for the tests
+func NewTestRateSampler(cfg RateConfig) TestRateSampler {
+	return TestRateSampler{rs: NewRateSampler(nil, cfg, testLogger)}
+}
+
+// Record records a sample of the qdiscs at time now in the default netns
+func (t TestRateSampler) Record(now time.Time, objs ...TestObject) {
+	raw := make([]rawObject, 0, len(objs))
+	for _, obj := range objs {
+		raw = append(raw, obj.raw())
+	}
+	t.rs.round++
+	t.rs.record(now, "default", "qdisc", raw)
+}
+
+// Rates returns the rates of the qdisc over the window
+func (t TestRateSampler) Rates(obj TestObject, window time.Duration) (bps, pps float64, ok bool) {
+	h, found := t.rs.history[rateKey{ns: "default", ifindex: obj.Ifindex, object: "qdisc", handle: obj.Handle, parent: obj.Parent}]
+	if !found {
+		return 0, 0, false
+	}
+	return h.rates(window)
+}
+
+// RecordDepth records a poll of the qdiscs of the interface at time now
+func (ds *DepthSampler) RecordDepth(now time.Time, ns string, interf rtnetlink.LinkMessage, objs ...TestObject) {
+	raw := make([]rawObject, 0, len(objs))
+	for _, obj := range objs {
+		raw = append(raw, obj.raw())
+	}
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	lk := linkKey{ns, interf.Index}
+	st, found := ds.links[lk]
+	if !found {
+		st = &samplerStats{}
+		ds.links[lk] = st
+	}
+	st.round++
+	ds.record(ns, interf, "qdisc", raw, st.round, now)
+}
+
+// MatchThresholds compiles the thresholds and returns the counters that are kept for the object,
+// as "metric value"
+func MatchThresholds(cfg []Threshold, ns, link, kind string, handle uint32) ([]string, error) {
+	ths, err := compileThresholds(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for key := range matchThresholds(ths, ns, link, kind, handle) {
+		keys = append(keys, fmt.Sprintf("%s %d", key.metric, key.value))
+	}
+	slices.Sort(keys)
+	return keys, nil
+}
+
+// NewTestMonotonicTracker creates a MonotonicTracker that tracks the counters of the descs
+func NewTestMonotonicTracker(descs ...*prometheus.Desc) *MonotonicTracker {
+	mt := NewMonotonicTracker(nil, nil, MonotonicConfig{}, testLogger)
+	for _, d := range descs {
+		mt.counters[d] = true
+	}
+	return mt
+}
+
+// Process runs the metrics of a scrape through the tracker
+func (mt *MonotonicTracker) Process(metrics []prometheus.Metric) []prometheus.Metric {
+	return mt.process(metrics)
+}
+
+// CollectClasses folds the classes of the interface into their groups and exports the groups, like
+// TcCollector does. It returns the handles of the classes that are exported one by one.
+func (agg *Aggregator) CollectClasses(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, qdiscs, classes []tc.Object) []uint32 {
+	tree := NewObjectTree(qdiscs, classes)
+	groups := make(map[groupKey]*groupStats)
+	var single []uint32
+	for _, cl := range classes {
+		if !agg.Fold(groups, cl, tree.Ancestors(cl.Handle, cl.Parent), tree.IsLeaf(cl.Handle)) {
+			single = append(single, cl.Handle)
+		}
+	}
+	agg.CollectInterface(ch, host, ns, interf, groups)
+	return single
+}
+
+// NewTestCardinalityGuard creates a CardinalityGuard that ranks the objects on the bytes desc
+func NewTestCardinalityGuard(limits LimitsConfig, bytes *prometheus.Desc) *CardinalityGuard {
+	cg := NewCardinalityGuard(nil, limits, testLogger)
+	cg.bytes[bytes] = true
+	return cg
+}
+
+// Process runs the metrics of a scrape through the guard
+func (cg *CardinalityGuard) Process(metrics []prometheus.Metric) []prometheus.Metric {
+	return cg.process(metrics)
+}
+
+// TestRawObject is a raw tc object with the options encoded as the kernel sends them. Attrs and
+// Stats2 hold the other attributes and the stats.
+type TestRawObject struct {
+	Handle  uint32
+	Parent  uint32
+	Info    uint32
+	Kind    string
+	Options []byte
+	Attrs   map[uint16][]byte
+	Stats2  map[uint16][]byte
+}
+
+// raw returns the rawObject of interface devid
+func (o TestRawObject) raw(devid uint32) rawObject {
+	attrs := map[uint16][]byte{tcaKind: append([]byte(o.Kind), 0)}
+	for typ, b := range o.Attrs {
+		attrs[typ] = b
+	}
+	if o.Options != nil {
+		attrs[tcaOptions] = o.Options
+	}
+	return rawObject{
+		Ifindex: devid,
+		Handle:  o.Handle,
+		Parent:  o.Parent,
+		Info:    o.Info,
+		Kind:    o.Kind,
+		Attrs:   attrs,
+		Stats2:  o.Stats2,
+	}
+}
+
+// TestRawDump is a dump of an interface that holds the given objects instead of going to the kernel
+type TestRawDump struct {
+	dump *rawDump
+}
+
+// NewTestRawDump creates a dump of interface devid that holds the objects
+func NewTestRawDump(devid uint32, objs ...TestRawObject) TestRawDump {
+	raws := make([]rawObject, 0, len(objs))
+	for _, o := range objs {
+		raws = append(raws, o.raw(devid))
+	}
+	return TestRawDump{&rawDump{devid: devid, dumped: true, list: raws, objs: indexRawObjects(raws)}}
+}
+
+// Collect passes the object to the collector together with the dump
+func (d TestRawDump) Collect(col ObjectCollector, ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, obj tc.Object) {
+	collectObject(col, ch, host, ns, interf, obj, d.dump)
+}
+
+// KindOptions decodes the flags, link layer and overhead labels from the encoded options of a kind
+func KindOptions(kind string, b []byte) (flags []string, linklayer, overhead string) {
+	opts := kindOptions(kind, b)
+	flags = slices.Clone(opts.flags)
+	slices.Sort(flags)
+	return flags, opts.linklayer, opts.overhead
+}
+
+// CollectInterfaceDumps passes the objects of the interface to the collector together with the
+// dumps of the qdiscs and the classes
+func CollectInterfaceDumps(col InterfaceCollector, ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, qdiscs, classes []tc.Object, rawQdiscs, rawClasses TestRawDump) {
+	collectInterface(col, ch, host, ns, interf, qdiscs, classes, rawQdiscs.dump, rawClasses.dump)
+}
+
+// SplitCounters adds the encoded gnet stats of the objects and returns the hardware and software
+// counters, ok is false when the stats of the last object have no hardware counters
+func SplitCounters(stats ...map[uint16][]byte) (hwBytes, hwPackets, swBytes, swPackets float64, ok bool) {
+	var s splitCounters
+	for _, st := range stats {
+		ok = s.add(st)
+	}
+	return s.hwBytes, s.hwPackets, s.swBytes, s.swPackets, ok
+}
+
+// TestAction is a decoded action of a filter
+type TestAction struct {
+	Kind      string
+	Index     uint32
+	InHwCount uint32
+	Options   []uint16
+	LastUsed  float64
+}
+
+// TestFilter is a decoded filter
+type TestFilter struct {
+	Chain    uint32
+	Prio     uint32
+	Protocol string
+	Options  []uint16
+	Actions  []TestAction
+}
+
+// NewRawFilter decodes the filter of the raw object of interface devid
+func NewRawFilter(devid uint32, obj TestRawObject) (TestFilter, error) {
+	fl, err := newRawFilter(obj.raw(devid))
+	if err != nil {
+		return TestFilter{}, err
+	}
+	return TestFilter{
+		Chain:    fl.Chain,
+		Prio:     fl.prio(),
+		Protocol: protocolName(fl.protocol()),
+		Options:  attrTypes(fl.Options),
+		Actions:  testActions(fl.Actions),
+	}, nil
+}
+
+// ParseActions decodes an encoded list of actions
+func ParseActions(b []byte) ([]TestAction, error) {
+	acts, err := parseActions(b)
+	if err != nil {
+		return nil, err
+	}
+	return testActions(acts), nil
+}
+
+func testActions(acts []rawAction) []TestAction {
+	var out []TestAction
+	for _, act := range acts {
+		lastUsed, _ := act.lastUsed()
+		out = append(out, TestAction{
+			Kind:      act.Kind,
+			Index:     act.Index,
+			InHwCount: act.InHwCount,
+			Options:   attrTypes(act.Options),
+			LastUsed:  lastUsed,
+		})
+	}
+	return out
+}
+
+// attrTypes returns the sorted types of the attributes
+func attrTypes(attrs map[uint16][]byte) []uint16 {
+	var types []uint16
+	for typ := range attrs {
+		types = append(types, typ)
+	}
+	slices.Sort(types)
+	return types
+}
+
+// TestBpfProgram is a BPF program attached by a filter or one of its actions
+type TestBpfProgram struct {
+	Attach       string
+	ID           uint32
+	Name         string
+	Tag          string
+	DirectAction string
+}
+
+// FilterBpfPrograms returns the BPF programs of the filter of the raw object of interface devid
+func FilterBpfPrograms(devid uint32, obj TestRawObject) ([]TestBpfProgram, error) {
+	fl, err := newRawFilter(obj.raw(devid))
+	if err != nil {
+		return nil, err
+	}
+	var progs []TestBpfProgram
+	for _, p := range filterBpfPrograms(fl) {
+		progs = append(progs, TestBpfProgram{p.attach, p.id, p.name, p.tag, p.directAction})
+	}
+	return progs, nil
+}
+
+// BpfIdentity reads the id, name and tag of a program from the cls_bpf options
+func BpfIdentity(attrs map[uint16][]byte) (uint32, string, string) {
+	return bpfIdentity(attrs, tcaBpfID, tcaBpfName, tcaBpfTag)
+}
+
+// DecodeMatch decodes the match of the filter of the raw object of interface devid
+func DecodeMatch(devid uint32, obj TestRawObject, maxLength int) (string, error) {
+	fl, err := newRawFilter(obj.raw(devid))
+	if err != nil {
+		return "", err
+	}
+	return decodeMatch(fl, maxLength), nil
+}
+
+// CollectActionMessages decodes the messages of an action table dump and exports their actions,
+// names maps the interface indexes to their names
+func CollectActionMessages(col NamespaceCollector, ch chan<- prometheus.Metric, host, ns string, names map[uint32]string, msgs ...[]byte) error {
+	nlMsgs := make([]netlink.Message, 0, len(msgs))
+	for _, m := range msgs {
+		nlMsgs = append(nlMsgs, netlink.Message{Data: m})
+	}
+	actions, err := parseActionMessages(nlMsgs)
+	if err != nil {
+		return err
+	}
+	for _, act := range actions {
+		col.(*ActionCollector).collectAction(ch, host, ns, act, names)
+	}
+	return nil
+}
+
+// CollectDump pairs the flows of the class dump with their qdiscs and exports them
+func (col *FlowCollector) CollectDump(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, qdiscs, classes []tc.Object) {
+	owners := flowOwners(qdiscs, classes)
+	var flows []qdiscFlow
+	for i, cl := range classes {
+		if qdisc, found := owners[i]; found && isFlowClass(cl) {
+			flows = append(flows, qdiscFlow{flow: cl, qdisc: qdisc})
+		}
+	}
+	col.CollectInterface(ch, host, ns, interf, flows)
+}
+
+// FilterParents returns the parents the filters of the interface are dumped for
+func FilterParents(qdiscs, classes []tc.Object) []uint32 {
+	return filterParents(qdiscs, classes)
+}
+
+// CollectBlockBindings exports the shared blocks the qdiscs of the interfaces are bound to,
+// qdiscs[i] being the qdiscs of links[i]
+func CollectBlockBindings(col InterfaceCollector, ch chan<- prometheus.Metric, host, ns string, links []rtnetlink.LinkMessage, qdiscs [][]tc.Object) {
+	blocks := make(map[uint32][]blockBinding)
+	for i, link := range links {
+		addBlockBindings(blocks, link, qdiscs[i])
+	}
+	for block, bindings := range blocks {
+		col.(*FilterCollector).collectBindings(ch, host, ns, block, bindings)
+	}
+}
+
+// CollectFilters exports the filters of the raw objects of the interface, or of the shared block
+// when devid is TCM_IFINDEX_MAGIC_BLOCK
+func CollectFilters(col InterfaceCollector, ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, devid uint32, objs ...TestRawObject) error {
+	filters, err := testFilters(devid, objs)
+	if err != nil {
+		return err
+	}
+	col.(*FilterCollector).collectFilters(ch, host, ns, interf, filters)
+	return nil
+}
+
+// CollectChains exports the number of filters of the chains of the parent, for the interface or
+// for the shared block parent when devid is TCM_IFINDEX_MAGIC_BLOCK
+func CollectChains(col InterfaceCollector, ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, devid, parent uint32, chains []uint32, objs ...TestRawObject) error {
+	filters, err := testFilters(devid, objs)
+	if err != nil {
+		return err
+	}
+	col.(*FilterCollector).exportChains(ch, host, ns, interf, devid, parent, chains, filters)
+	return nil
+}
+
+// testFilters decodes the filters of the raw objects of interface devid
+func testFilters(devid uint32, objs []TestRawObject) ([]rawFilter, error) {
+	var filters []rawFilter
+	for _, obj := range objs {
+		fl, err := newRawFilter(obj.raw(devid))
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, fl)
+	}
+	return filters, nil
+}
@@ -0,0 +1,148 @@
+package tccollector
+
+import (
+	"fmt"
+	"path"
+)
+
+// FilterHolder holds the rules that select the qdiscs and classes to collect
+type FilterHolder struct {
+	Qdisc []Filter `mapstructure:"qdisc"`
+	Class []Filter `mapstructure:"class"`
+}
+
+// Filter is a single include or exclude rule. An object matches the rule when it matches all the
+// fields that are set, a rule without fields matches everything. Handles are written in tc
+// notation (1:10, 1:, root, ingress).
+type Filter struct {
+	// Action is include or exclude, rules without an action exclude
+	Action string `mapstructure:"action"`
+	NetNS  string `mapstructure:"netns"`
+	// Link is a glob pattern for the interface name
+	Link   string `mapstructure:"link"`
+	Kind   string `mapstructure:"kind"`
+	Handle string `mapstructure:"handle"`
+	Parent string `mapstructure:"parent"`
+	// Subtree matches the object with this handle and everything below it
+	Subtree string `mapstructure:"subtree"`
+}
+
+// FilterTarget is the qdisc or class a RuleSet is evaluated against
+type FilterTarget struct {
+	NetNS  string
+	Link   string
+	Kind   string
+	Handle uint32
+	Parent uint32
+	// Ancestors are the handles of the objects above the target, see ObjectTree.Ancestors
+	Ancestors []uint32
+}
+
+type rule struct {
+	include bool
+	netns   string
+	link    string
+	kind    string
+	handle  *uint32
+	parent  *uint32
+	subtree *uint32
+}
+
+// RuleSet is a compiled list of filters. The rules are evaluated in order and the first rule that
+// matches decides. Objects that match no rule are collected, unless there are include rules: then
+// only the included objects are collected.
+type RuleSet struct {
+	rules          []rule
+	defaultInclude bool
+}
+
+// NewRuleSet compiles the filters into a RuleSet
+func NewRuleSet(filters []Filter) (*RuleSet, error) {
+	rs := &RuleSet{defaultInclude: true}
+	for _, f := range filters {
+		r := rule{
+			netns: f.NetNS,
+			link:  f.Link,
+			kind:  f.Kind,
+		}
+		switch f.Action {
+		case "include":
+			r.include = true
+			rs.defaultInclude = false
+		case "", "exclude":
+		default:
+			return nil, fmt.Errorf("invalid filter action %q", f.Action)
+		}
+		if _, err := path.Match(f.Link, ""); err != nil {
+			return nil, fmt.Errorf("invalid link pattern %q: %w", f.Link, err)
+		}
+		var err error
+		if r.handle, err = parseOptionalHandle(f.Handle); err != nil {
+			return nil, err
+		}
+		if r.parent, err = parseOptionalHandle(f.Parent); err != nil {
+			return nil, err
+		}
+		if r.subtree, err = parseOptionalHandle(f.Subtree); err != nil {
+			return nil, err
+		}
+		rs.rules = append(rs.rules, r)
+	}
+	return rs, nil
+}
+
+// parseOptionalHandle parses the handle of a filter field, nil means the field is not set
+func parseOptionalHandle(s string) (*uint32, error) {
+	if s == "" {
+		return nil, nil
+	}
+	handle, err := ParseHandle(s)
+	if err != nil {
+		return nil, err
+	}
+	return &handle, nil
+}
+
+// Includes reports if the object should be collected
+func (rs *RuleSet) Includes(t FilterTarget) bool {
+	for _, r := range rs.rules {
+		if r.matches(t) {
+			return r.include
+		}
+	}
+	return rs.defaultInclude
+}
+
+// matches checks if the object matches all the fields of the rule
+func (r rule) matches(t FilterTarget) bool {
+	if r.netns != "" && r.netns != t.NetNS {
+		return false
+	}
+	if r.link != "" {
+		if ok, _ := path.Match(r.link, t.Link); !ok {
+			return false
+		}
+	}
+	if r.kind != "" && r.kind != t.Kind {
+		return false
+	}
+	if r.handle != nil && *r.handle != t.Handle {
+		return false
+	}
+	if r.parent != nil && *r.parent != t.Parent {
+		return false
+	}
+	if r.subtree != nil && *r.subtree != t.Handle {
+		found := false
+		for _, a := range t.Ancestors {
+			if a == *r.subtree {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,147 @@
+package tccollector_test
+
+import (
+	"testing"
+
+	tcexporter "github.com/fbegyn/tc_exporter/collector"
+	"github.com/florianl/go-tc"
+)
+
+func TestRuleSet(t *testing.T) {
+	qdiscs, classes := testTree()
+	tree := tcexporter.NewObjectTree(qdiscs, classes)
+	target := func(link string, obj tc.Object) tcexporter.FilterTarget {
+		return tcexporter.FilterTarget{
+			NetNS:     "default",
+			Link:      link,
+			Kind:      obj.Kind,
+			Handle:    obj.Handle,
+			Parent:    obj.Parent,
+			Ancestors: tree.Ancestors(obj.Handle, obj.Parent),
+		}
+	}
+	htb, leaf10, leaf20, ingress := qdiscs[0], qdiscs[1], qdiscs[2], qdiscs[3]
+
+	tests := []struct {
+		name     string
+		filters  []tcexporter.Filter
+		target   tcexporter.FilterTarget
+		included bool
+	}{
+		{name: "no filters", target: target("eth0", htb), included: true},
+		{name: "exclude kind",
+			filters:  []tcexporter.Filter{{Kind: "fq_codel"}},
+			target:   target("eth0", leaf10),
+			included: false},
+		{name: "exclude kind other object",
+			filters:  []tcexporter.Filter{{Action: "exclude", Kind: "fq_codel"}},
+			target:   target("eth0", htb),
+			included: true},
+		{name: "parent in hex",
+			filters:  []tcexporter.Filter{{Parent: "1:20"}},
+			target:   target("eth0", leaf20),
+			included: false},
+		{name: "handle",
+			filters:  []tcexporter.Filter{{Handle: "ffff:"}},
+			target:   target("eth0", ingress),
+			included: false},
+		{name: "parent ingress",
+			filters:  []tcexporter.Filter{{Parent: "ingress"}},
+			target:   target("eth0", ingress),
+			included: false},
+		{name: "all fields have to match",
+			filters:  []tcexporter.Filter{{Kind: "fq_codel", Parent: "1:20"}},
+			target:   target("eth0", leaf10),
+			included: true},
+		{name: "link pattern",
+			filters:  []tcexporter.Filter{{Link: "veth*"}},
+			target:   target("veth12", htb),
+			included: false},
+		{name: "link pattern no match",
+			filters:  []tcexporter.Filter{{Link: "veth*"}},
+			target:   target("eth0", htb),
+			included: true},
+		{name: "netns",
+			filters:  []tcexporter.Filter{{NetNS: "testing01"}},
+			target:   target("eth0", htb),
+			included: true},
+		{name: "subtree includes the object itself",
+			filters:  []tcexporter.Filter{{Subtree: "1:20"}},
+			target:   target("eth0", classes[2]),
+			included: false},
+		{name: "subtree below class",
+			filters:  []tcexporter.Filter{{Subtree: "1:20"}},
+			target:   target("eth0", leaf20),
+			included: false},
+		{name: "subtree sibling",
+			filters:  []tcexporter.Filter{{Subtree: "1:20"}},
+			target:   target("eth0", leaf10),
+			included: true},
+		{name: "subtree root",
+			filters:  []tcexporter.Filter{{Subtree: "root"}},
+			target:   target("eth0", ingress),
+			included: true},
+		{name: "include rule excludes the rest",
+			filters:  []tcexporter.Filter{{Action: "include", Kind: "htb"}},
+			target:   target("eth0", leaf10),
+			included: false},
+		{name: "include rule",
+			filters:  []tcexporter.Filter{{Action: "include", Kind: "htb"}},
+			target:   target("eth0", htb),
+			included: true},
+		{name: "first match wins",
+			filters: []tcexporter.Filter{
+				{Action: "exclude", Handle: "10:"},
+				{Action: "include", Subtree: "1:"},
+			},
+			target:   target("eth0", leaf10),
+			included: false},
+		{name: "first match wins include",
+			filters: []tcexporter.Filter{
+				{Action: "include", Subtree: "1:"},
+				{Action: "exclude", Handle: "10:"},
+			},
+			target:   target("eth0", leaf10),
+			included: true},
+		{name: "catch all exclude",
+			filters: []tcexporter.Filter{
+				{Action: "include", Link: "eth0"},
+				{Action: "exclude"},
+			},
+			target:   target("eth1", htb),
+			included: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs, err := tcexporter.NewRuleSet(tt.filters)
+			if err != nil {
+				t.Fatalf("failed to compile filters: %v", err)
+			}
+			if included := rs.Includes(tt.target); included != tt.included {
+				t.Fatalf("unexpected result: got %t, want %t", included, tt.included)
+			}
+		})
+	}
+}
+
+func TestRuleSetInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter tcexporter.Filter
+	}{
+		{name: "action", filter: tcexporter.Filter{Action: "drop"}},
+		{name: "link pattern", filter: tcexporter.Filter{Link: "eth["}},
+		{name: "handle", filter: tcexporter.Filter{Handle: "10"}},
+		{name: "parent", filter: tcexporter.Filter{Parent: "1:fffff"}},
+		{name: "subtree", filter: tcexporter.Filter{Subtree: "x:1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tcexporter.NewRuleSet([]tcexporter.Filter{tt.filter}); err == nil {
+				t.Fatalf("expected an error for %+v", tt.filter)
+			}
+		})
+	}
+}
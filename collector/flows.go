@@ -0,0 +1,352 @@
+package tccollector
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+
+	"github.com/florianl/go-tc"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	flowLabels        []string = []string{"host", "netns", "linkindex", "link", "type", "handle", "parent", "qdisc_parent"}
+	flowSummaryLabels []string = []string{"host", "netns", "linkindex", "link", "type", "handle", "parent"}
+)
+
+// FlowsConfig configures the per flow stats of the fq_codel and sfq qdiscs
+type FlowsConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Top is the number of flows with the largest backlog exported per qdisc
+	Top int `mapstructure:"top"`
+}
+
+// isFlowClass reports if the class is a flow of a fq_codel or sfq qdisc. These qdiscs are
+// classless, the kernel dumps their active flows as classes.
+func isFlowClass(cl tc.Object) bool {
+	return cl.Kind == "fq_codel" || cl.Kind == "sfq"
+}
+
+// qdiscFlow is a flow and the handle and parent of the qdisc it belongs to
+type qdiscFlow struct {
+	flow  tc.Object
+	qdisc rawKey
+}
+
+// flowQdisc are the flows of a single qdisc
+type flowQdisc struct {
+	kind  string
+	flows []tc.Object
+}
+
+// flowOwners pairs the flows of the class dump with the qdisc they belong to, by the index of the
+// flow in classes. The kernel dumps a flow with the handle of its qdisc and TC_H_ROOT as parent,
+// which does not tell the fq_codel children of mq apart as they all have handle 0:. The qdisc and
+// the class dump walk the qdiscs in the same order and the flows of a qdisc are dumped together by
+// increasing minor, so the flows are handed out to the qdiscs with their handle in dump order: a
+// fq_codel qdisc owns as many flows as are on its flow lists, a sfq qdisc with packets queued owns
+// one run of flows. Flows that can not be paired, because the qdiscs changed between the dumps,
+// are left out.
+func flowOwners(qdiscs, classes []tc.Object) map[int]rawKey {
+	type flowKey struct {
+		kind   string
+		handle uint32
+	}
+	candidates := make(map[flowKey][]tc.Object)
+	for _, qd := range qdiscs {
+		if isFlowClass(qd) {
+			key := flowKey{qd.Kind, qd.Handle}
+			candidates[key] = append(candidates[key], qd)
+		}
+	}
+
+	// the runs of consecutive flows with increasing minors, as indexes in classes
+	runs := make(map[flowKey][][]int)
+	prev := -1
+	for i, cl := range classes {
+		if !isFlowClass(cl) {
+			continue
+		}
+		key := flowKey{cl.Kind, cl.Handle & 0xffff0000}
+		rs := runs[key]
+		if prev == i-1 && prev >= 0 && classes[prev].Kind == cl.Kind &&
+			classes[prev].Handle&0xffff0000 == key.handle && classes[prev].Handle < cl.Handle {
+			rs[len(rs)-1] = append(rs[len(rs)-1], i)
+		} else {
+			rs = append(rs, []int{i})
+		}
+		runs[key] = rs
+		prev = i
+	}
+
+	owners := make(map[int]rawKey)
+	for key, rs := range runs {
+		qds := candidates[key]
+		switch {
+		case len(qds) == 1:
+			for _, i := range slices.Concat(rs...) {
+				owners[i] = rawKey{handle: qds[0].Handle, parent: qds[0].Parent}
+			}
+		case key.kind == "fq_codel":
+			pairFqCodelFlows(owners, qds, rs)
+		default:
+			// only the sfq qdiscs with packets queued have flows
+			var active []tc.Object
+			for _, qd := range qds {
+				if s, ok := readStats(qd); ok && s.qlen > 0 {
+					active = append(active, qd)
+				}
+			}
+			if len(active) != len(rs) {
+				continue
+			}
+			for n, r := range rs {
+				for _, i := range r {
+					owners[i] = rawKey{handle: active[n].Handle, parent: active[n].Parent}
+				}
+			}
+		}
+	}
+	return owners
+}
+
+// pairFqCodelFlows hands out the runs of flows to the fq_codel qdiscs with the same handle by the
+// length of their flow lists. The flows of a qdisc never span the start of a run.
+func pairFqCodelFlows(owners map[int]rawKey, qds []tc.Object, runs [][]int) {
+	starts := make(map[int]bool, len(runs))
+	var flows []int
+	for _, r := range runs {
+		starts[len(flows)] = true
+		flows = append(flows, r...)
+	}
+
+	pairs := make(map[int]rawKey, len(flows))
+	off := 0
+	for _, qd := range qds {
+		if qd.XStats == nil || qd.XStats.FqCodel == nil || qd.XStats.FqCodel.Qd == nil {
+			return
+		}
+		n := int(qd.XStats.FqCodel.Qd.NewFlowsLen + qd.XStats.FqCodel.Qd.OldFlowsLen)
+		if off+n > len(flows) {
+			return
+		}
+		for j := off; j < off+n; j++ {
+			if j > off && starts[j] {
+				return
+			}
+			pairs[flows[j]] = rawKey{handle: qd.Handle, parent: qd.Parent}
+		}
+		off += n
+	}
+	if off != len(flows) {
+		return
+	}
+	maps.Copy(owners, pairs)
+}
+
+// FlowCollector exports the stats of the flows of fq_codel and sfq qdiscs. Only the flows with the
+// largest backlog are exported one by one, summaries cover all flows of the qdisc.
+type FlowCollector struct {
+	logger     slog.Logger
+	top        int
+	active     *prometheus.Desc
+	maxDelay   *prometheus.Desc
+	maxBacklog *prometheus.Desc
+	backlog    *prometheus.Desc
+	qlen       *prometheus.Desc
+	deficit    *prometheus.Desc
+	delay      *prometheus.Desc
+	count      *prometheus.Desc
+	dropping   *prometheus.Desc
+	allot      *prometheus.Desc
+}
+
+// NewFlowCollector create a new FlowCollector
+func NewFlowCollector(cfg FlowsConfig, log *slog.Logger) (*FlowCollector, error) {
+	log = log.With("collector", "flows")
+	log.Info("making flow collector")
+	top := cfg.Top
+	if top <= 0 {
+		top = 10
+	}
+
+	return &FlowCollector{
+		logger: *log,
+		top:    top,
+		active: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "flows", "active"),
+			"Number of flows of the qdisc with packets queued",
+			flowSummaryLabels, nil,
+		),
+		maxDelay: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "flows", "max_delay_seconds"),
+			"Largest sojourn time of the last dequeued packet over the flows of the qdisc",
+			flowSummaryLabels, nil,
+		),
+		maxBacklog: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "flows", "max_backlog_bytes"),
+			"Largest backlog over the flows of the qdisc",
+			flowSummaryLabels, nil,
+		),
+		backlog: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "flow", "backlog_bytes"),
+			"Backlog of the flow",
+			flowLabels, nil,
+		),
+		qlen: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "flow", "qlen"),
+			"Queue length of the flow",
+			flowLabels, nil,
+		),
+		deficit: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "flow", "deficit_bytes"),
+			"Deficit of the flow",
+			flowLabels, nil,
+		),
+		delay: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "flow", "delay_seconds"),
+			"Sojourn time of the last packet dequeued from the flow",
+			flowLabels, nil,
+		),
+		count: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "flow", "codel_count"),
+			"CoDel drop count of the flow",
+			flowLabels, nil,
+		),
+		dropping: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "flow", "dropping"),
+			"Whether CoDel is in dropping state for the flow",
+			flowLabels, nil,
+		),
+		allot: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "flow", "allot_bytes"),
+			"Allotment of the sfq flow",
+			flowLabels, nil,
+		),
+	}, nil
+}
+
+// Describe implements Collector
+func (col *FlowCollector) Describe(ch chan<- *prometheus.Desc) {
+	ds := []*prometheus.Desc{
+		col.active,
+		col.maxDelay,
+		col.maxBacklog,
+		col.backlog,
+		col.qlen,
+		col.deficit,
+		col.delay,
+		col.count,
+		col.dropping,
+		col.allot,
+	}
+
+	for _, d := range ds {
+		ch <- d
+	}
+}
+
+// CollectInterface exports the flows of the qdiscs of the interface
+func (col *FlowCollector) CollectInterface(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, flows []qdiscFlow) {
+	qdiscs := make(map[rawKey]*flowQdisc)
+	for _, fl := range flows {
+		qd, found := qdiscs[fl.qdisc]
+		if !found {
+			qd = &flowQdisc{kind: fl.flow.Kind}
+			qdiscs[fl.qdisc] = qd
+		}
+		qd.flows = append(qd.flows, fl.flow)
+	}
+
+	for key, qd := range qdiscs {
+		handleMaj, handleMin := HandleStr(key.handle)
+		parentMaj, parentMin := HandleStr(key.parent)
+		labels := []string{
+			host,
+			ns,
+			fmt.Sprintf("%d", interf.Index),
+			interf.Attributes.Name,
+			qd.kind,
+			fmt.Sprintf("%x:%x", handleMaj, handleMin),
+			fmt.Sprintf("%x:%x", parentMaj, parentMin),
+		}
+
+		var maxBacklog, maxDelay float64
+		backlogs := make(map[uint32]float64, len(qd.flows))
+		for _, fl := range qd.flows {
+			if s, ok := readStats(fl); ok {
+				backlogs[fl.Handle] = s.backlog
+				maxBacklog = max(maxBacklog, s.backlog)
+			}
+			if cl := flowCodelStats(fl); cl != nil {
+				maxDelay = max(maxDelay, float64(cl.LDelay)/1e6)
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(col.active, prometheus.GaugeValue, float64(len(qd.flows)), labels...)
+		ch <- prometheus.MustNewConstMetric(col.maxBacklog, prometheus.GaugeValue, maxBacklog, labels...)
+		if qd.kind == "fq_codel" {
+			ch <- prometheus.MustNewConstMetric(col.maxDelay, prometheus.GaugeValue, maxDelay, labels...)
+		}
+
+		// only the flows with the largest backlog, ties on the handle to keep the selection stable
+		slices.SortFunc(qd.flows, func(a, b tc.Object) int {
+			return cmp.Or(
+				cmp.Compare(backlogs[b.Handle], backlogs[a.Handle]),
+				cmp.Compare(a.Handle, b.Handle),
+			)
+		})
+		if len(qd.flows) > col.top {
+			qd.flows = qd.flows[:col.top]
+		}
+		for _, fl := range qd.flows {
+			col.collectFlow(ch, host, ns, interf, fl, key)
+		}
+	}
+}
+
+// collectFlow exports the stats of a single flow
+func (col *FlowCollector) collectFlow(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, fl tc.Object, qdisc rawKey) {
+	handleMaj, handleMin := HandleStr(fl.Handle)
+	parentMaj, parentMin := HandleStr(qdisc.handle)
+	qdiscParentMaj, qdiscParentMin := HandleStr(qdisc.parent)
+	labels := []string{
+		host,
+		ns,
+		fmt.Sprintf("%d", interf.Index),
+		interf.Attributes.Name,
+		fl.Kind,
+		fmt.Sprintf("%x:%x", handleMaj, handleMin),
+		fmt.Sprintf("%x:%x", parentMaj, parentMin),
+		fmt.Sprintf("%x:%x", qdiscParentMaj, qdiscParentMin),
+	}
+
+	if s, ok := readStats(fl); ok {
+		ch <- prometheus.MustNewConstMetric(col.backlog, prometheus.GaugeValue, s.backlog, labels...)
+		ch <- prometheus.MustNewConstMetric(col.qlen, prometheus.GaugeValue, s.qlen, labels...)
+	}
+	if cl := flowCodelStats(fl); cl != nil {
+		dropping := 0.0
+		if cl.Dropping != 0 {
+			dropping = 1
+		}
+		ch <- prometheus.MustNewConstMetric(col.deficit, prometheus.GaugeValue, float64(cl.Deficit), labels...)
+		// the kernel reports the delay in microseconds
+		ch <- prometheus.MustNewConstMetric(col.delay, prometheus.GaugeValue, float64(cl.LDelay)/1e6, labels...)
+		ch <- prometheus.MustNewConstMetric(col.count, prometheus.GaugeValue, float64(cl.Count), labels...)
+		ch <- prometheus.MustNewConstMetric(col.dropping, prometheus.GaugeValue, dropping, labels...)
+	}
+	if fl.Kind == "sfq" && fl.XStats != nil && fl.XStats.Sfq != nil {
+		ch <- prometheus.MustNewConstMetric(col.allot, prometheus.GaugeValue, float64(fl.XStats.Sfq.Allot), labels...)
+	}
+}
+
+// flowCodelStats returns the CoDel stats of a fq_codel flow
+func flowCodelStats(fl tc.Object) *tc.FqCodelClStats {
+	if fl.Kind != "fq_codel" || fl.XStats == nil || fl.XStats.FqCodel == nil {
+		return nil
+	}
+	return fl.XStats.FqCodel.Cl
+}
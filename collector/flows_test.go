@@ -0,0 +1,208 @@
+package tccollector_test
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+
+	tcexporter "github.com/fbegyn/tc_exporter/collector"
+	"github.com/florianl/go-tc"
+	"github.com/florianl/go-tc/core"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestFlowCollectorTop(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	col, err := tcexporter.NewFlowCollector(tcexporter.FlowsConfig{Top: 2}, logger)
+	if err != nil {
+		t.Fatalf("failed to create flow collector: %v", err)
+	}
+	interf := rtnetlink.LinkMessage{Index: 1, Attributes: &rtnetlink.LinkAttributes{Name: "eth0"}}
+	flow := func(minor, backlog, ldelay uint32) tc.Object {
+		return tc.Object{
+			Msg: tc.Msg{Handle: core.BuildHandle(0x10, minor), Parent: tc.HandleRoot},
+			Attribute: tc.Attribute{
+				Kind:   "fq_codel",
+				Stats2: &tc.Stats2{Backlog: backlog, Qlen: 1},
+				XStats: &tc.XStats{FqCodel: &tc.FqCodelXStats{Cl: &tc.FqCodelClStats{LDelay: ldelay}}},
+			},
+		}
+	}
+
+	qdisc := tc.Object{Msg: tc.Msg{Handle: core.BuildHandle(0x10, 0), Parent: tc.HandleRoot}, Attribute: tc.Attribute{Kind: "fq_codel"}}
+	ch := make(chan prometheus.Metric, 64)
+	col.CollectDump(ch, "host", "default", interf, []tc.Object{qdisc}, []tc.Object{
+		flow(1, 1500, 2000),
+		flow(2, 9000, 40000),
+		flow(3, 3000, 1000),
+	})
+	close(ch)
+
+	values := make(map[string]float64)
+	flows := make(map[string]bool)
+	for m := range ch {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatalf("failed to write metric: %v", err)
+		}
+		desc := m.Desc().String()
+		name := desc[strings.Index(desc, `"`)+1:]
+		name = name[:strings.Index(name, `"`)]
+		values[name] = pb.GetGauge().GetValue()
+		if name == "tc_flow_backlog_bytes" {
+			for _, lp := range pb.Label {
+				if lp.GetName() == "handle" {
+					flows[lp.GetValue()] = true
+				}
+			}
+		}
+	}
+
+	if values["tc_flows_active"] != 3 {
+		t.Fatalf("unexpected active flows: %v", values["tc_flows_active"])
+	}
+	if values["tc_flows_max_backlog_bytes"] != 9000 {
+		t.Fatalf("unexpected max backlog: %v", values["tc_flows_max_backlog_bytes"])
+	}
+	if values["tc_flows_max_delay_seconds"] != 0.04 {
+		t.Fatalf("unexpected max delay: %v", values["tc_flows_max_delay_seconds"])
+	}
+	if len(flows) != 2 || !flows["10:2"] || !flows["10:3"] {
+		t.Fatalf("unexpected flows exported: %v", flows)
+	}
+}
+
+func TestFlowCollectorMqChildren(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	col, err := tcexporter.NewFlowCollector(tcexporter.FlowsConfig{Top: 10}, logger)
+	if err != nil {
+		t.Fatalf("failed to create flow collector: %v", err)
+	}
+	interf := rtnetlink.LinkMessage{Index: 2, Attributes: &rtnetlink.LinkAttributes{Name: "eth0"}}
+
+	mq := tc.Object{Msg: tc.Msg{Handle: core.BuildHandle(1, 0), Parent: tc.HandleRoot}, Attribute: tc.Attribute{Kind: "mq"}}
+	// the fq_codel children of mq all have handle 0:
+	fqCodel := func(parent uint32, flows uint32, qlen uint32) tc.Object {
+		return tc.Object{
+			Msg: tc.Msg{Handle: 0, Parent: parent},
+			Attribute: tc.Attribute{
+				Kind:   "fq_codel",
+				Stats2: &tc.Stats2{Qlen: qlen},
+				XStats: &tc.XStats{FqCodel: &tc.FqCodelXStats{Qd: &tc.FqCodelQdStats{NewFlowsLen: 1, OldFlowsLen: flows - 1}}},
+			},
+		}
+	}
+	sfq := func(handle, parent, qlen uint32) tc.Object {
+		return tc.Object{Msg: tc.Msg{Handle: handle, Parent: parent}, Attribute: tc.Attribute{Kind: "sfq", Stats2: &tc.Stats2{Qlen: qlen}}}
+	}
+	// the kernel dumps the flows with the handle of the qdisc and TC_H_ROOT as parent
+	flow := func(kind string, minor, backlog uint32) tc.Object {
+		return tc.Object{
+			Msg:       tc.Msg{Handle: core.BuildHandle(0, minor), Parent: tc.HandleRoot},
+			Attribute: tc.Attribute{Kind: kind, Stats2: &tc.Stats2{Backlog: backlog, Qlen: 1}},
+		}
+	}
+	mqClass := func(minor uint32) tc.Object {
+		return tc.Object{Msg: tc.Msg{Handle: core.BuildHandle(1, minor), Parent: core.BuildHandle(1, 0)}, Attribute: tc.Attribute{Kind: "mq"}}
+	}
+
+	type flowKey struct {
+		handle, parent, qdiscParent string
+	}
+	tests := []struct {
+		name    string
+		qdiscs  []tc.Object
+		classes []tc.Object
+		active  map[string]float64
+		flows   map[flowKey]float64
+	}{
+		{
+			// the second child starts with a higher minor than the first one ended with, only
+			// the lengths of the flow lists tell them apart
+			name:   "fq_codel",
+			qdiscs: []tc.Object{mq, fqCodel(core.BuildHandle(1, 1), 2, 2), fqCodel(core.BuildHandle(1, 2), 2, 2)},
+			classes: []tc.Object{
+				mqClass(1), mqClass(2),
+				flow("fq_codel", 3, 100), flow("fq_codel", 7, 200),
+				flow("fq_codel", 9, 300), flow("fq_codel", 12, 400),
+			},
+			active: map[string]float64{"1:1": 2, "1:2": 2},
+			flows: map[flowKey]float64{
+				{"0:3", "0:0", "1:1"}: 100,
+				{"0:7", "0:0", "1:1"}: 200,
+				{"0:9", "0:0", "1:2"}: 300,
+				{"0:c", "0:0", "1:2"}: 400,
+			},
+		},
+		{
+			name:   "fq_codel same minors",
+			qdiscs: []tc.Object{mq, fqCodel(core.BuildHandle(1, 1), 1, 1), fqCodel(core.BuildHandle(1, 2), 1, 1)},
+			classes: []tc.Object{
+				flow("fq_codel", 5, 100), flow("fq_codel", 5, 200),
+			},
+			active: map[string]float64{"1:1": 1, "1:2": 1},
+			flows: map[flowKey]float64{
+				{"0:5", "0:0", "1:1"}: 100,
+				{"0:5", "0:0", "1:2"}: 200,
+			},
+		},
+		{
+			// the flow lists changed between the dumps, a flow of the first child would end up
+			// in the second one
+			name:   "fq_codel changed between the dumps",
+			qdiscs: []tc.Object{mq, fqCodel(core.BuildHandle(1, 1), 1, 1), fqCodel(core.BuildHandle(1, 2), 1, 1)},
+			classes: []tc.Object{
+				flow("fq_codel", 5, 100), flow("fq_codel", 2, 200), flow("fq_codel", 8, 300),
+			},
+		},
+		{
+			// only the sfq qdiscs with packets queued have flows
+			name:   "sfq",
+			qdiscs: []tc.Object{mq, sfq(0, core.BuildHandle(1, 1), 3), sfq(0, core.BuildHandle(1, 2), 0), sfq(0, core.BuildHandle(1, 3), 1)},
+			classes: []tc.Object{
+				flow("sfq", 4, 100), flow("sfq", 6, 200),
+				flow("sfq", 2, 300),
+			},
+			active: map[string]float64{"1:1": 2, "1:3": 1},
+			flows: map[flowKey]float64{
+				{"0:4", "0:0", "1:1"}: 100,
+				{"0:6", "0:0", "1:1"}: 200,
+				{"0:2", "0:0", "1:3"}: 300,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics := collectMetrics(t, func(ch chan<- prometheus.Metric) {
+				col.CollectDump(ch, "host", "default", interf, tt.qdiscs, tt.classes)
+			})
+			var active, flows int
+			for _, m := range metrics {
+				switch m.name {
+				case "tc_flows_active":
+					active++
+				case "tc_flow_backlog_bytes":
+					flows++
+				}
+			}
+			if active != len(tt.active) || flows != len(tt.flows) {
+				t.Fatalf("unexpected number of qdiscs and flows: got %d and %d, want %d and %d", active, flows, len(tt.active), len(tt.flows))
+			}
+			for parent, want := range tt.active {
+				got, ok := findMetric(metrics, "tc_flows_active", map[string]string{"handle": "0:0", "parent": parent})
+				if !ok || got != want {
+					t.Fatalf("unexpected active flows of %s: got %v (%t), want %v", parent, got, ok, want)
+				}
+			}
+			for key, want := range tt.flows {
+				got, ok := findMetric(metrics, "tc_flow_backlog_bytes", map[string]string{"handle": key.handle, "parent": key.parent, "qdisc_parent": key.qdiscParent})
+				if !ok || got != want {
+					t.Fatalf("unexpected backlog of flow %s of %s: got %v (%t), want %v", key.handle, key.qdiscParent, got, ok, want)
+				}
+			}
+		})
+	}
+}
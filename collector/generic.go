@@ -0,0 +1,151 @@
+package tccollector
+
+import (
+	"fmt"
+	"log/slog"
+	"reflect"
+	"slices"
+
+	"github.com/florianl/go-tc"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	genericLabels []string = []string{"host", "netns", "linkindex", "link", "type", "handle", "parent", "field"}
+
+	// specificQdiscKinds and specificClassKinds are the kinds with a dedicated collector, they
+	// have to match the kinds of the switches in TcCollector.collect
+	specificQdiscKinds = map[string]bool{
+		"cbq": true, "cbs": true, "choke": true, "codel": true, "etf": true, "pfifo": true,
+		"bfifo": true, "pfifo_head_drop": true, "pfifo_fast": true, "noqueue": true, "fq": true,
+		"fq_codel": true, "hfsc": true, "service_curve": true, "htb": true, "pie": true, "red": true,
+		"gred": true, "skbprio": true, "sfb": true, "sfq": true,
+	}
+	specificClassKinds = map[string]bool{
+		"htb": true, "hfsc": true, "qfq": true, "skbprio": true,
+	}
+)
+
+// GenericConfig configures the fallback collector for the kinds without a dedicated collector
+type GenericConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Kinds is the allowlist of the kinds that are exported, all kinds without a dedicated
+	// collector when empty
+	Kinds []string `mapstructure:"kinds"`
+}
+
+// GenericCollector exports every numeric field of the options and xstats go-tc decoded for an
+// object. The field label is the path of the field in the go-tc structs, like `Parms.Limit`.
+type GenericCollector struct {
+	logger slog.Logger
+	kinds  []string
+	option *prometheus.Desc
+	xstat  *prometheus.Desc
+}
+
+// NewGenericCollector create a new GenericCollector
+func NewGenericCollector(cfg GenericConfig, log *slog.Logger) (*GenericCollector, error) {
+	log = log.With("collector", "generic")
+	log.Info("making generic collector", "kinds", cfg.Kinds)
+
+	return &GenericCollector{
+		logger: *log,
+		kinds:  cfg.Kinds,
+		option: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "generic", "option"),
+			"Numeric option of a qdisc or class without a dedicated collector",
+			genericLabels, nil,
+		),
+		xstat: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "generic", "xstat"),
+			"Numeric xstat of a qdisc or class without a dedicated collector",
+			genericLabels, nil,
+		),
+	}, nil
+}
+
+// Describe implements Collector
+func (col *GenericCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- col.option
+	ch <- col.xstat
+}
+
+// handles reports if the kind is allowed and has no dedicated collector
+func (col *GenericCollector) handles(kind string, specific map[string]bool) bool {
+	if specific[kind] {
+		return false
+	}
+	return len(col.kinds) == 0 || slices.Contains(col.kinds, kind)
+}
+
+// CollectObject exports the numeric fields of the options and xstats of the object
+func (col *GenericCollector) CollectObject(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, obj tc.Object) {
+	handleMaj, handleMin := HandleStr(obj.Handle)
+	parentMaj, parentMin := HandleStr(obj.Parent)
+	labels := []string{
+		host,
+		ns,
+		fmt.Sprintf("%d", interf.Index),
+		interf.Attributes.Name,
+		obj.Kind,
+		fmt.Sprintf("%x:%x", handleMaj, handleMin),
+		fmt.Sprintf("%x:%x", parentMaj, parentMin),
+	}
+	emit := func(desc *prometheus.Desc) func(string, float64) {
+		return func(field string, value float64) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, append(labels, field)...)
+		}
+	}
+
+	// the options of the kind are the struct pointers of the attribute, next to the stats
+	attr := reflect.ValueOf(obj.Attribute)
+	for i := range attr.NumField() {
+		name := attr.Type().Field(i).Name
+		switch name {
+		case "Stats", "Stats2", "Stab":
+			continue
+		case "XStats":
+			walkNumeric("", attr.Field(i), emit(col.xstat))
+			continue
+		}
+		f := attr.Field(i)
+		if f.Kind() != reflect.Pointer || f.IsNil() || f.Elem().Kind() != reflect.Struct {
+			continue
+		}
+		walkNumeric(name, f, emit(col.option))
+	}
+}
+
+// walkNumeric calls fn for every numeric field of v. Structs are walked recursively, the fields
+// of arrays get their index. Slices are skipped, their length is not bounded.
+func walkNumeric(path string, v reflect.Value, fn func(string, float64)) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			walkNumeric(path, v.Elem(), fn)
+		}
+	case reflect.Struct:
+		for i := range v.NumField() {
+			field := v.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			p := field.Name
+			if path != "" {
+				p = path + "." + p
+			}
+			walkNumeric(p, v.Field(i), fn)
+		}
+	case reflect.Array:
+		for i := range v.Len() {
+			walkNumeric(fmt.Sprintf("%s[%d]", path, i), v.Index(i), fn)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		fn(path, float64(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		fn(path, float64(v.Uint()))
+	case reflect.Float32, reflect.Float64:
+		fn(path, v.Float())
+	}
+}
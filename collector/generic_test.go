@@ -0,0 +1,69 @@
+package tccollector_test
+
+import (
+	"log/slog"
+	"os"
+	"testing"
+
+	tcexporter "github.com/fbegyn/tc_exporter/collector"
+	"github.com/florianl/go-tc"
+	"github.com/florianl/go-tc/core"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestGenericCollectorFields(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	col, err := tcexporter.NewGenericCollector(tcexporter.GenericConfig{Enabled: true}, logger)
+	if err != nil {
+		t.Fatalf("failed to create generic collector: %v", err)
+	}
+	interf := rtnetlink.LinkMessage{Index: 1, Attributes: &rtnetlink.LinkAttributes{Name: "eth0"}}
+	qlen := uint32(64)
+	qd := tc.Object{
+		Msg: tc.Msg{Handle: core.BuildHandle(1, 0), Parent: tc.HandleRoot},
+		Attribute: tc.Attribute{
+			Kind:   "hhf",
+			Stats2: &tc.Stats2{Bytes: 1000},
+			Htb:    &tc.Htb{Parms: &tc.HtbOpt{Quantum: 1500}, DirectQlen: &qlen},
+			XStats: &tc.XStats{Hhf: &tc.HhfXStats{DropOverlimit: 3}},
+		},
+	}
+
+	ch := make(chan prometheus.Metric, 128)
+	col.CollectObject(ch, "host", "default", interf, qd)
+	close(ch)
+
+	fields := make(map[string]float64)
+	for m := range ch {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatalf("failed to write metric: %v", err)
+		}
+		for _, lp := range pb.Label {
+			if lp.GetName() == "field" {
+				fields[lp.GetValue()] = pb.GetGauge().GetValue()
+			}
+		}
+	}
+
+	tests := map[string]float64{
+		"Htb.Parms.Quantum":   1500,
+		"Htb.Parms.Rate.Rate": 0,
+		"Htb.DirectQlen":      64,
+		"Hhf.DropOverlimit":   3,
+	}
+	for field, want := range tests {
+		got, found := fields[field]
+		if !found {
+			t.Fatalf("field %s is not exported: %v", field, fields)
+		}
+		if got != want {
+			t.Fatalf("unexpected value for %s: got %v, want %v", field, got, want)
+		}
+	}
+	if _, found := fields["Bytes"]; found {
+		t.Fatalf("the stats should not be exported")
+	}
+}
@@ -0,0 +1,208 @@
+package tccollector
+
+import (
+	"cmp"
+	"log/slog"
+	"slices"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var (
+	droppedLabels []string = []string{"host", "netns", "link", "reason"}
+)
+
+// LimitsConfig configures the maximum number of series of the qdiscs and classes, 0 means no limit
+type LimitsConfig struct {
+	MaxSeries         int `mapstructure:"max-series"`
+	MaxSeriesPerNetNS int `mapstructure:"max-series-per-netns"`
+	MaxSeriesPerLink  int `mapstructure:"max-series-per-link"`
+}
+
+// enabled reports if any limit is configured
+func (cfg LimitsConfig) enabled() bool {
+	return cfg.MaxSeries > 0 || cfg.MaxSeriesPerNetNS > 0 || cfg.MaxSeriesPerLink > 0
+}
+
+// byteCollector is implemented by the object collectors that export the byte counter the objects
+// are ranked on
+type byteCollector interface {
+	byteCounter() *prometheus.Desc
+}
+
+// droppedKey identifies the dropped series counter
+type droppedKey struct {
+	host   string
+	ns     string
+	link   string
+	reason string
+}
+
+// CardinalityGuard limits the number of series a scrape exports. When a limit is exceeded, the
+// objects with the most bytes are kept and all series of the other objects are dropped, so the
+// subset is the same from scrape to scrape.
+type CardinalityGuard struct {
+	logger  slog.Logger
+	limits  LimitsConfig
+	bytes   map[*prometheus.Desc]bool
+	dropped *prometheus.Desc
+
+	mu           sync.Mutex
+	droppedTotal map[droppedKey]float64
+}
+
+// NewCardinalityGuard creates a CardinalityGuard that ranks on the byte counters of the collectors
+func NewCardinalityGuard(collectors map[string]ObjectCollector, limits LimitsConfig, log *slog.Logger) *CardinalityGuard {
+	log = log.With("collector", "guard")
+	log.Info("making cardinality guard", "max-series", limits.MaxSeries,
+		"max-series-per-netns", limits.MaxSeriesPerNetNS, "max-series-per-link", limits.MaxSeriesPerLink)
+
+	bytes := make(map[*prometheus.Desc]bool)
+	for _, col := range collectors {
+		if bc, ok := col.(byteCollector); ok {
+			bytes[bc.byteCounter()] = true
+		}
+	}
+
+	return &CardinalityGuard{
+		logger: *log,
+		limits: limits,
+		bytes:  bytes,
+		dropped: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "series", "dropped_total"),
+			"Number of series that were dropped because a series limit was exceeded",
+			droppedLabels, nil,
+		),
+		droppedTotal: make(map[droppedKey]float64),
+	}
+}
+
+// Describe implements Collector
+func (cg *CardinalityGuard) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cg.dropped
+}
+
+// guardObject are the series of a single qdisc or class
+type guardObject struct {
+	key     objectKey
+	host    string
+	bytes   float64
+	metrics []prometheus.Metric
+}
+
+// process drops the series of the objects that do not fit in the limits. Series that do not
+// belong to a qdisc or class are always kept and do not count against the limits.
+func (cg *CardinalityGuard) process(metrics []prometheus.Metric) []prometheus.Metric {
+	var out []prometheus.Metric
+	objects := make(map[objectKey]*guardObject)
+	for _, m := range metrics {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			out = append(out, m)
+			continue
+		}
+		key, host, ok := metricObject(&pb)
+		if !ok {
+			out = append(out, m)
+			continue
+		}
+		obj, found := objects[key]
+		if !found {
+			obj = &guardObject{key: key, host: host}
+			objects[key] = obj
+		}
+		obj.metrics = append(obj.metrics, m)
+		if cg.bytes[m.Desc()] && pb.Counter != nil {
+			obj.bytes += pb.Counter.GetValue()
+		}
+	}
+
+	ranked := make([]*guardObject, 0, len(objects))
+	for _, obj := range objects {
+		ranked = append(ranked, obj)
+	}
+	slices.SortFunc(ranked, func(a, b *guardObject) int {
+		return cmp.Or(
+			cmp.Compare(b.bytes, a.bytes),
+			cmp.Compare(a.key.ns, b.key.ns),
+			cmp.Compare(a.key.link, b.key.link),
+			cmp.Compare(a.key.handle, b.key.handle),
+			cmp.Compare(a.key.parent, b.key.parent),
+		)
+	})
+
+	var total int
+	perNetNS := make(map[string]int)
+	perLink := make(map[[2]string]int)
+	dropped := make(map[droppedKey]int)
+	for _, obj := range ranked {
+		n := len(obj.metrics)
+		link := [2]string{obj.key.ns, obj.key.link}
+		var reason string
+		switch {
+		case cg.limits.MaxSeriesPerLink > 0 && perLink[link]+n > cg.limits.MaxSeriesPerLink:
+			reason = "link"
+		case cg.limits.MaxSeriesPerNetNS > 0 && perNetNS[obj.key.ns]+n > cg.limits.MaxSeriesPerNetNS:
+			reason = "netns"
+		case cg.limits.MaxSeries > 0 && total+n > cg.limits.MaxSeries:
+			reason = "total"
+		}
+		if reason != "" {
+			dropped[droppedKey{obj.host, obj.key.ns, obj.key.link, reason}] += n
+			continue
+		}
+		total += n
+		perNetNS[obj.key.ns] += n
+		perLink[link] += n
+		out = append(out, obj.metrics...)
+	}
+
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+	for key, n := range dropped {
+		cg.logger.Warn("series limit exceeded, dropping series", "host", key.host, "netns", key.ns, "link", key.link, "reason", key.reason, "dropped", n)
+		cg.droppedTotal[key] += float64(n)
+	}
+	for key, n := range cg.droppedTotal {
+		out = append(out, prometheus.MustNewConstMetric(
+			cg.dropped,
+			prometheus.CounterValue,
+			n,
+			key.host, key.ns, key.link, key.reason,
+		))
+	}
+	return out
+}
+
+// metricObject returns the qdisc or class the metric belongs to and the host it was scraped on. ok
+// is false for metrics without a handle and for the metrics of filters, which have a handle too
+// but are identified by their chain and prio. The type is left out, the name info metrics do not
+// have it. Like the monotonic counters, the parent is only part of the identity of the objects
+// without a handle, the children of mq and mqprio.
+func metricObject(pb *dto.Metric) (key objectKey, host string, ok bool) {
+	var parent string
+	var hasHandle, isFilter bool
+	for _, lp := range pb.Label {
+		switch lp.GetName() {
+		case "host":
+			host = lp.GetValue()
+		case "parent":
+			parent = lp.GetValue()
+		case "prio":
+			isFilter = true
+		case "netns":
+			key.ns = lp.GetValue()
+		case "link":
+			key.link = lp.GetValue()
+		case "handle":
+			key.handle = lp.GetValue()
+			hasHandle = true
+		}
+	}
+	if key.handle == "0:0" {
+		key.parent = parent
+	}
+	return key, host, hasHandle && !isFilter
+}
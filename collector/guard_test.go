@@ -0,0 +1,138 @@
+package tccollector_test
+
+import (
+	"testing"
+
+	tcexporter "github.com/fbegyn/tc_exporter/collector"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCardinalityGuard(t *testing.T) {
+	qdiscLabels := []string{"host", "netns", "linkindex", "link", "type", "handle", "parent"}
+	bytes := prometheus.NewDesc("tc_qdisc_bytes", "bytes", qdiscLabels, nil)
+	packets := prometheus.NewDesc("tc_qdisc_packets", "packets", qdiscLabels, nil)
+	filter := prometheus.NewDesc("tc_filter_packets", "packets", []string{"host", "netns", "linkindex", "link", "type", "parent", "chain", "prio", "protocol", "handle"}, nil)
+
+	type qdisc struct {
+		link   string
+		handle string
+		parent string
+		bytes  float64
+	}
+	// the root mq and two of its children, which all have handle 0:, and a qdisc on another link
+	root := qdisc{"eth0", "1:0", "ffff:ffff", 3000}
+	child1 := qdisc{"eth0", "0:0", "1:1", 2000}
+	child2 := qdisc{"eth0", "0:0", "1:2", 1000}
+	other := qdisc{"eth1", "1:0", "ffff:ffff", 500}
+	qdiscs := []qdisc{root, child1, child2, other}
+
+	tests := []struct {
+		name    string
+		limits  tcexporter.LimitsConfig
+		kept    []qdisc
+		dropped map[[2]string]float64
+	}{
+		{
+			name:   "no limit exceeded",
+			limits: tcexporter.LimitsConfig{MaxSeries: 8},
+			kept:   qdiscs,
+		},
+		{
+			name:    "link",
+			limits:  tcexporter.LimitsConfig{MaxSeriesPerLink: 4},
+			kept:    []qdisc{root, child1, other},
+			dropped: map[[2]string]float64{{"eth0", "link"}: 2},
+		},
+		{
+			name:    "total",
+			limits:  tcexporter.LimitsConfig{MaxSeries: 4},
+			kept:    []qdisc{root, child1},
+			dropped: map[[2]string]float64{{"eth0", "total"}: 2, {"eth1", "total"}: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cg := tcexporter.NewTestCardinalityGuard(tt.limits, bytes)
+			var scrape []prometheus.Metric
+			for _, qd := range qdiscs {
+				scrape = append(scrape,
+					prometheus.MustNewConstMetric(bytes, prometheus.CounterValue, qd.bytes, "host", "default", "2", qd.link, "mq", qd.handle, qd.parent),
+					prometheus.MustNewConstMetric(packets, prometheus.CounterValue, qd.bytes/100, "host", "default", "2", qd.link, "mq", qd.handle, qd.parent),
+				)
+			}
+			// filters have a handle too, but are never limited
+			scrape = append(scrape, prometheus.MustNewConstMetric(filter, prometheus.CounterValue, 10, "host", "default", "2", "eth0", "u32", "1:0", "0", "1", "ip", "800::800"))
+
+			metrics := collectMetrics(t, func(ch chan<- prometheus.Metric) {
+				for _, m := range cg.Process(scrape) {
+					ch <- m
+				}
+			})
+			if _, ok := findMetric(metrics, "tc_filter_packets", nil); !ok {
+				t.Fatalf("filter series was dropped")
+			}
+			var kept int
+			for _, m := range metrics {
+				if m.name == "tc_qdisc_bytes" || m.name == "tc_qdisc_packets" {
+					kept++
+				}
+			}
+			if kept != 2*len(tt.kept) {
+				t.Fatalf("unexpected number of qdisc series: got %d, want %d", kept, 2*len(tt.kept))
+			}
+			for _, qd := range tt.kept {
+				got, ok := findMetric(metrics, "tc_qdisc_bytes", map[string]string{"link": qd.link, "handle": qd.handle, "parent": qd.parent})
+				if !ok || got != qd.bytes {
+					t.Fatalf("unexpected bytes of %s %s parent %s: got %v (%t), want %v", qd.link, qd.handle, qd.parent, got, ok, qd.bytes)
+				}
+			}
+			for key, want := range tt.dropped {
+				got, ok := findMetric(metrics, "tc_series_dropped_total", map[string]string{"host": "host", "link": key[0], "reason": key[1]})
+				if !ok || got != want {
+					t.Fatalf("unexpected dropped series of %s for %s: got %v (%t), want %v", key[0], key[1], got, ok, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCardinalityGuardNameInfo(t *testing.T) {
+	classLabels := []string{"host", "netns", "linkindex", "link", "type", "handle", "parent"}
+	bytes := prometheus.NewDesc("tc_class_bytes", "bytes", classLabels, nil)
+	packets := prometheus.NewDesc("tc_class_packets", "packets", classLabels, nil)
+	name := prometheus.NewDesc("tc_class_name_info", "name", []string{"host", "netns", "linkindex", "link", "handle", "name"}, nil)
+
+	// the name info has no type and no parent, it is kept or dropped together with its class
+	cg := tcexporter.NewTestCardinalityGuard(tcexporter.LimitsConfig{MaxSeriesPerLink: 4}, bytes)
+	var scrape []prometheus.Metric
+	for _, cl := range []struct {
+		handle string
+		name   string
+		bytes  float64
+	}{
+		{"1:10", "gold", 2000},
+		{"1:20", "silver", 1000},
+	} {
+		scrape = append(scrape,
+			prometheus.MustNewConstMetric(bytes, prometheus.CounterValue, cl.bytes, "host", "default", "2", "eth0", "htb", cl.handle, "1:1"),
+			prometheus.MustNewConstMetric(packets, prometheus.CounterValue, cl.bytes/100, "host", "default", "2", "eth0", "htb", cl.handle, "1:1"),
+			prometheus.MustNewConstMetric(name, prometheus.GaugeValue, 1, "host", "default", "2", "eth0", cl.handle, cl.name),
+		)
+	}
+	metrics := collectMetrics(t, func(ch chan<- prometheus.Metric) {
+		for _, m := range cg.Process(scrape) {
+			ch <- m
+		}
+	})
+	for _, m := range []string{"tc_class_bytes", "tc_class_packets", "tc_class_name_info"} {
+		if _, ok := findMetric(metrics, m, map[string]string{"handle": "1:10"}); !ok {
+			t.Fatalf("missing %s of the kept class", m)
+		}
+		if _, ok := findMetric(metrics, m, map[string]string{"handle": "1:20"}); ok {
+			t.Fatalf("%s of the dropped class was kept", m)
+		}
+	}
+	if got, ok := findMetric(metrics, "tc_series_dropped_total", map[string]string{"link": "eth0", "reason": "link"}); !ok || got != 3 {
+		t.Fatalf("unexpected dropped series: got %v (%t), want 3", got, ok)
+	}
+}
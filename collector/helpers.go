@@ -1,8 +1,11 @@
 package tccollector
 
 import (
+	"encoding/binary"
 	"os"
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/florianl/go-tc"
 	"github.com/jsimonetti/rtnetlink"
@@ -21,6 +24,38 @@ func FmtHandleStr(handle uint32) (string) {
 	return fmt.Sprintf("%d:%d", ((handle & 0xffff0000) >> 16), (handle & 0x0000ffff))
 }
 
+// ParseHandle parses a handle in tc notation, like 1:10, 1: or root
+func ParseHandle(s string) (uint32, error) {
+	switch s {
+	case "root":
+		return tc.HandleRoot, nil
+	case "ingress", "clsact":
+		return tc.HandleIngress, nil
+	case "none":
+		return 0, nil
+	}
+	maj, min, found := strings.Cut(s, ":")
+	if !found {
+		return 0, fmt.Errorf("invalid handle %q: missing ':'", s)
+	}
+	var handle uint64
+	if maj != "" {
+		v, err := strconv.ParseUint(maj, 16, 16)
+		if err != nil {
+			return 0, fmt.Errorf("invalid handle %q: %w", s, err)
+		}
+		handle = v << 16
+	}
+	if min != "" {
+		v, err := strconv.ParseUint(min, 16, 16)
+		if err != nil {
+			return 0, fmt.Errorf("invalid handle %q: %w", s, err)
+		}
+		handle |= v
+	}
+	return uint32(handle), nil
+}
+
 // GetNetlinkConn gets a rtnetlink connection for the specified network namespace
 func GetNetlinkConn(ns string) (con *rtnetlink.Conn, err error) {
 	if ns == "default" {
@@ -115,31 +150,40 @@ func getClasses(devid uint32, ns string) ([]tc.Object, error) {
 	return cl, nil
 }
 
-// getQdiscs fetches all qdiscs for a pecified interface in the netns
-func getFilters(devid uint32, ns string) ([]tc.Object, error) {
-	sock, err := GetTcConn(ns)
+// getFilters fetches the filters of a parent for a specified interface over a connection to its
+// netns. The kernel only dumps the filters of a single qdisc or class at a time, so the connection
+// is shared by the parents of the interface.
+func getFilters(conn *netlink.Conn, devid, parent uint32) ([]rawFilter, error) {
+	objs, err := dumpRawObjects(conn, unix.RTM_GETTFILTER, devid, parent)
 	if err != nil {
 		return nil, err
 	}
-	defer sock.Close()
+	var filters []rawFilter
+	for _, obj := range objs {
+		fl, err := newRawFilter(obj)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, fl)
+	}
+	return filters, nil
+}
 
-	filters, err := sock.Filter().Get(&tc.Msg{
-		Family:  unix.AF_UNSPEC,
-		Info:    0,
-		Handle:  tc.HandleRoot,
-		Ifindex: devid,
-	})
+// getChains fetches the filter chains of a parent for a specified interface over a connection to
+// its netns, or of a shared block when devid is tcmIfindexMagicBlock and parent the index of the
+// block. The kernel also dumps the chains that have no filters.
+func getChains(conn *netlink.Conn, devid, parent uint32) ([]uint32, error) {
+	objs, err := dumpRawObjects(conn, unix.RTM_GETCHAIN, devid, parent)
 	if err != nil {
 		return nil, err
 	}
-	var fl []tc.Object
-	for _, filter := range filters {
-		if filter.Ifindex == devid {
-			fl = append(fl, filter)
+	var chains []uint32
+	for _, obj := range objs {
+		if chain, ok := obj.Attrs[tcaChain]; ok && len(chain) >= 4 {
+			chains = append(chains, binary.NativeEndian.Uint32(chain))
 		}
 	}
-
-	return fl, nil
+	return chains, nil
 }
 
 type stats struct {
@@ -153,3 +197,46 @@ type stats struct {
 	backlog    *prometheus.Desc
 	requeues   *prometheus.Desc
 }
+
+// counters returns the descs of the stats that are real counters, the queue length and backlog
+// are exported as counters but go up and down
+func (s stats) counters() []*prometheus.Desc {
+	return []*prometheus.Desc{s.bytes, s.packets, s.drops, s.overlimits, s.requeues}
+}
+
+// objectStats are the generic stats of a qdisc or class
+type objectStats struct {
+	bytes      float64
+	packets    float64
+	drops      float64
+	overlimits float64
+	requeues   float64
+	qlen       float64
+	backlog    float64
+}
+
+// readStats reads the generic stats of the object, the legacy stats take precedence over stats2
+// like they do in the qdisc and class collectors
+func readStats(obj tc.Object) (objectStats, bool) {
+	var s objectStats
+	if obj.Stats2 != nil {
+		s = objectStats{
+			bytes:      float64(obj.Stats2.Bytes),
+			packets:    float64(obj.Stats2.Packets),
+			drops:      float64(obj.Stats2.Drops),
+			overlimits: float64(obj.Stats2.Overlimits),
+			requeues:   float64(obj.Stats2.Requeues),
+			qlen:       float64(obj.Stats2.Qlen),
+			backlog:    float64(obj.Stats2.Backlog),
+		}
+	}
+	if obj.Stats != nil {
+		s.bytes = float64(obj.Stats.Bytes)
+		s.packets = float64(obj.Stats.Packets)
+		s.drops = float64(obj.Stats.Drops)
+		s.overlimits = float64(obj.Stats.Overlimits)
+		s.qlen = float64(obj.Stats.Qlen)
+		s.backlog = float64(obj.Stats.Backlog)
+	}
+	return s, obj.Stats != nil || obj.Stats2 != nil
+}
@@ -2,11 +2,14 @@ package tccollector_test
 
 import (
 	"os/exec"
+	"regexp"
 	"syscall"
 	"testing"
 
 	tcexporter "github.com/fbegyn/tc_exporter/collector"
 	"github.com/jsimonetti/rtnetlink"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"golang.org/x/sys/unix"
 )
 
@@ -77,3 +80,65 @@ func shell(t *testing.T, name string, arg ...string) {
 		t.Fatalf("failed to wait for command %q: %v", name, err)
 	}
 }
+
+// metric is a collected metric with its name, labels and value
+type metric struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+var fqNameRegexp = regexp.MustCompile(`fqName: "([^"]+)"`)
+
+// collectMetrics runs collect and returns the metrics it sent
+func collectMetrics(t *testing.T, collect func(ch chan<- prometheus.Metric)) []metric {
+	t.Helper()
+	ch := make(chan prometheus.Metric)
+	go func() {
+		collect(ch)
+		close(ch)
+	}()
+	var metrics []metric
+	for m := range ch {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatalf("failed to write metric: %v", err)
+		}
+		mt := metric{labels: make(map[string]string)}
+		if match := fqNameRegexp.FindStringSubmatch(m.Desc().String()); match != nil {
+			mt.name = match[1]
+		}
+		for _, lp := range pb.Label {
+			mt.labels[lp.GetName()] = lp.GetValue()
+		}
+		switch {
+		case pb.Gauge != nil:
+			mt.value = pb.Gauge.GetValue()
+		case pb.Counter != nil:
+			mt.value = pb.Counter.GetValue()
+		}
+		metrics = append(metrics, mt)
+	}
+	return metrics
+}
+
+// findMetric returns the value of the metric with the name and the labels, ok is false when it
+// was not collected
+func findMetric(metrics []metric, name string, labels map[string]string) (float64, bool) {
+	for _, m := range metrics {
+		if m.name != name {
+			continue
+		}
+		match := true
+		for k, v := range labels {
+			if m.labels[k] != v {
+				match = false
+				break
+			}
+		}
+		if match {
+			return m.value, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,264 @@
+package tccollector
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/florianl/go-tc"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	infoLabels []string = []string{
+		"host", "netns", "linkindex", "link", "type", "handle", "parent",
+		"hw_offload", "ingress_block", "egress_block", "chain", "linklayer", "overhead", "estimator", "flags",
+	}
+)
+
+// options of the qdiscs the flags label is decoded from
+const (
+	tcaCodelEcn   = 4
+	tcaFqCodelEcn = 4
+	tcaPieEcn     = 6
+	tcaPieByte    = 7
+	tcaRedParms   = 1
+	tcaRedFlags   = 4
+
+	tcaCakeAtm       = 4
+	tcaCakeOverhead  = 6
+	tcaCakeAutorate  = 9
+	tcaCakeNat       = 11
+	tcaCakeRaw       = 12
+	tcaCakeWash      = 13
+	tcaCakeIngress   = 15
+	tcaCakeAckFilter = 16
+	tcaCakeSplitGso  = 17
+
+	redFlagEcn      = 1
+	redFlagHarddrop = 2
+	redFlagAdaptive = 4
+	redFlagNodrop   = 8
+)
+
+// InfoCollector exports an info metric per qdisc and class, with the attributes that are not
+// numbers as labels. The label set is the same for every kind, labels that do not apply to the
+// kind are empty. The boolean options of the kind are joined in the flags label.
+type InfoCollector struct {
+	logger    slog.Logger
+	netns     map[string][]rtnetlink.LinkMessage
+	qdiscInfo *prometheus.Desc
+	classInfo *prometheus.Desc
+}
+
+// NewInfoCollector create a new InfoCollector given a network interface
+func NewInfoCollector(netns map[string][]rtnetlink.LinkMessage, log *slog.Logger) (InterfaceCollector, error) {
+	log = log.With("collector", "info")
+	log.Info("making info collector")
+
+	return &InfoCollector{
+		logger: *log,
+		netns:  netns,
+		qdiscInfo: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "qdisc", "info"),
+			"Non numeric attributes of the qdisc",
+			infoLabels, nil,
+		),
+		classInfo: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "class", "info"),
+			"Non numeric attributes of the class",
+			infoLabels, nil,
+		),
+	}, nil
+}
+
+// Describe implements Collector
+func (col *InfoCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- col.qdiscInfo
+	ch <- col.classInfo
+}
+
+// CollectInterface exports the info metrics of the qdiscs and classes of the interface. The
+// options go-tc does not decode are read from the raw messages, when those can not be fetched the
+// labels that depend on them are empty.
+func (col *InfoCollector) CollectInterface(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, qdiscs, classes []tc.Object) {
+	col.collectRawInterface(ch, host, ns, interf, qdiscs, classes,
+		newRawDump(ns, unix.RTM_GETQDISC, interf.Index), newRawDump(ns, unix.RTM_GETTCLASS, interf.Index))
+}
+
+// collectRawInterface implements rawInterfaceCollector
+func (col *InfoCollector) collectRawInterface(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, qdiscs, classes []tc.Object, rawQdiscs, rawClasses *rawDump) {
+	if len(qdiscs) > 0 {
+		raws := col.rawObjects(rawQdiscs, interf)
+		for _, qd := range qdiscs {
+			col.collectObject(ch, col.qdiscInfo, host, ns, interf, qd, raws[rawKey{qd.Handle, qd.Parent}])
+		}
+	}
+	if len(classes) > 0 {
+		raws := col.rawObjects(rawClasses, interf)
+		for _, cl := range classes {
+			col.collectObject(ch, col.classInfo, host, ns, interf, cl, raws[rawKey{cl.Handle, cl.Parent}])
+		}
+	}
+}
+
+// rawObjects returns the raw objects of the dump by handle and parent
+func (col *InfoCollector) rawObjects(raws *rawDump, interf rtnetlink.LinkMessage) map[rawKey]*rawObject {
+	objs, err := raws.index()
+	if err != nil {
+		col.logger.Error("failed to get raw tc objects", "interface", interf.Attributes.Name, "err", err)
+		return nil
+	}
+	return objs
+}
+
+// collectObject exports the info metric of a single qdisc or class
+func (col *InfoCollector) collectObject(ch chan<- prometheus.Metric, desc *prometheus.Desc, host, ns string, interf rtnetlink.LinkMessage, obj tc.Object, raw *rawObject) {
+	var hwOffload, ingressBlock, egressBlock, chain, linklayer, overhead, estimator string
+	if obj.HwOffload != nil {
+		hwOffload = fmt.Sprintf("%t", *obj.HwOffload != 0)
+	}
+	if obj.IngressBlock != nil {
+		ingressBlock = fmt.Sprintf("%d", *obj.IngressBlock)
+	}
+	if obj.EgressBlock != nil {
+		egressBlock = fmt.Sprintf("%d", *obj.EgressBlock)
+	}
+	if obj.Chain != nil {
+		chain = fmt.Sprintf("%d", *obj.Chain)
+	}
+	// the size table of the qdisc applies to all kinds, the rate of an htb class overrides it
+	if obj.Stab != nil && obj.Stab.Base != nil {
+		linklayer = linklayerName(obj.Stab.Base.LinkLayer)
+		overhead = fmt.Sprintf("%d", obj.Stab.Base.Overhead)
+	}
+	if obj.Htb != nil && obj.Htb.Parms != nil {
+		linklayer = linklayerName(uint32(obj.Htb.Parms.Rate.Linklayer))
+		overhead = fmt.Sprintf("%d", obj.Htb.Parms.Rate.Overhead)
+	}
+
+	var flags []string
+	if raw != nil {
+		estimator = fmt.Sprintf("%t", raw.hasRateEstimator())
+		opts := kindOptions(obj.Kind, raw.Attrs[tcaOptions])
+		flags = opts.flags
+		if opts.linklayer != "" {
+			linklayer = opts.linklayer
+		}
+		if opts.overhead != "" {
+			overhead = opts.overhead
+		}
+	}
+	slices.Sort(flags)
+
+	handleMaj, handleMin := HandleStr(obj.Handle)
+	parentMaj, parentMin := HandleStr(obj.Parent)
+	ch <- prometheus.MustNewConstMetric(
+		desc,
+		prometheus.GaugeValue,
+		1,
+		host,
+		ns,
+		fmt.Sprintf("%d", interf.Index),
+		interf.Attributes.Name,
+		obj.Kind,
+		fmt.Sprintf("%x:%x", handleMaj, handleMin),
+		fmt.Sprintf("%x:%x", parentMaj, parentMin),
+		hwOffload,
+		ingressBlock,
+		egressBlock,
+		chain,
+		linklayer,
+		overhead,
+		estimator,
+		strings.Join(flags, ","),
+	)
+}
+
+// linklayerName returns the name of a TC_LINKLAYER_* value
+func linklayerName(ll uint32) string {
+	switch ll & 0x0f {
+	case 0:
+		return "unaware"
+	case 1:
+		return "ethernet"
+	case 2:
+		return "atm"
+	}
+	return fmt.Sprintf("%d", ll)
+}
+
+// infoOptions are the labels decoded from the options of a kind
+type infoOptions struct {
+	flags     []string
+	linklayer string
+	overhead  string
+}
+
+// kindOptions decodes the flags, and for cake the link layer compensation, from the raw options
+// of the kind
+func kindOptions(kind string, b []byte) infoOptions {
+	var opts infoOptions
+	if len(b) == 0 {
+		return opts
+	}
+	attrs, err := parseAttrs(b)
+	if err != nil {
+		return opts
+	}
+	set := func(typ uint16, name string) {
+		if v, ok := attrs[typ]; ok && len(v) >= 4 && binary.NativeEndian.Uint32(v) != 0 {
+			opts.flags = append(opts.flags, name)
+		}
+	}
+	switch kind {
+	case "red":
+		// the parameters are a plain struct, the historic flags follow the limits and the log
+		// values. nodrop does not fit in there, it is only sent in the TCA_RED_FLAGS bitfield.
+		var flags uint32
+		if p := attrs[tcaRedParms]; len(p) >= 16 {
+			flags = uint32(p[15]) &^ redFlagNodrop
+		}
+		if f := attrs[tcaRedFlags]; len(f) >= 8 {
+			flags |= binary.NativeEndian.Uint32(f[0:4])
+		}
+		for bit, name := range map[uint32]string{
+			redFlagEcn:      "ecn",
+			redFlagHarddrop: "harddrop",
+			redFlagAdaptive: "adaptive",
+			redFlagNodrop:   "nodrop",
+		} {
+			if flags&bit != 0 {
+				opts.flags = append(opts.flags, name)
+			}
+		}
+	case "codel":
+		set(tcaCodelEcn, "ecn")
+	case "fq_codel":
+		set(tcaFqCodelEcn, "ecn")
+	case "pie":
+		set(tcaPieEcn, "ecn")
+		set(tcaPieByte, "bytemode")
+	case "cake":
+		set(tcaCakeAutorate, "autorate")
+		set(tcaCakeNat, "nat")
+		set(tcaCakeWash, "wash")
+		set(tcaCakeIngress, "ingress")
+		set(tcaCakeAckFilter, "ack_filter")
+		set(tcaCakeSplitGso, "split_gso")
+		if _, ok := attrs[tcaCakeRaw]; ok {
+			opts.flags = append(opts.flags, "raw")
+		}
+		if v, ok := attrs[tcaCakeAtm]; ok && len(v) >= 4 {
+			opts.linklayer = []string{"none", "atm", "ptm"}[min(binary.NativeEndian.Uint32(v), 2)]
+		}
+		if v, ok := attrs[tcaCakeOverhead]; ok && len(v) >= 4 {
+			opts.overhead = fmt.Sprintf("%d", int32(binary.NativeEndian.Uint32(v)))
+		}
+	}
+	return opts
+}
@@ -0,0 +1,289 @@
+package tccollector_test
+
+import (
+	"encoding/binary"
+	"log/slog"
+	"os"
+	"slices"
+	"testing"
+
+	tcexporter "github.com/fbegyn/tc_exporter/collector"
+	"github.com/florianl/go-tc"
+	"github.com/florianl/go-tc/core"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/mdlayher/netlink"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestInfoCollectorLabels(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	col, err := tcexporter.NewInfoCollector(nil, logger)
+	if err != nil {
+		t.Fatalf("failed to create info collector: %v", err)
+	}
+	// an interface that does not exist, the raw options can not be fetched
+	interf := rtnetlink.LinkMessage{Index: 0xfffff, Attributes: &rtnetlink.LinkAttributes{Name: "test0"}}
+	offload := uint8(1)
+	block := uint32(22)
+	qd := tc.Object{
+		Msg:       tc.Msg{Handle: core.BuildHandle(1, 0), Parent: tc.HandleRoot},
+		Attribute: tc.Attribute{Kind: "htb", HwOffload: &offload, IngressBlock: &block},
+	}
+	cl := tc.Object{
+		Msg: tc.Msg{Handle: core.BuildHandle(1, 1), Parent: core.BuildHandle(1, 0)},
+		Attribute: tc.Attribute{
+			Kind: "htb",
+			Htb:  &tc.Htb{Parms: &tc.HtbOpt{Rate: tc.RateSpec{Linklayer: 2, Overhead: 40}}},
+		},
+	}
+
+	ch := make(chan prometheus.Metric, 8)
+	col.CollectInterface(ch, "host", "default", interf, []tc.Object{qd}, []tc.Object{cl})
+	close(ch)
+
+	var infos []map[string]string
+	for m := range ch {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatalf("failed to write metric: %v", err)
+		}
+		labels := make(map[string]string)
+		for _, lp := range pb.Label {
+			labels[lp.GetName()] = lp.GetValue()
+		}
+		infos = append(infos, labels)
+	}
+	if len(infos) != 2 {
+		t.Fatalf("expected 2 info metrics, got %d", len(infos))
+	}
+
+	tests := []struct {
+		handle string
+		label  string
+		want   string
+	}{
+		{"1:0", "hw_offload", "true"},
+		{"1:0", "ingress_block", "22"},
+		{"1:0", "egress_block", ""},
+		{"1:0", "linklayer", ""},
+		{"1:1", "linklayer", "atm"},
+		{"1:1", "overhead", "40"},
+		{"1:1", "hw_offload", ""},
+	}
+	for _, tt := range tests {
+		for _, info := range infos {
+			if info["handle"] != tt.handle {
+				continue
+			}
+			if info[tt.label] != tt.want {
+				t.Fatalf("unexpected %s for %s: got %q, want %q", tt.label, tt.handle, info[tt.label], tt.want)
+			}
+		}
+	}
+}
+
+func TestInfoKindOptions(t *testing.T) {
+	encode := func(f func(ae *netlink.AttributeEncoder)) []byte {
+		ae := netlink.NewAttributeEncoder()
+		f(ae)
+		b, err := ae.Encode()
+		if err != nil {
+			t.Fatalf("failed to encode options: %v", err)
+		}
+		return b
+	}
+	// redParms is a struct tc_red_qopt with the historic flags in the last byte
+	redParms := func(flags uint8) []byte {
+		p := make([]byte, 16)
+		binary.NativeEndian.PutUint32(p[0:], 400000)
+		p[15] = flags
+		return p
+	}
+	// redFlags is the struct nla_bitfield32 of TCA_RED_FLAGS
+	redFlags := func(value, selector uint32) []byte {
+		b := make([]byte, 8)
+		binary.NativeEndian.PutUint32(b[0:], value)
+		binary.NativeEndian.PutUint32(b[4:], selector)
+		return b
+	}
+
+	tests := []struct {
+		name      string
+		kind      string
+		options   []byte
+		flags     []string
+		linklayer string
+		overhead  string
+	}{
+		{
+			name: "red historic flags",
+			kind: "red",
+			options: encode(func(ae *netlink.AttributeEncoder) {
+				ae.Bytes(1, redParms(1|2))
+			}),
+			flags: []string{"ecn", "harddrop"},
+		},
+		{
+			name: "red nodrop",
+			kind: "red",
+			options: encode(func(ae *netlink.AttributeEncoder) {
+				ae.Bytes(1, redParms(1|4))
+				ae.Bytes(4, redFlags(1|4|8, 0xf))
+			}),
+			flags: []string{"adaptive", "ecn", "nodrop"},
+		},
+		{
+			// TCA_RED_EARLY_DROP_BLOCK holds a block index, not flags
+			name: "red early drop block",
+			kind: "red",
+			options: encode(func(ae *netlink.AttributeEncoder) {
+				ae.Bytes(1, redParms(1))
+				ae.Uint32(5, 8|2)
+			}),
+			flags: []string{"ecn"},
+		},
+		{
+			// nodrop is only read from the bitfield
+			name: "red nodrop in the parameters",
+			kind: "red",
+			options: encode(func(ae *netlink.AttributeEncoder) {
+				ae.Bytes(1, redParms(8))
+			}),
+		},
+		{
+			name: "codel ecn",
+			kind: "codel",
+			options: encode(func(ae *netlink.AttributeEncoder) {
+				ae.Uint32(4, 1)
+			}),
+			flags: []string{"ecn"},
+		},
+		{
+			name: "fq_codel without ecn",
+			kind: "fq_codel",
+			options: encode(func(ae *netlink.AttributeEncoder) {
+				ae.Uint32(4, 0)
+			}),
+		},
+		{
+			name: "pie",
+			kind: "pie",
+			options: encode(func(ae *netlink.AttributeEncoder) {
+				ae.Uint32(6, 1)
+				ae.Uint32(7, 1)
+			}),
+			flags: []string{"bytemode", "ecn"},
+		},
+		{
+			name: "cake",
+			kind: "cake",
+			options: encode(func(ae *netlink.AttributeEncoder) {
+				// the diffserv and flow modes are not flags
+				ae.Uint32(3, 1)
+				ae.Uint32(4, 1)
+				ae.Uint32(5, 4)
+				ae.Uint32(6, uint32(0xfffffffc))
+				ae.Uint32(9, 1)
+				ae.Uint32(11, 1)
+				ae.Uint32(13, 0)
+				ae.Uint32(15, 1)
+				ae.Uint32(16, 2)
+				ae.Uint32(17, 1)
+			}),
+			flags:     []string{"ack_filter", "autorate", "ingress", "nat", "split_gso"},
+			linklayer: "atm",
+			overhead:  "-4",
+		},
+		{
+			name: "cake raw ptm",
+			kind: "cake",
+			options: encode(func(ae *netlink.AttributeEncoder) {
+				ae.Uint32(4, 2)
+				ae.Uint32(6, 0)
+				ae.Uint32(12, 0)
+				ae.Uint32(13, 1)
+			}),
+			flags:     []string{"raw", "wash"},
+			linklayer: "ptm",
+			overhead:  "0",
+		},
+		{
+			name:    "invalid options",
+			kind:    "cake",
+			options: []byte{1, 2, 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags, linklayer, overhead := tcexporter.KindOptions(tt.kind, tt.options)
+			if !slices.Equal(flags, tt.flags) {
+				t.Fatalf("unexpected flags: got %v, want %v", flags, tt.flags)
+			}
+			if linklayer != tt.linklayer {
+				t.Fatalf("unexpected linklayer: got %q, want %q", linklayer, tt.linklayer)
+			}
+			if overhead != tt.overhead {
+				t.Fatalf("unexpected overhead: got %q, want %q", overhead, tt.overhead)
+			}
+		})
+	}
+}
+
+func TestInfoCollectorDumps(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	col, err := tcexporter.NewInfoCollector(nil, logger)
+	if err != nil {
+		t.Fatalf("failed to create info collector: %v", err)
+	}
+	interf := rtnetlink.LinkMessage{Index: 2, Attributes: &rtnetlink.LinkAttributes{Name: "eth0"}}
+	cakeOptions := func(atm, nat uint32) []byte {
+		ae := netlink.NewAttributeEncoder()
+		ae.Uint32(4, atm)
+		ae.Uint32(11, nat)
+		b, err := ae.Encode()
+		if err != nil {
+			t.Fatalf("failed to encode cake options: %v", err)
+		}
+		return b
+	}
+
+	// the options are read from the dumps of the scrape, the children of mq all have handle 0:
+	rawQdiscs := tcexporter.NewTestRawDump(2,
+		tcexporter.TestRawObject{Parent: core.BuildHandle(1, 1), Kind: "cake", Options: cakeOptions(1, 1)},
+		tcexporter.TestRawObject{Parent: core.BuildHandle(1, 2), Kind: "cake", Options: cakeOptions(2, 0)},
+	)
+	rawClasses := tcexporter.NewTestRawDump(2,
+		tcexporter.TestRawObject{Handle: core.BuildHandle(1, 1), Parent: core.BuildHandle(1, 0), Kind: "mq"},
+	)
+	qdiscs := []tc.Object{
+		{Msg: tc.Msg{Parent: core.BuildHandle(1, 1)}, Attribute: tc.Attribute{Kind: "cake"}},
+		{Msg: tc.Msg{Parent: core.BuildHandle(1, 2)}, Attribute: tc.Attribute{Kind: "cake"}},
+	}
+	classes := []tc.Object{
+		{Msg: tc.Msg{Handle: core.BuildHandle(1, 1), Parent: core.BuildHandle(1, 0)}, Attribute: tc.Attribute{Kind: "mq"}},
+	}
+	metrics := collectMetrics(t, func(ch chan<- prometheus.Metric) {
+		tcexporter.CollectInterfaceDumps(col, ch, "host", "default", interf, qdiscs, classes, rawQdiscs, rawClasses)
+	})
+	if len(metrics) != 3 {
+		t.Fatalf("unexpected number of metrics: got %d, want 3", len(metrics))
+	}
+
+	tests := []struct {
+		name   string
+		metric string
+		labels map[string]string
+	}{
+		{"first child", "tc_qdisc_info", map[string]string{"parent": "1:1", "flags": "nat", "linklayer": "atm"}},
+		{"second child", "tc_qdisc_info", map[string]string{"parent": "1:2", "flags": "", "linklayer": "ptm"}},
+		{"class", "tc_class_info", map[string]string{"handle": "1:1", "parent": "1:0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := findMetric(metrics, tt.metric, tt.labels); !ok {
+				t.Fatalf("missing %s with labels %v", tt.metric, tt.labels)
+			}
+		})
+	}
+}
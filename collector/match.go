@@ -0,0 +1,219 @@
+package tccollector
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"net/netip"
+	"strings"
+)
+
+// MatchConfig configures the decoding of the match keys of the u32 and flower filters
+type MatchConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// MaxLength is the maximum length of the match label, longer matches are cut off
+	MaxLength int `mapstructure:"max-length"`
+}
+
+// u32 and flower netlink attributes of the match keys
+const (
+	tcaU32Sel = 5
+
+	tcaFlowerKeyEthType  = 8
+	tcaFlowerKeyIPProto  = 9
+	tcaFlowerKeyIPv4Src  = 10
+	tcaFlowerKeyIPv4SrcM = 11
+	tcaFlowerKeyIPv4Dst  = 12
+	tcaFlowerKeyIPv4DstM = 13
+	tcaFlowerKeyIPv6Src  = 14
+	tcaFlowerKeyIPv6SrcM = 15
+	tcaFlowerKeyIPv6Dst  = 16
+	tcaFlowerKeyIPv6DstM = 17
+	tcaFlowerKeyTCPSrc   = 18
+	tcaFlowerKeyTCPDst   = 19
+	tcaFlowerKeyUDPSrc   = 20
+	tcaFlowerKeyUDPDst   = 21
+	tcaFlowerKeyVlanID   = 23
+	tcaFlowerKeyVlanPrio = 24
+	tcaFlowerKeySCTPSrc  = 41
+	tcaFlowerKeySCTPDst  = 42
+	tcaFlowerKeyIPTos    = 73
+	tcaFlowerKeyIPTosM   = 74
+
+	// u32SelLen and u32KeyLen are the sizes of struct tc_u32_sel and struct tc_u32_key
+	u32SelLen = 16
+	u32KeyLen = 16
+)
+
+// ipProtoNames are the names of the common IP protocols
+var ipProtoNames = map[uint8]string{
+	1:   "icmp",
+	6:   "tcp",
+	17:  "udp",
+	58:  "icmpv6",
+	132: "sctp",
+}
+
+// decodeMatch returns the match keys of a u32 or flower filter in a tc like notation, like
+// `ip dst 10.0.0.0/8 dport 443`. Other classifiers have no match.
+func decodeMatch(fl rawFilter, maxLength int) string {
+	var match string
+	switch fl.Kind {
+	case "u32":
+		if sel, ok := fl.Options[tcaU32Sel]; ok {
+			match = decodeU32(sel, fl.protocol())
+		}
+	case "flower":
+		match = decodeFlower(fl.Options)
+	}
+	if maxLength <= 0 {
+		maxLength = 128
+	}
+	if len(match) > maxLength {
+		match = match[:max(maxLength-3, 0)] + "..."
+	}
+	return match
+}
+
+// decodeU32 decodes the keys of a u32 selector. The keys at the offsets of the IPv4 header fields
+// are named when the filter matches IP, all other keys are shown as `at off val/mask`.
+func decodeU32(sel []byte, proto uint16) string {
+	if len(sel) < u32SelLen {
+		return ""
+	}
+	nkeys := int(sel[2])
+	var parts []string
+	if proto == 0x0800 {
+		parts = append(parts, "ip")
+	}
+	for i := range nkeys {
+		start := u32SelLen + i*u32KeyLen
+		if len(sel) < start+u32KeyLen {
+			break
+		}
+		key := sel[start : start+u32KeyLen]
+		mask := binary.BigEndian.Uint32(key[0:4])
+		val := binary.BigEndian.Uint32(key[4:8])
+		off := int32(binary.NativeEndian.Uint32(key[8:12]))
+		offmask := int32(binary.NativeEndian.Uint32(key[12:16]))
+		parts = append(parts, u32Key(mask, val, off, offmask != 0, proto))
+	}
+	return strings.Join(parts, " ")
+}
+
+// u32Key names a single u32 key
+func u32Key(mask, val uint32, off int32, nexthdr bool, proto uint16) string {
+	if !nexthdr && (proto == 0x0800 || proto == ethPAll) {
+		switch {
+		case off == 0 && (mask == 0x00ff0000 || mask == 0x00fc0000):
+			return fmt.Sprintf("dscp %d", val>>18)
+		case off == 8 && mask == 0x00ff0000:
+			return fmt.Sprintf("ip_proto %s", ipProtoName(uint8(val>>16)))
+		case (off == 12 || off == 16) && isPrefixMask(mask):
+			addr := netip.AddrFrom4([4]byte{byte(val >> 24), byte(val >> 16), byte(val >> 8), byte(val)})
+			dir := "src"
+			if off == 16 {
+				dir = "dst"
+			}
+			return fmt.Sprintf("%s %s", dir, netip.PrefixFrom(addr, bits.OnesCount32(mask)).Masked())
+		case off == 20 && mask == 0xffff0000:
+			return fmt.Sprintf("sport %d", val>>16)
+		case off == 20 && mask == 0x0000ffff:
+			return fmt.Sprintf("dport %d", val&0xffff)
+		case off == 20 && mask == 0xffffffff:
+			return fmt.Sprintf("sport %d dport %d", val>>16, val&0xffff)
+		}
+	}
+	at := fmt.Sprintf("%d", off)
+	if nexthdr {
+		at = "nexthdr+" + at
+	}
+	return fmt.Sprintf("at %s %08x/%08x", at, val, mask)
+}
+
+// isPrefixMask reports if the mask is a network prefix
+func isPrefixMask(mask uint32) bool {
+	return bits.OnesCount32(mask) == bits.LeadingZeros32(^mask)
+}
+
+// ipProtoName returns the name of the IP protocol, or its number
+func ipProtoName(proto uint8) string {
+	if name, found := ipProtoNames[proto]; found {
+		return name
+	}
+	return fmt.Sprintf("%d", proto)
+}
+
+// decodeFlower decodes the keys of a flower filter
+func decodeFlower(opts map[uint16][]byte) string {
+	var parts []string
+	if v, ok := opts[tcaFlowerKeyEthType]; ok && len(v) >= 2 {
+		parts = append(parts, protocolName(binary.BigEndian.Uint16(v)))
+	}
+	if v, ok := opts[tcaFlowerKeyVlanID]; ok && len(v) >= 2 {
+		parts = append(parts, fmt.Sprintf("vlan %d", binary.NativeEndian.Uint16(v)))
+	}
+	if v, ok := opts[tcaFlowerKeyVlanPrio]; ok && len(v) >= 1 {
+		parts = append(parts, fmt.Sprintf("vlan_prio %d", v[0]))
+	}
+	if v, ok := opts[tcaFlowerKeyIPProto]; ok && len(v) >= 1 {
+		parts = append(parts, "ip_proto "+ipProtoName(v[0]))
+	}
+	for _, addr := range []struct {
+		dir       string
+		key, mask uint16
+	}{
+		{"src", tcaFlowerKeyIPv4Src, tcaFlowerKeyIPv4SrcM},
+		{"dst", tcaFlowerKeyIPv4Dst, tcaFlowerKeyIPv4DstM},
+		{"src", tcaFlowerKeyIPv6Src, tcaFlowerKeyIPv6SrcM},
+		{"dst", tcaFlowerKeyIPv6Dst, tcaFlowerKeyIPv6DstM},
+	} {
+		if prefix, ok := flowerPrefix(opts[addr.key], opts[addr.mask]); ok {
+			parts = append(parts, fmt.Sprintf("%s %s", addr.dir, prefix))
+		}
+	}
+	for _, port := range []struct {
+		dir string
+		key uint16
+	}{
+		{"sport", tcaFlowerKeyTCPSrc},
+		{"sport", tcaFlowerKeyUDPSrc},
+		{"sport", tcaFlowerKeySCTPSrc},
+		{"dport", tcaFlowerKeyTCPDst},
+		{"dport", tcaFlowerKeyUDPDst},
+		{"dport", tcaFlowerKeySCTPDst},
+	} {
+		if v, ok := opts[port.key]; ok && len(v) >= 2 {
+			parts = append(parts, fmt.Sprintf("%s %d", port.dir, binary.BigEndian.Uint16(v)))
+		}
+	}
+	if v, ok := opts[tcaFlowerKeyIPTos]; ok && len(v) >= 1 {
+		mask := uint8(0xff)
+		if m, ok := opts[tcaFlowerKeyIPTosM]; ok && len(m) >= 1 {
+			mask = m[0]
+		}
+		// only the DSCP bits are shown when the mask leaves out the ECN bits
+		if mask == 0xfc {
+			parts = append(parts, fmt.Sprintf("dscp %d", v[0]>>2))
+		} else {
+			parts = append(parts, fmt.Sprintf("tos 0x%02x/0x%02x", v[0], mask))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// flowerPrefix converts an address key and its mask to a prefix
+func flowerPrefix(key, mask []byte) (netip.Prefix, bool) {
+	addr, ok := netip.AddrFromSlice(key)
+	if !ok {
+		return netip.Prefix{}, false
+	}
+	ones := addr.BitLen()
+	if len(mask) == len(key) {
+		ones = 0
+		for _, b := range mask {
+			ones += bits.OnesCount8(b)
+		}
+	}
+	return netip.PrefixFrom(addr, ones).Masked(), true
+}
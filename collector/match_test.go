@@ -0,0 +1,231 @@
+package tccollector_test
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+
+	tcexporter "github.com/fbegyn/tc_exporter/collector"
+	"github.com/mdlayher/netlink"
+)
+
+// u32Key is a struct tc_u32_key, the mask and value are in network byte order
+type u32Key struct {
+	mask, val uint32
+	off       int32
+	offmask   int32
+}
+
+// encodeU32 encodes the options of a u32 filter with a selector holding the keys
+func encodeU32(t *testing.T, keys ...u32Key) []byte {
+	t.Helper()
+	sel := make([]byte, 16+16*len(keys))
+	sel[2] = uint8(len(keys))
+	for i, k := range keys {
+		key := sel[16+16*i:]
+		binary.BigEndian.PutUint32(key[0:], k.mask)
+		binary.BigEndian.PutUint32(key[4:], k.val)
+		binary.NativeEndian.PutUint32(key[8:], uint32(k.off))
+		binary.NativeEndian.PutUint32(key[12:], uint32(k.offmask))
+	}
+	ae := netlink.NewAttributeEncoder()
+	ae.Bytes(5, sel)
+	b, err := ae.Encode()
+	if err != nil {
+		t.Fatalf("failed to encode u32 options: %v", err)
+	}
+	return b
+}
+
+// encodeFlower encodes the options of a flower filter
+func encodeFlower(t *testing.T, f func(ae *netlink.AttributeEncoder)) []byte {
+	t.Helper()
+	ae := netlink.NewAttributeEncoder()
+	f(ae)
+	b, err := ae.Encode()
+	if err != nil {
+		t.Fatalf("failed to encode flower options: %v", err)
+	}
+	return b
+}
+
+// be16 encodes a value in network byte order
+func be16(v uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, v)
+}
+
+func TestDecodeMatch(t *testing.T) {
+	ipv6Dst := netip.MustParseAddr("2001:db8::1").As16()
+	ipv6Mask := netip.MustParseAddr("ffff:ffff::").As16()
+	ipv4Src := netip.MustParseAddr("192.0.2.10").As4()
+
+	tests := []struct {
+		name      string
+		kind      string
+		proto     uint16
+		options   []byte
+		maxLength int
+		want      string
+	}{
+		{
+			name:  "u32 ip dst and dport",
+			kind:  "u32",
+			proto: 0x0800,
+			options: encodeU32(t,
+				u32Key{mask: 0xff000000, val: 0x0a000000, off: 16},
+				u32Key{mask: 0x0000ffff, val: 443, off: 20},
+			),
+			want: "ip dst 10.0.0.0/8 dport 443",
+		},
+		{
+			name:    "u32 src host and ports",
+			kind:    "u32",
+			proto:   0x0800,
+			options: encodeU32(t, u32Key{mask: 0xffffffff, val: 0xc000020a, off: 12}, u32Key{mask: 0xffffffff, val: 1024<<16 | 53, off: 20}),
+			want:    "ip src 192.0.2.10/32 sport 1024 dport 53",
+		},
+		{
+			name:    "u32 dscp",
+			kind:    "u32",
+			proto:   0x0800,
+			options: encodeU32(t, u32Key{mask: 0x00fc0000, val: 46 << 18, off: 0}),
+			want:    "ip dscp 46",
+		},
+		{
+			name:    "u32 ip_proto",
+			kind:    "u32",
+			proto:   0x0800,
+			options: encodeU32(t, u32Key{mask: 0x00ff0000, val: 6 << 16, off: 8}, u32Key{mask: 0x00ff0000, val: 47 << 16, off: 8}),
+			want:    "ip ip_proto tcp ip_proto 47",
+		},
+		{
+			// the offsets of the keys past the next header are relative to that header
+			name:    "u32 nexthdr",
+			kind:    "u32",
+			proto:   0x0800,
+			options: encodeU32(t, u32Key{mask: 0x0000ffff, val: 80, off: 0, offmask: 0x0f00}),
+			want:    "ip at nexthdr+0 00000050/0000ffff",
+		},
+		{
+			name:    "u32 unnamed offset",
+			kind:    "u32",
+			proto:   0x0800,
+			options: encodeU32(t, u32Key{mask: 0xffff0000, val: 0x12340000, off: 4}),
+			want:    "ip at 4 12340000/ffff0000",
+		},
+		{
+			// only the IPv4 header fields are named
+			name:    "u32 ipv6",
+			kind:    "u32",
+			proto:   0x86dd,
+			options: encodeU32(t, u32Key{mask: 0xffffffff, val: 0x20010db8, off: 24}),
+			want:    "at 24 20010db8/ffffffff",
+		},
+		{
+			name:  "flower ipv6 prefix",
+			kind:  "flower",
+			proto: 0x86dd,
+			options: encodeFlower(t, func(ae *netlink.AttributeEncoder) {
+				ae.Bytes(8, be16(0x86dd))
+				ae.Uint8(9, 6)
+				ae.Bytes(16, ipv6Dst[:])
+				ae.Bytes(17, ipv6Mask[:])
+				ae.Bytes(19, be16(443))
+			}),
+			want: "ipv6 ip_proto tcp dst 2001:db8::/32 dport 443",
+		},
+		{
+			name:  "flower ipv4 host and vlan",
+			kind:  "flower",
+			proto: 0x8100,
+			options: encodeFlower(t, func(ae *netlink.AttributeEncoder) {
+				ae.Bytes(8, be16(0x0800))
+				ae.Uint16(23, 100)
+				ae.Uint8(24, 3)
+				ae.Uint8(9, 17)
+				ae.Bytes(10, ipv4Src[:])
+				ae.Bytes(20, be16(5353))
+			}),
+			want: "ip vlan 100 vlan_prio 3 ip_proto udp src 192.0.2.10/32 sport 5353",
+		},
+		{
+			name:  "flower dscp",
+			kind:  "flower",
+			proto: 0x0800,
+			options: encodeFlower(t, func(ae *netlink.AttributeEncoder) {
+				ae.Uint8(73, 0xb8)
+				ae.Uint8(74, 0xfc)
+			}),
+			want: "dscp 46",
+		},
+		{
+			name:  "flower tos",
+			kind:  "flower",
+			proto: 0x0800,
+			options: encodeFlower(t, func(ae *netlink.AttributeEncoder) {
+				ae.Uint8(73, 0x02)
+				ae.Uint8(74, 0x03)
+			}),
+			want: "tos 0x02/0x03",
+		},
+		{
+			name:  "flower tos without mask",
+			kind:  "flower",
+			proto: 0x0800,
+			options: encodeFlower(t, func(ae *netlink.AttributeEncoder) {
+				ae.Uint8(73, 0xb8)
+			}),
+			want: "tos 0xb8/0xff",
+		},
+		{
+			name:      "cut off",
+			kind:      "u32",
+			proto:     0x0800,
+			options:   encodeU32(t, u32Key{mask: 0xff000000, val: 0x0a000000, off: 16}, u32Key{mask: 0x0000ffff, val: 443, off: 20}),
+			maxLength: 16,
+			want:      "ip dst 10.0.0...",
+		},
+		{
+			name:      "cut off to nothing",
+			kind:      "u32",
+			proto:     0x0800,
+			options:   encodeU32(t, u32Key{mask: 0xff000000, val: 0x0a000000, off: 16}),
+			maxLength: 2,
+			want:      "...",
+		},
+		{
+			name:    "other classifier",
+			kind:    "matchall",
+			proto:   0x0003,
+			options: encodeFlower(t, func(ae *netlink.AttributeEncoder) { ae.Uint32(3, 0) }),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := tcexporter.TestRawObject{Handle: 1, Info: filterInfo(1, tt.proto), Kind: tt.kind, Options: tt.options}
+			match, err := tcexporter.DecodeMatch(2, obj, tt.maxLength)
+			if err != nil {
+				t.Fatalf("failed to decode filter: %v", err)
+			}
+			if match != tt.want {
+				t.Fatalf("unexpected match: got %q, want %q", match, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeMatchDefaultLength(t *testing.T) {
+	// 20 keys of `at 4 12340000/ffff0000` are well over the default length
+	keys := make([]u32Key, 20)
+	for i := range keys {
+		keys[i] = u32Key{mask: 0xffff0000, val: 0x12340000, off: 4}
+	}
+	obj := tcexporter.TestRawObject{Handle: 1, Info: filterInfo(1, 0x0800), Kind: "u32", Options: encodeU32(t, keys...)}
+	match, err := tcexporter.DecodeMatch(2, obj, 0)
+	if err != nil {
+		t.Fatalf("failed to decode filter: %v", err)
+	}
+	if len(match) != 128 || match[125:] != "..." {
+		t.Fatalf("unexpected match of %d bytes: %q", len(match), match)
+	}
+}
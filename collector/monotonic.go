@@ -0,0 +1,260 @@
+package tccollector
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var (
+	objectLabels []string = []string{"host", "netns", "link", "type", "handle", "parent"}
+)
+
+// MonotonicConfig configures the virtual monotonic counters
+type MonotonicConfig struct {
+	Enabled   bool          `mapstructure:"enabled"`
+	Retention time.Duration `mapstructure:"retention"`
+}
+
+// counterCollector is implemented by the object collectors that export counters which are reset
+// when the kernel object is recreated
+type counterCollector interface {
+	counters() []*prometheus.Desc
+}
+
+// objectKey is the stable identity of a qdisc or class. Unlike the kernel object it survives the
+// qdisc being deleted and created again. The parent is only part of it for the objects without a
+// handle, like the children of mq and mqprio, which can only be told apart by their parent.
+type objectKey struct {
+	ns     string
+	link   string
+	kind   string
+	handle string
+	parent string
+}
+
+// counterKey identifies a single counter of an object. The link index and parent are left out
+// because they can change when an object is replaced, except for the objects without a handle.
+type counterKey struct {
+	desc   *prometheus.Desc
+	labels string
+}
+
+type counterState struct {
+	offset float64
+	last   float64
+}
+
+type objectState struct {
+	host     string
+	created  time.Time
+	seen     time.Time
+	resets   uint64
+	counters map[counterKey]*counterState
+}
+
+// MonotonicTracker turns the counters of the qdiscs and classes into counters that keep increasing
+// when the kernel resets them. An object is reset when one of its counters goes backwards, the
+// values seen before the reset are then added to all counters of the object from there on.
+type MonotonicTracker struct {
+	logger    slog.Logger
+	retention time.Duration
+	counters  map[*prometheus.Desc]bool
+	resets    *prometheus.Desc
+	created   *prometheus.Desc
+
+	mu      sync.Mutex
+	objects map[objectKey]*objectState
+}
+
+// NewMonotonicTracker creates a MonotonicTracker for the counters of the given collectors
+func NewMonotonicTracker(collectors map[string]ObjectCollector, interfaceCollectors map[string]InterfaceCollector, cfg MonotonicConfig, log *slog.Logger) *MonotonicTracker {
+	log = log.With("collector", "monotonic")
+	log.Info("making monotonic counter tracker")
+	retention := cfg.Retention
+	if retention <= 0 {
+		retention = time.Hour
+	}
+
+	counters := make(map[*prometheus.Desc]bool)
+	register := func(col any) {
+		if cc, ok := col.(counterCollector); ok {
+			for _, d := range cc.counters() {
+				counters[d] = true
+			}
+		}
+	}
+	for _, col := range collectors {
+		register(col)
+	}
+	for _, col := range interfaceCollectors {
+		register(col)
+	}
+
+	return &MonotonicTracker{
+		logger:    *log,
+		retention: retention,
+		counters:  counters,
+		resets: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "object", "resets_total"),
+			"Number of times the counters of the object were reset by the kernel",
+			objectLabels, nil,
+		),
+		created: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "object", "created_timestamp_seconds"),
+			"Time the exporter first saw the current instance of the object",
+			objectLabels, nil,
+		),
+		objects: make(map[objectKey]*objectState),
+	}
+}
+
+// Describe implements Collector
+func (mt *MonotonicTracker) Describe(ch chan<- *prometheus.Desc) {
+	ds := []*prometheus.Desc{
+		mt.resets,
+		mt.created,
+	}
+
+	for _, d := range ds {
+		ch <- d
+	}
+}
+
+// process replaces the counters in the metrics of a scrape by their monotonic value and adds the
+// reset and creation metrics of the objects. All metrics of the scrape are needed, a reset of an
+// object has to be known before any of its counters can be adjusted.
+func (mt *MonotonicTracker) process(metrics []prometheus.Metric) []prometheus.Metric {
+	type sample struct {
+		idx   int
+		key   counterKey
+		value float64
+	}
+	now := time.Now()
+	out := make([]prometheus.Metric, len(metrics))
+	copy(out, metrics)
+	samples := make(map[objectKey][]sample)
+	hosts := make(map[objectKey]string)
+	for i, m := range metrics {
+		if !mt.counters[m.Desc()] {
+			continue
+		}
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil || pb.Counter == nil {
+			continue
+		}
+		var key objectKey
+		var host, parent string
+		var labels strings.Builder
+		for _, lp := range pb.Label {
+			switch lp.GetName() {
+			case "host":
+				host = lp.GetValue()
+				continue
+			case "linkindex":
+				continue
+			case "parent":
+				parent = lp.GetValue()
+				continue
+			case "netns":
+				key.ns = lp.GetValue()
+			case "link":
+				key.link = lp.GetValue()
+			case "type":
+				key.kind = lp.GetValue()
+			case "handle":
+				key.handle = lp.GetValue()
+			}
+			labels.WriteString(lp.GetName() + "=" + lp.GetValue() + ",")
+		}
+		if key.handle == "0:0" {
+			key.parent = parent
+			labels.WriteString("parent=" + parent + ",")
+		}
+		hosts[key] = host
+		samples[key] = append(samples[key], sample{i, counterKey{m.Desc(), labels.String()}, pb.Counter.GetValue()})
+	}
+
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	for key, ss := range samples {
+		st, found := mt.objects[key]
+		if !found {
+			st = &objectState{created: now, counters: make(map[counterKey]*counterState)}
+			mt.objects[key] = st
+		}
+		st.host = hosts[key]
+		st.seen = now
+
+		reset := false
+		for _, s := range ss {
+			if c, found := st.counters[s.key]; found && s.value < c.last {
+				reset = true
+			}
+		}
+		if reset {
+			mt.logger.Debug("object counters were reset", "netns", key.ns, "link", key.link, "type", key.kind, "handle", key.handle, "parent", key.parent)
+			st.resets++
+			st.created = now
+			for _, c := range st.counters {
+				c.offset += c.last
+				c.last = 0
+			}
+		}
+		for _, s := range ss {
+			c, found := st.counters[s.key]
+			if !found {
+				c = &counterState{}
+				st.counters[s.key] = c
+			}
+			c.last = s.value
+			out[s.idx] = virtualCounter{Metric: metrics[s.idx], value: c.offset + s.value}
+		}
+	}
+
+	for key, st := range mt.objects {
+		// keep the objects that are missing for a while, provisioning can delete an object and
+		// create it again a few scrapes later
+		if now.Sub(st.seen) > mt.retention {
+			delete(mt.objects, key)
+			continue
+		}
+		if st.seen != now {
+			continue
+		}
+		out = append(out,
+			prometheus.MustNewConstMetric(
+				mt.resets,
+				prometheus.CounterValue,
+				float64(st.resets),
+				st.host, key.ns, key.link, key.kind, key.handle, key.parent,
+			),
+			prometheus.MustNewConstMetric(
+				mt.created,
+				prometheus.GaugeValue,
+				float64(st.created.UnixNano())/1e9,
+				st.host, key.ns, key.link, key.kind, key.handle, key.parent,
+			),
+		)
+	}
+	return out
+}
+
+// virtualCounter is a counter metric with its value replaced by the monotonic value
+type virtualCounter struct {
+	prometheus.Metric
+	value float64
+}
+
+// Write implements Metric
+func (m virtualCounter) Write(out *dto.Metric) error {
+	if err := m.Metric.Write(out); err != nil {
+		return err
+	}
+	out.Counter.Value = &m.value
+	return nil
+}
@@ -0,0 +1,146 @@
+package tccollector_test
+
+import (
+	"log/slog"
+	"os"
+	"testing"
+
+	tcexporter "github.com/fbegyn/tc_exporter/collector"
+	"github.com/florianl/go-tc"
+	"github.com/florianl/go-tc/core"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMonotonicTracker(t *testing.T) {
+	bytes := prometheus.NewDesc("tc_qdisc_bytes", "bytes", []string{"host", "netns", "linkindex", "link", "type", "handle", "parent"}, nil)
+	mt := tcexporter.NewTestMonotonicTracker(bytes)
+
+	type qdisc struct {
+		handle string
+		parent string
+	}
+	// the root mq and two of its children, which all have handle 0:
+	root := qdisc{"1:0", "ffff:ffff"}
+	child1 := qdisc{"0:0", "1:1"}
+	child2 := qdisc{"0:0", "1:2"}
+	scrape := func(values map[qdisc]float64) []metric {
+		var metrics []prometheus.Metric
+		for qd, value := range values {
+			metrics = append(metrics, prometheus.MustNewConstMetric(
+				bytes, prometheus.CounterValue, value, "host", "default", "2", "eth0", "mq", qd.handle, qd.parent,
+			))
+		}
+		return collectMetrics(t, func(ch chan<- prometheus.Metric) {
+			for _, m := range mt.Process(metrics) {
+				ch <- m
+			}
+		})
+	}
+
+	tests := []struct {
+		name   string
+		values map[qdisc]float64
+		want   map[qdisc]float64
+		resets map[qdisc]float64
+	}{
+		{
+			name:   "first scrape",
+			values: map[qdisc]float64{root: 1500, child1: 1000, child2: 500},
+			want:   map[qdisc]float64{root: 1500, child1: 1000, child2: 500},
+			resets: map[qdisc]float64{root: 0, child1: 0, child2: 0},
+		},
+		{
+			// a child with a lower value than the other child is not a reset
+			name:   "children apart",
+			values: map[qdisc]float64{root: 1800, child1: 1200, child2: 600},
+			want:   map[qdisc]float64{root: 1800, child1: 1200, child2: 600},
+			resets: map[qdisc]float64{root: 0, child1: 0, child2: 0},
+		},
+		{
+			name:   "reset of a child",
+			values: map[qdisc]float64{root: 2000, child1: 1300, child2: 100},
+			want:   map[qdisc]float64{root: 2000, child1: 1300, child2: 700},
+			resets: map[qdisc]float64{root: 0, child1: 0, child2: 1},
+		},
+		{
+			name:   "after the reset",
+			values: map[qdisc]float64{root: 2100, child1: 1300, child2: 200},
+			want:   map[qdisc]float64{root: 2100, child1: 1300, child2: 800},
+			resets: map[qdisc]float64{root: 0, child1: 0, child2: 1},
+		},
+	}
+	for _, tt := range tests {
+		metrics := scrape(tt.values)
+		for qd, want := range tt.want {
+			got, ok := findMetric(metrics, "tc_qdisc_bytes", map[string]string{"handle": qd.handle, "parent": qd.parent})
+			if !ok || got != want {
+				t.Fatalf("%s: unexpected bytes of %s parent %s: got %v (%t), want %v", tt.name, qd.handle, qd.parent, got, ok, want)
+			}
+		}
+		for qd, want := range tt.resets {
+			// the parent is only part of the identity of the objects without a handle
+			parent := ""
+			if qd.handle == "0:0" {
+				parent = qd.parent
+			}
+			got, ok := findMetric(metrics, "tc_object_resets_total", map[string]string{"handle": qd.handle, "parent": parent})
+			if !ok || got != want {
+				t.Fatalf("%s: unexpected resets of %s parent %s: got %v (%t), want %v", tt.name, qd.handle, qd.parent, got, ok, want)
+			}
+		}
+	}
+}
+
+func TestMonotonicTrackerKindCounters(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	col, err := tcexporter.NewFqCodelQdiscCollector(nil, logger)
+	if err != nil {
+		t.Fatalf("failed to create fq_codel collector: %v", err)
+	}
+	mt := tcexporter.NewMonotonicTracker(map[string]tcexporter.ObjectCollector{"fq_codel": col}, nil, tcexporter.MonotonicConfig{}, logger)
+	interf := rtnetlink.LinkMessage{Index: 2, Attributes: &rtnetlink.LinkAttributes{Name: "eth0"}}
+	scrape := func(xs tc.FqCodelQdStats) []metric {
+		qd := tc.Object{
+			Msg:       tc.Msg{Handle: core.BuildHandle(1, 0), Parent: tc.HandleRoot},
+			Attribute: tc.Attribute{Kind: "fq_codel", XStats: &tc.XStats{FqCodel: &tc.FqCodelXStats{Qd: &xs}}},
+		}
+		ch := make(chan prometheus.Metric, 16)
+		col.CollectObject(ch, "host", "default", interf, qd)
+		close(ch)
+		var metrics []prometheus.Metric
+		for m := range ch {
+			metrics = append(metrics, m)
+		}
+		return collectMetrics(t, func(ch chan<- prometheus.Metric) {
+			for _, m := range mt.Process(metrics) {
+				ch <- m
+			}
+		})
+	}
+
+	tests := []struct {
+		name    string
+		xstats  tc.FqCodelQdStats
+		overlim float64
+		memory  float64
+		resets  float64
+	}{
+		{"first scrape", tc.FqCodelQdStats{DropOverlimit: 10, MemoryUsage: 5000}, 10, 5000, 0},
+		// the memory usage goes down without the qdisc being replaced
+		{"memory usage down", tc.FqCodelQdStats{DropOverlimit: 12, MemoryUsage: 1000}, 12, 1000, 0},
+		{"replaced", tc.FqCodelQdStats{DropOverlimit: 3, MemoryUsage: 2000}, 15, 2000, 1},
+	}
+	for _, tt := range tests {
+		metrics := scrape(tt.xstats)
+		if got, ok := findMetric(metrics, "tc_fq_codel_drop_overlimit", nil); !ok || got != tt.overlim {
+			t.Fatalf("%s: unexpected overlimit drops: got %v (%t), want %v", tt.name, got, ok, tt.overlim)
+		}
+		if got, ok := findMetric(metrics, "tc_fq_codel_memory_usage", nil); !ok || got != tt.memory {
+			t.Fatalf("%s: unexpected memory usage: got %v (%t), want %v", tt.name, got, ok, tt.memory)
+		}
+		if got, ok := findMetric(metrics, "tc_object_resets_total", nil); !ok || got != tt.resets {
+			t.Fatalf("%s: unexpected resets: got %v (%t), want %v", tt.name, got, ok, tt.resets)
+		}
+	}
+}
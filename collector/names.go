@@ -0,0 +1,199 @@
+package tccollector
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/florianl/go-tc"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	nameLabels []string = []string{"host", "netns", "linkindex", "link", "handle", "name"}
+)
+
+// defaultNamesFile is the file iproute2 reads the class names from
+const defaultNamesFile = "/etc/iproute2/tc_cls"
+
+// NamesConfig configures the names of the qdiscs and classes
+type NamesConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	File    string `mapstructure:"file"`
+	// Handles maps handles in tc notation to a name, they take precedence over the file
+	Handles map[string]string `mapstructure:"handles"`
+}
+
+// NameResolver looks up the names of qdiscs and classes in the iproute2 tc_cls file and the
+// configured handles. The file is read again when it changes.
+type NameResolver struct {
+	logger slog.Logger
+	file   string
+	config map[uint32]string
+
+	mu        sync.Mutex
+	checked   time.Time
+	modTime   time.Time
+	fileNames map[uint32]string
+}
+
+// NewNameResolver creates a NameResolver for the configuration
+func NewNameResolver(cfg NamesConfig, log *slog.Logger) (*NameResolver, error) {
+	log = log.With("collector", "names")
+	file := cfg.File
+	if file == "" {
+		file = defaultNamesFile
+	}
+	config := make(map[uint32]string, len(cfg.Handles))
+	for h, name := range cfg.Handles {
+		handle, err := ParseHandle(h)
+		if err != nil {
+			return nil, err
+		}
+		config[handle] = name
+	}
+
+	return &NameResolver{
+		logger: *log,
+		file:   file,
+		config: config,
+	}, nil
+}
+
+// Name returns the name of the handle
+func (nr *NameResolver) Name(handle uint32) (string, bool) {
+	if name, found := nr.config[handle]; found {
+		return name, true
+	}
+	nr.mu.Lock()
+	defer nr.mu.Unlock()
+	nr.reload()
+	name, found := nr.fileNames[handle]
+	return name, found
+}
+
+// reload reads the file again when it was modified. The file is checked at most once a second, a
+// scrape looks up the name of every object.
+func (nr *NameResolver) reload() {
+	now := time.Now()
+	if now.Sub(nr.checked) < time.Second {
+		return
+	}
+	nr.checked = now
+
+	info, err := os.Stat(nr.file)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			nr.logger.Error("failed to stat names file", "file", nr.file, "err", err)
+		}
+		nr.fileNames = nil
+		nr.modTime = time.Time{}
+		return
+	}
+	if info.ModTime().Equal(nr.modTime) {
+		return
+	}
+
+	names, err := readNamesFile(nr.file)
+	if err != nil {
+		nr.logger.Error("failed to read names file", "file", nr.file, "err", err)
+		return
+	}
+	nr.logger.Info("loaded names file", "file", nr.file, "names", len(names))
+	nr.fileNames = names
+	nr.modTime = info.ModTime()
+}
+
+// readNamesFile parses a file in the iproute2 tc_cls format. Every line holds a handle and a name,
+// the handle is either in tc notation (1:10) or a hex number (10010).
+func readNamesFile(file string) (map[uint32]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	names := make(map[uint32]string)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: expected a handle and a name", line)
+		}
+		var handle uint32
+		if strings.Contains(fields[0], ":") {
+			handle, err = ParseHandle(fields[0])
+		} else {
+			var v uint64
+			v, err = strconv.ParseUint(fields[0], 16, 32)
+			handle = uint32(v)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		names[handle] = fields[1]
+	}
+	return names, scanner.Err()
+}
+
+// NameCollector exports the names of qdiscs or classes as info metrics that can be joined on the
+// handle
+type NameCollector struct {
+	logger   slog.Logger
+	resolver *NameResolver
+	info     *prometheus.Desc
+}
+
+// NewNameCollector create a new NameCollector for the given object type (qdisc or class)
+func NewNameCollector(resolver *NameResolver, object string, log *slog.Logger) (ObjectCollector, error) {
+	log = log.With("collector", "names", "object", object)
+	log.Info("making name collector")
+
+	return &NameCollector{
+		logger:   *log,
+		resolver: resolver,
+		info: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, object, "name_info"),
+			fmt.Sprintf("Name of the %s from tc_cls or the config", object),
+			nameLabels, nil,
+		),
+	}, nil
+}
+
+// Describe implements Collector
+func (col *NameCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- col.info
+}
+
+// CollectObject fetches and updates the data the collector is exporting
+func (col *NameCollector) CollectObject(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, obj tc.Object) {
+	name, found := col.resolver.Name(obj.Handle)
+	if !found {
+		return
+	}
+	handleMaj, handleMin := HandleStr(obj.Handle)
+
+	ch <- prometheus.MustNewConstMetric(
+		col.info,
+		prometheus.GaugeValue,
+		1,
+		host,
+		ns,
+		fmt.Sprintf("%d", interf.Index),
+		interf.Attributes.Name,
+		fmt.Sprintf("%x:%x", handleMaj, handleMin),
+		name,
+	)
+}
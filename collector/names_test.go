@@ -0,0 +1,57 @@
+package tccollector_test
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	tcexporter "github.com/fbegyn/tc_exporter/collector"
+	"github.com/florianl/go-tc/core"
+)
+
+func TestNameResolver(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "tc_cls")
+	content := `# class names
+1:10	web
+1:20	voip
+10040	bulk
+
+10:	leaf
+`
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write names file: %v", err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	nr, err := tcexporter.NewNameResolver(tcexporter.NamesConfig{
+		File:    file,
+		Handles: map[string]string{"1:20": "realtime", "1:30": "default"},
+	}, logger)
+	if err != nil {
+		t.Fatalf("failed to create name resolver: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		handle uint32
+		want   string
+		found  bool
+	}{
+		{name: "file", handle: core.BuildHandle(1, 0x10), want: "web", found: true},
+		{name: "config overrides file", handle: core.BuildHandle(1, 0x20), want: "realtime", found: true},
+		{name: "config", handle: core.BuildHandle(1, 0x30), want: "default", found: true},
+		{name: "hex id", handle: core.BuildHandle(1, 0x40), want: "bulk", found: true},
+		{name: "qdisc", handle: core.BuildHandle(0x10, 0), want: "leaf", found: true},
+		{name: "unknown", handle: core.BuildHandle(1, 0x50), found: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, found := nr.Name(tt.handle)
+			if found != tt.found || name != tt.want {
+				t.Fatalf("unexpected name: got %q (%t), want %q (%t)", name, found, tt.want, tt.found)
+			}
+		})
+	}
+}
@@ -0,0 +1,415 @@
+package tccollector
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/florianl/go-tc"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/mdlayher/netlink"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
+)
+
+// tc netlink attributes that are decoded by hand. go-tc does not expose all of the attributes the
+// kernel sends, so for those we read the raw messages ourselves.
+const (
+	tcaKind    = 1
+	tcaOptions = 2
+	tcaStats   = 3
+	tcaXStats  = 4
+	tcaStats2  = 7
+
+	tcaStatsBasic     = 1
+	tcaStatsRateEst   = 2
+	tcaStatsQueue     = 3
+	tcaStatsRateEst64 = 5
+)
+
+// tcmsgLen is the size of the tcmsg header that precedes the attributes of every tc message
+const tcmsgLen = 20
+
+// rawObject is a tc object as it was received from the kernel, with its attributes left undecoded
+type rawObject struct {
+	Ifindex uint32
+	Handle  uint32
+	Parent  uint32
+	Info    uint32
+	Kind    string
+	Attrs   map[uint16][]byte
+	Stats2  map[uint16][]byte
+}
+
+// GetRouteConn gets a raw route netlink connection for the specified network namespace
+func GetRouteConn(ns string) (*netlink.Conn, error) {
+	if ns == "default" {
+		return netlink.Dial(unix.NETLINK_ROUTE, nil)
+	}
+	f, err := os.Open("/var/run/netns/" + ns)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return netlink.Dial(unix.NETLINK_ROUTE, &netlink.Config{
+		NetNS: int(f.Fd()),
+	})
+}
+
+// getRawObjects dumps the tc objects of the given message type (RTM_GETQDISC, RTM_GETTCLASS, ...)
+// for an interface in the netns
+func getRawObjects(ns string, typ netlink.HeaderType, devid, parent uint32) ([]rawObject, error) {
+	conn, err := GetRouteConn(ns)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return dumpRawObjects(conn, typ, devid, parent)
+}
+
+// dumpRawObjects dumps the tc objects like getRawObjects, over a connection that is already open.
+// The callers that dump every parent of an interface share a single connection.
+func dumpRawObjects(conn *netlink.Conn, typ netlink.HeaderType, devid, parent uint32) ([]rawObject, error) {
+	req := make([]byte, tcmsgLen)
+	req[0] = unix.AF_UNSPEC
+	binary.NativeEndian.PutUint32(req[4:8], devid)
+	binary.NativeEndian.PutUint32(req[12:16], parent)
+
+	msgs, err := conn.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  typ,
+			Flags: netlink.Request | netlink.Dump,
+		},
+		Data: req,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var objs []rawObject
+	for _, msg := range msgs {
+		obj, err := parseRawObject(msg.Data)
+		if err != nil {
+			return nil, err
+		}
+		// the qdisc dump ignores the interface index of the request
+		if obj.Ifindex != devid {
+			continue
+		}
+		objs = append(objs, obj)
+	}
+	return objs, nil
+}
+
+// parseRawObject decodes the tcmsg header and the top level attributes of a tc message
+func parseRawObject(data []byte) (rawObject, error) {
+	if len(data) < tcmsgLen {
+		return rawObject{}, errors.New("tc message is too short")
+	}
+	obj := rawObject{
+		Ifindex: binary.NativeEndian.Uint32(data[4:8]),
+		Handle:  binary.NativeEndian.Uint32(data[8:12]),
+		Parent:  binary.NativeEndian.Uint32(data[12:16]),
+		Info:    binary.NativeEndian.Uint32(data[16:20]),
+	}
+
+	attrs, err := parseAttrs(data[tcmsgLen:])
+	if err != nil {
+		return rawObject{}, err
+	}
+	obj.Attrs = attrs
+	if kind, ok := attrs[tcaKind]; ok {
+		obj.Kind = nullStr(kind)
+	}
+	if stats2, ok := attrs[tcaStats2]; ok {
+		obj.Stats2, err = parseAttrs(stats2)
+		if err != nil {
+			return rawObject{}, err
+		}
+	}
+	return obj, nil
+}
+
+// parseAttrs decodes a stream of netlink attributes into a map indexed by attribute type
+func parseAttrs(b []byte) (map[uint16][]byte, error) {
+	ad, err := netlink.NewAttributeDecoder(b)
+	if err != nil {
+		return nil, err
+	}
+	attrs := make(map[uint16][]byte)
+	for ad.Next() {
+		attrs[ad.Type()] = ad.Bytes()
+	}
+	return attrs, ad.Err()
+}
+
+// nullStr converts a NUL terminated netlink string
+func nullStr(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
+
+// basic returns the byte and packet counters of the object
+func (obj rawObject) basic() (bytes, packets uint64, ok bool) {
+	if b, found := obj.Stats2[tcaStatsBasic]; found && len(b) >= 12 {
+		return binary.NativeEndian.Uint64(b[0:8]), uint64(binary.NativeEndian.Uint32(b[8:12])), true
+	}
+	// fall back on the legacy tc_stats struct
+	if b, found := obj.Attrs[tcaStats]; found && len(b) >= 12 {
+		return binary.NativeEndian.Uint64(b[0:8]), uint64(binary.NativeEndian.Uint32(b[8:12])), true
+	}
+	return 0, 0, false
+}
+
+// queue returns the queue length and backlog of the object
+func (obj rawObject) queue() (qlen, backlog uint32, ok bool) {
+	if b, found := obj.Stats2[tcaStatsQueue]; found && len(b) >= 8 {
+		return binary.NativeEndian.Uint32(b[0:4]), binary.NativeEndian.Uint32(b[4:8]), true
+	}
+	// fall back on the legacy tc_stats struct
+	if b, found := obj.Attrs[tcaStats]; found && len(b) >= 36 {
+		return binary.NativeEndian.Uint32(b[28:32]), binary.NativeEndian.Uint32(b[32:36]), true
+	}
+	return 0, 0, false
+}
+
+// hasRateEstimator reports if the kernel has a rate estimator attached to the object. The rate
+// estimate is only added to the stats when an estimator exists.
+func (obj rawObject) hasRateEstimator() bool {
+	_, est := obj.Stats2[tcaStatsRateEst]
+	_, est64 := obj.Stats2[tcaStatsRateEst64]
+	return est || est64
+}
+
+// rawAttr is a netlink attribute of a list where the same type can occur more than once
+type rawAttr struct {
+	Type uint16
+	Data []byte
+}
+
+// parseAttrList decodes a stream of netlink attributes in order
+func parseAttrList(b []byte) ([]rawAttr, error) {
+	ad, err := netlink.NewAttributeDecoder(b)
+	if err != nil {
+		return nil, err
+	}
+	var attrs []rawAttr
+	for ad.Next() {
+		attrs = append(attrs, rawAttr{Type: ad.Type(), Data: ad.Bytes()})
+	}
+	return attrs, ad.Err()
+}
+
+// rawKey identifies a tc object of an interface. The handle alone is not enough, the children of
+// mq and mqprio all have handle 0: and can only be told apart by their parent.
+type rawKey struct {
+	handle uint32
+	parent uint32
+}
+
+// indexRawObjects indexes the raw objects of an interface by handle and parent
+func indexRawObjects(objs []rawObject) map[rawKey]*rawObject {
+	idx := make(map[rawKey]*rawObject, len(objs))
+	for i := range objs {
+		idx[rawKey{objs[i].Handle, objs[i].Parent}] = &objs[i]
+	}
+	return idx
+}
+
+// rawDump dumps the qdiscs or the classes of an interface the first time an object is looked up.
+// The collectors that decode the raw messages share the dump, so the interface is dumped once per
+// scrape instead of once per object or collector.
+type rawDump struct {
+	ns    string
+	typ   netlink.HeaderType
+	devid uint32
+
+	dumped bool
+	list   []rawObject
+	objs   map[rawKey]*rawObject
+	err    error
+}
+
+// newRawDump creates the dump of the objects of the given message type for an interface
+func newRawDump(ns string, typ netlink.HeaderType, devid uint32) *rawDump {
+	return &rawDump{ns: ns, typ: typ, devid: devid}
+}
+
+// all returns the objects of the interface in dump order
+func (d *rawDump) all() ([]rawObject, error) {
+	if !d.dumped {
+		d.list, d.err = getRawObjects(d.ns, d.typ, d.devid, 0)
+		d.objs, d.dumped = indexRawObjects(d.list), true
+	}
+	return d.list, d.err
+}
+
+// index returns the objects of the interface by handle and parent
+func (d *rawDump) index() (map[rawKey]*rawObject, error) {
+	if _, err := d.all(); err != nil {
+		return nil, err
+	}
+	return d.objs, nil
+}
+
+// get returns the raw message of the tc object
+func (d *rawDump) get(obj tc.Object) (rawObject, error) {
+	objs, err := d.index()
+	if err != nil {
+		return rawObject{}, err
+	}
+	raw, found := objs[rawKey{obj.Handle, obj.Parent}]
+	if !found {
+		return rawObject{}, fmt.Errorf("no tc object with handle %x and parent %x on interface %d", obj.Handle, obj.Parent, d.devid)
+	}
+	return *raw, nil
+}
+
+// rawCollector is implemented by the object collectors that decode the raw netlink message of the
+// object. They are passed the dump of the interface, CollectObject dumps the interface itself.
+type rawCollector interface {
+	collectRaw(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, obj tc.Object, raws *rawDump)
+}
+
+// collectObject passes the object to the collector, together with the dump of the interface when
+// the collector decodes the raw messages
+func collectObject(col ObjectCollector, ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, obj tc.Object, raws *rawDump) {
+	if rc, ok := col.(rawCollector); ok {
+		rc.collectRaw(ch, host, ns, interf, obj, raws)
+		return
+	}
+	col.CollectObject(ch, host, ns, interf, obj)
+}
+
+// rawInterfaceCollector is implemented by the interface collectors that decode the raw netlink
+// messages of the qdiscs and classes. They are passed the dumps of the interface, CollectInterface
+// dumps the interface itself.
+type rawInterfaceCollector interface {
+	collectRawInterface(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, qdiscs, classes []tc.Object, rawQdiscs, rawClasses *rawDump)
+}
+
+// collectInterface passes the objects of the interface to the collector, together with the dumps
+// of the interface when the collector decodes the raw messages
+func collectInterface(col InterfaceCollector, ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, qdiscs, classes []tc.Object, rawQdiscs, rawClasses *rawDump) {
+	if rc, ok := col.(rawInterfaceCollector); ok {
+		rc.collectRawInterface(ch, host, ns, interf, qdiscs, classes, rawQdiscs, rawClasses)
+		return
+	}
+	col.CollectInterface(ch, host, ns, interf, qdiscs, classes)
+}
+
+// rawQdiscKinds maps the qdiscs whose collectors decode the raw netlink messages to their
+// collector. go-tc does not decode xstats for them, so they are not skipped when the xstats are
+// missing and their collector is running.
+var rawQdiscKinds = map[string]string{
+	"bfifo":           "fifo",
+	"cbs":             "cbs",
+	"etf":             "etf",
+	"gred":            "gred",
+	"noqueue":         "fifo",
+	"pfifo":           "fifo",
+	"pfifo_fast":      "fifo",
+	"pfifo_head_drop": "fifo",
+	"skbprio":         "skbprio",
+}
+
+// rawClassKinds maps the classes whose collectors decode the raw netlink messages to their
+// collector, like rawQdiscKinds
+var rawClassKinds = map[string]string{
+	"qfq":     "qfq",
+	"skbprio": "skbprio",
+}
+
+// rawCollected reports if the object kind is collected by a running raw netlink collector
+func rawCollected(kinds map[string]string, collectors map[string]ObjectCollector, kind string) bool {
+	key, found := kinds[kind]
+	if !found {
+		return false
+	}
+	_, running := collectors[key]
+	return running
+}
+
+// tc action table netlink attributes
+const (
+	tcaActTab    = 1
+	tcaRootFlags = 2
+
+	// tcaActFlagLargeDumpOn lets the kernel fit more than 32 actions in a dump message
+	tcaActFlagLargeDumpOn = 1 << 0
+
+	// tcamsgLen is the size of the tcamsg header that precedes the attributes of the action messages
+	tcamsgLen = 4
+)
+
+// getActions dumps the action table of an action kind in the netns. The table holds all the
+// actions of the kind, the standalone ones and the ones that were created by filters.
+func getActions(ns, kind string) ([]rawAction, error) {
+	ae := netlink.NewAttributeEncoder()
+	ae.Nested(tcaActTab, func(tab *netlink.AttributeEncoder) error {
+		tab.Nested(1, func(act *netlink.AttributeEncoder) error {
+			act.String(tcaActKind, kind)
+			return nil
+		})
+		return nil
+	})
+	// struct nla_bitfield32, the value and the selector of the flags
+	flags := make([]byte, 8)
+	binary.NativeEndian.PutUint32(flags[0:4], tcaActFlagLargeDumpOn)
+	binary.NativeEndian.PutUint32(flags[4:8], tcaActFlagLargeDumpOn)
+	ae.Bytes(tcaRootFlags, flags)
+	attrs, err := ae.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := GetRouteConn(ns)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	req := make([]byte, tcamsgLen)
+	req[0] = unix.AF_UNSPEC
+	msgs, err := conn.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  unix.RTM_GETACTION,
+			Flags: netlink.Request | netlink.Dump,
+		},
+		Data: append(req, attrs...),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return parseActionMessages(msgs)
+}
+
+// parseActionMessages decodes the actions of the messages of an action table dump
+func parseActionMessages(msgs []netlink.Message) ([]rawAction, error) {
+	var actions []rawAction
+	for _, msg := range msgs {
+		if len(msg.Data) < tcamsgLen {
+			return nil, errors.New("tc action message is too short")
+		}
+		attrs, err := parseAttrs(msg.Data[tcamsgLen:])
+		if err != nil {
+			return nil, err
+		}
+		tab, ok := attrs[tcaActTab]
+		if !ok {
+			continue
+		}
+		acts, err := parseActions(tab)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, acts...)
+	}
+	return actions, nil
+}
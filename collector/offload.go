@@ -0,0 +1,150 @@
+package tccollector
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/florianl/go-tc"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	offloadLabels       []string = []string{"host", "netns", "linkindex", "link", "type", "handle", "parent"}
+	offloadSourceLabels []string = []string{"host", "netns", "linkindex", "link", "type", "handle", "parent", "source"}
+)
+
+// offloadDescs are the descs of either the qdiscs or the classes
+type offloadDescs struct {
+	offloaded *prometheus.Desc
+	bytes     *prometheus.Desc
+	packets   *prometheus.Desc
+}
+
+// newOffloadDescs creates the descs for the object type (qdisc or class)
+func newOffloadDescs(object string) offloadDescs {
+	return offloadDescs{
+		offloaded: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, object, "offloaded"),
+			fmt.Sprintf("Whether the %s is offloaded to the hardware", object),
+			offloadLabels, nil,
+		),
+		bytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, object, "offload_bytes"),
+			fmt.Sprintf("Bytes handled by the %s, by the hardware or the software", object),
+			offloadSourceLabels, nil,
+		),
+		packets: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, object, "offload_packets"),
+			fmt.Sprintf("Packets handled by the %s, by the hardware or the software", object),
+			offloadSourceLabels, nil,
+		),
+	}
+}
+
+// OffloadCollector exports the hardware offload state of the qdiscs and classes. The split of the
+// counters in hardware and software is only exported when the kernel reports hardware stats for
+// the object.
+type OffloadCollector struct {
+	logger slog.Logger
+	netns  map[string][]rtnetlink.LinkMessage
+	qdisc  offloadDescs
+	class  offloadDescs
+}
+
+// NewOffloadCollector create a new OffloadCollector given a network interface
+func NewOffloadCollector(netns map[string][]rtnetlink.LinkMessage, log *slog.Logger) (InterfaceCollector, error) {
+	log = log.With("collector", "offload")
+	log.Info("making offload collector")
+
+	return &OffloadCollector{
+		logger: *log,
+		netns:  netns,
+		qdisc:  newOffloadDescs("qdisc"),
+		class:  newOffloadDescs("class"),
+	}, nil
+}
+
+// Describe implements Collector
+func (col *OffloadCollector) Describe(ch chan<- *prometheus.Desc) {
+	ds := []*prometheus.Desc{
+		col.qdisc.offloaded,
+		col.qdisc.bytes,
+		col.qdisc.packets,
+		col.class.offloaded,
+		col.class.bytes,
+		col.class.packets,
+	}
+
+	for _, d := range ds {
+		ch <- d
+	}
+}
+
+// CollectInterface exports the offload state of the qdiscs and classes of the interface
+func (col *OffloadCollector) CollectInterface(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, qdiscs, classes []tc.Object) {
+	col.collectRawInterface(ch, host, ns, interf, qdiscs, classes,
+		newRawDump(ns, unix.RTM_GETQDISC, interf.Index), newRawDump(ns, unix.RTM_GETTCLASS, interf.Index))
+}
+
+// collectRawInterface implements rawInterfaceCollector
+func (col *OffloadCollector) collectRawInterface(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, qdiscs, classes []tc.Object, rawQdiscs, rawClasses *rawDump) {
+	col.collectObjects(ch, col.qdisc, host, ns, interf, qdiscs, rawQdiscs)
+	col.collectObjects(ch, col.class, host, ns, interf, classes, rawClasses)
+}
+
+// collectObjects exports the offload state of the objects, from the raw dump of their type
+func (col *OffloadCollector) collectObjects(ch chan<- prometheus.Metric, descs offloadDescs, host, ns string, interf rtnetlink.LinkMessage, objs []tc.Object, raws *rawDump) {
+	if len(objs) == 0 {
+		return
+	}
+	idx, err := raws.index()
+	if err != nil {
+		col.logger.Error("failed to get raw tc objects", "interface", interf.Attributes.Name, "err", err)
+		return
+	}
+	col.exportObjects(ch, descs, host, ns, interf, objs, idx)
+}
+
+// exportObjects exports the offload state of the objects from their raw messages, indexed by
+// handle and parent
+func (col *OffloadCollector) exportObjects(ch chan<- prometheus.Metric, descs offloadDescs, host, ns string, interf rtnetlink.LinkMessage, objs []tc.Object, raws map[rawKey]*rawObject) {
+	for _, obj := range objs {
+		raw, found := raws[rawKey{obj.Handle, obj.Parent}]
+		if !found {
+			continue
+		}
+		handleMaj, handleMin := HandleStr(obj.Handle)
+		parentMaj, parentMin := HandleStr(obj.Parent)
+		labels := []string{
+			host,
+			ns,
+			fmt.Sprintf("%d", interf.Index),
+			interf.Attributes.Name,
+			obj.Kind,
+			fmt.Sprintf("%x:%x", handleMaj, handleMin),
+			fmt.Sprintf("%x:%x", parentMaj, parentMin),
+		}
+
+		offloaded := 0.0
+		if v, ok := raw.Attrs[tcaHwOffload]; ok && len(v) >= 1 && v[0] != 0 {
+			offloaded = 1
+		}
+		ch <- prometheus.MustNewConstMetric(descs.offloaded, prometheus.GaugeValue, offloaded, labels...)
+
+		var counters splitCounters
+		if !counters.add(raw.Stats2) {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(descs.bytes, prometheus.CounterValue, counters.hwBytes, append(labels, "hw")...)
+		ch <- prometheus.MustNewConstMetric(descs.packets, prometheus.CounterValue, counters.hwPackets, append(labels, "hw")...)
+		ch <- prometheus.MustNewConstMetric(descs.bytes, prometheus.CounterValue, counters.swBytes, append(labels, "sw")...)
+		ch <- prometheus.MustNewConstMetric(descs.packets, prometheus.CounterValue, counters.swPackets, append(labels, "sw")...)
+	}
+}
+
+// counters implements counterCollector
+func (col *OffloadCollector) counters() []*prometheus.Desc {
+	return []*prometheus.Desc{col.qdisc.bytes, col.qdisc.packets, col.class.bytes, col.class.packets}
+}
@@ -0,0 +1,67 @@
+package tccollector_test
+
+import (
+	"log/slog"
+	"os"
+	"testing"
+
+	tcexporter "github.com/fbegyn/tc_exporter/collector"
+	"github.com/florianl/go-tc"
+	"github.com/florianl/go-tc/core"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestOffloadCollectorMqChildren(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	col, err := tcexporter.NewOffloadCollector(nil, logger)
+	if err != nil {
+		t.Fatalf("failed to create offload collector: %v", err)
+	}
+	interf := rtnetlink.LinkMessage{Index: 2, Attributes: &rtnetlink.LinkAttributes{Name: "eth0"}}
+
+	// the children of mq all have handle 0:, only the first one is offloaded
+	dump := tcexporter.NewTestRawDump(2,
+		tcexporter.TestRawObject{
+			Parent: core.BuildHandle(1, 1), Kind: "red",
+			Attrs:  map[uint16][]byte{12: {1}},
+			Stats2: map[uint16][]byte{1: gnetBasic(1500, 10), 7: gnetBasic(1000, 6)},
+		},
+		tcexporter.TestRawObject{
+			Parent: core.BuildHandle(1, 2), Kind: "red",
+			Attrs:  map[uint16][]byte{12: {0}},
+			Stats2: map[uint16][]byte{1: gnetBasic(700, 7)},
+		},
+	)
+	qdiscs := []tc.Object{
+		{Msg: tc.Msg{Parent: core.BuildHandle(1, 1)}, Attribute: tc.Attribute{Kind: "red"}},
+		{Msg: tc.Msg{Parent: core.BuildHandle(1, 2)}, Attribute: tc.Attribute{Kind: "red"}},
+	}
+	metrics := collectMetrics(t, func(ch chan<- prometheus.Metric) {
+		tcexporter.CollectInterfaceDumps(col, ch, "host", "default", interf, qdiscs, nil, dump, tcexporter.NewTestRawDump(2))
+	})
+
+	tests := []struct {
+		name   string
+		metric string
+		labels map[string]string
+		want   float64
+		found  bool
+	}{
+		{"offloaded", "tc_qdisc_offloaded", map[string]string{"parent": "1:1"}, 1, true},
+		{"not offloaded", "tc_qdisc_offloaded", map[string]string{"parent": "1:2"}, 0, true},
+		{"hw bytes", "tc_qdisc_offload_bytes", map[string]string{"parent": "1:1", "source": "hw"}, 1000, true},
+		{"sw bytes", "tc_qdisc_offload_bytes", map[string]string{"parent": "1:1", "source": "sw"}, 500, true},
+		{"sw packets", "tc_qdisc_offload_packets", map[string]string{"parent": "1:1", "source": "sw"}, 4, true},
+		// without hardware stats the counters are not split
+		{"no hw stats", "tc_qdisc_offload_bytes", map[string]string{"parent": "1:2"}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := findMetric(metrics, tt.metric, tt.labels)
+			if ok != tt.found || got != tt.want {
+				t.Fatalf("unexpected %s: got %v (%t), want %v (%t)", tt.metric, got, ok, tt.want, tt.found)
+			}
+		})
+	}
+}
@@ -124,3 +124,8 @@ func (col *CbqCollector) CollectObject(ch chan<- prometheus.Metric, host, ns str
 		fmt.Sprintf("%x:%x", parentMaj, parentMin),
 	)
 }
+
+// counters implements counterCollector
+func (col *CbqCollector) counters() []*prometheus.Desc {
+	return []*prometheus.Desc{col.borrows, col.overactions}
+}
@@ -0,0 +1,155 @@
+package tccollector
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+
+	"github.com/florianl/go-tc"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	cbsLabels []string = []string{"host", "netns", "linkindex", "link", "type", "handle", "parent"}
+)
+
+const (
+	tcaCbsParms = 1
+	// cbsQoptLen is the size of struct tc_cbs_qopt
+	cbsQoptLen = 20
+)
+
+// cbsParms is the configuration of a CBS qdisc
+type cbsParms struct {
+	offload   bool
+	hiCredit  int32
+	loCredit  int32
+	idleSlope int32
+	sendSlope int32
+}
+
+// parseCbsOptions decodes the TCA_OPTIONS of a CBS qdisc
+func parseCbsOptions(b []byte) (cbsParms, error) {
+	attrs, err := parseAttrs(b)
+	if err != nil {
+		return cbsParms{}, err
+	}
+	p := attrs[tcaCbsParms]
+	if len(p) < cbsQoptLen {
+		return cbsParms{}, fmt.Errorf("CBS parameters are too short: %d bytes", len(p))
+	}
+	return cbsParms{
+		offload:   p[0] != 0,
+		hiCredit:  int32(binary.NativeEndian.Uint32(p[4:8])),
+		loCredit:  int32(binary.NativeEndian.Uint32(p[8:12])),
+		idleSlope: int32(binary.NativeEndian.Uint32(p[12:16])),
+		sendSlope: int32(binary.NativeEndian.Uint32(p[16:20])),
+	}, nil
+}
+
+// CbsCollector is the object that will collect CBS qdisc data for the interface
+type CbsCollector struct {
+	logger slog.Logger
+	netns  map[string][]rtnetlink.LinkMessage
+
+	idleSlope *prometheus.Desc
+	sendSlope *prometheus.Desc
+	hiCredit  *prometheus.Desc
+	loCredit  *prometheus.Desc
+	offload   *prometheus.Desc
+}
+
+// NewCbsCollector create a new CbsCollector given a network interface
+func NewCbsCollector(netns map[string][]rtnetlink.LinkMessage, log *slog.Logger) (ObjectCollector, error) {
+	// Setup logger for cbs collector
+	log = log.With("collector", "cbs")
+	log.Info("making cbs collector")
+
+	return &CbsCollector{
+		logger: *log,
+		netns:  netns,
+		idleSlope: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "cbs", "idleslope_kbits"),
+			"CBS rate at which the credits accumulate when the queue is idle, in kbit/s",
+			cbsLabels, nil,
+		),
+		sendSlope: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "cbs", "sendslope_kbits"),
+			"CBS rate at which the credits are spent while transmitting, in kbit/s",
+			cbsLabels, nil,
+		),
+		hiCredit: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "cbs", "hicredit_bytes"),
+			"CBS upper limit of the credits",
+			cbsLabels, nil,
+		),
+		loCredit: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "cbs", "locredit_bytes"),
+			"CBS lower limit of the credits",
+			cbsLabels, nil,
+		),
+		offload: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "cbs", "offload"),
+			"Whether the CBS shaper is offloaded to the hardware",
+			cbsLabels, nil,
+		),
+	}, nil
+}
+
+// Describe implements Collector
+func (col *CbsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ds := []*prometheus.Desc{
+		col.idleSlope,
+		col.sendSlope,
+		col.hiCredit,
+		col.loCredit,
+		col.offload,
+	}
+
+	for _, d := range ds {
+		ch <- d
+	}
+}
+
+// CollectObject fetches and updates the data the collector is exporting
+func (col *CbsCollector) CollectObject(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, qd tc.Object) {
+	col.collectRaw(ch, host, ns, interf, qd, newRawDump(ns, unix.RTM_GETQDISC, interf.Index))
+}
+
+// collectRaw exports the CBS qdisc from the dump of the interface
+func (col *CbsCollector) collectRaw(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, qd tc.Object, raws *rawDump) {
+	raw, err := raws.get(qd)
+	if err != nil {
+		col.logger.Error("failed to get CBS qdisc", "interface", interf.Attributes.Name, "err", err)
+		return
+	}
+	parms, err := parseCbsOptions(raw.Attrs[tcaOptions])
+	if err != nil {
+		col.logger.Error("failed to parse CBS options", "interface", interf.Attributes.Name, "err", err)
+		return
+	}
+
+	handleMaj, handleMin := HandleStr(qd.Handle)
+	parentMaj, parentMin := HandleStr(qd.Parent)
+	labels := []string{
+		host,
+		ns,
+		fmt.Sprintf("%d", interf.Index),
+		interf.Attributes.Name,
+		qd.Kind,
+		fmt.Sprintf("%x:%x", handleMaj, handleMin),
+		fmt.Sprintf("%x:%x", parentMaj, parentMin),
+	}
+	offload := 0.0
+	if parms.offload {
+		offload = 1
+	}
+
+	ch <- prometheus.MustNewConstMetric(col.idleSlope, prometheus.GaugeValue, float64(parms.idleSlope), labels...)
+	ch <- prometheus.MustNewConstMetric(col.sendSlope, prometheus.GaugeValue, float64(parms.sendSlope), labels...)
+	ch <- prometheus.MustNewConstMetric(col.hiCredit, prometheus.GaugeValue, float64(parms.hiCredit), labels...)
+	ch <- prometheus.MustNewConstMetric(col.loCredit, prometheus.GaugeValue, float64(parms.loCredit), labels...)
+	ch <- prometheus.MustNewConstMetric(col.offload, prometheus.GaugeValue, offload, labels...)
+}
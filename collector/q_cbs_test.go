@@ -0,0 +1,102 @@
+package tccollector_test
+
+import (
+	"encoding/binary"
+	"log/slog"
+	"os"
+	"testing"
+
+	tcexporter "github.com/fbegyn/tc_exporter/collector"
+	"github.com/florianl/go-tc"
+	"github.com/florianl/go-tc/core"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/mdlayher/netlink"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// encodeCbsOptions encodes the TCA_OPTIONS of a CBS qdisc with a struct tc_cbs_qopt
+func encodeCbsOptions(t *testing.T, offload uint8, hiCredit, loCredit, idleSlope, sendSlope int32) []byte {
+	t.Helper()
+	p := make([]byte, 20)
+	p[0] = offload
+	binary.NativeEndian.PutUint32(p[4:], uint32(hiCredit))
+	binary.NativeEndian.PutUint32(p[8:], uint32(loCredit))
+	binary.NativeEndian.PutUint32(p[12:], uint32(idleSlope))
+	binary.NativeEndian.PutUint32(p[16:], uint32(sendSlope))
+	ae := netlink.NewAttributeEncoder()
+	ae.Bytes(1, p)
+	b, err := ae.Encode()
+	if err != nil {
+		t.Fatalf("failed to encode CBS options: %v", err)
+	}
+	return b
+}
+
+func TestCbsCollector(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	col, err := tcexporter.NewCbsCollector(nil, logger)
+	if err != nil {
+		t.Fatalf("failed to create CBS collector: %v", err)
+	}
+	interf := rtnetlink.LinkMessage{Index: 2, Attributes: &rtnetlink.LinkAttributes{Name: "eth0"}}
+
+	// a CBS qdisc on two queues of mqprio
+	dump := tcexporter.NewTestRawDump(2,
+		tcexporter.TestRawObject{Handle: core.BuildHandle(0x100, 0), Parent: core.BuildHandle(1, 1), Kind: "cbs", Options: encodeCbsOptions(t, 1, 153, -1389, 98688, -901312)},
+		tcexporter.TestRawObject{Handle: core.BuildHandle(0x200, 0), Parent: core.BuildHandle(1, 2), Kind: "cbs", Options: encodeCbsOptions(t, 0, 12, -97, 3648, -996352)},
+	)
+
+	tests := []struct {
+		name   string
+		handle string
+		parent string
+		want   map[string]float64
+	}{
+		{
+			name:   "offloaded",
+			handle: "100:0",
+			parent: "1:1",
+			want: map[string]float64{
+				"tc_cbs_idleslope_kbits": 98688,
+				"tc_cbs_sendslope_kbits": -901312,
+				"tc_cbs_hicredit_bytes":  153,
+				"tc_cbs_locredit_bytes":  -1389,
+				"tc_cbs_offload":         1,
+			},
+		},
+		{
+			name:   "software",
+			handle: "200:0",
+			parent: "1:2",
+			want: map[string]float64{
+				"tc_cbs_idleslope_kbits": 3648,
+				"tc_cbs_sendslope_kbits": -996352,
+				"tc_cbs_hicredit_bytes":  12,
+				"tc_cbs_locredit_bytes":  -97,
+				"tc_cbs_offload":         0,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handle, err := tcexporter.ParseHandle(tt.handle)
+			if err != nil {
+				t.Fatalf("failed to parse handle: %v", err)
+			}
+			parent, err := tcexporter.ParseHandle(tt.parent)
+			if err != nil {
+				t.Fatalf("failed to parse parent: %v", err)
+			}
+			qd := tc.Object{Msg: tc.Msg{Handle: handle, Parent: parent}, Attribute: tc.Attribute{Kind: "cbs"}}
+			metrics := collectMetrics(t, func(ch chan<- prometheus.Metric) {
+				dump.Collect(col, ch, "host", "default", interf, qd)
+			})
+			for name, want := range tt.want {
+				got, ok := findMetric(metrics, name, map[string]string{"handle": tt.handle, "parent": tt.parent})
+				if !ok || got != want {
+					t.Fatalf("unexpected %s: got %v (%t), want %v", name, got, ok, want)
+				}
+			}
+		})
+	}
+}
@@ -143,3 +143,14 @@ func (col *ChokeCollector) CollectObject(ch chan<- prometheus.Metric, host, ns s
 		fmt.Sprintf("%x:%x", parentMaj, parentMin),
 	)
 }
+
+// counters implements counterCollector
+func (col *ChokeCollector) counters() []*prometheus.Desc {
+	return []*prometheus.Desc{
+		col.early,
+		col.pDrop,
+		col.other,
+		col.marked,
+		col.matched,
+	}
+}
@@ -218,3 +218,8 @@ func (col *CodelCollector) CollectObject(ch chan<- prometheus.Metric, host, ns s
 		fmt.Sprintf("%x:%x", parentMaj, parentMin),
 	)
 }
+
+// counters implements counterCollector
+func (col *CodelCollector) counters() []*prometheus.Desc {
+	return []*prometheus.Desc{col.dropOverlimit, col.ecnMark, col.ceMark}
+}
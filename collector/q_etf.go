@@ -0,0 +1,149 @@
+package tccollector
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+
+	"github.com/florianl/go-tc"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	etfLabels []string = []string{"host", "netns", "linkindex", "link", "type", "handle", "parent"}
+)
+
+const (
+	tcaEtfParms = 1
+	// etfQoptLen is the size of struct tc_etf_qopt
+	etfQoptLen = 12
+
+	etfFlagDeadlineMode = 1 << 0
+	etfFlagOffload      = 1 << 1
+)
+
+// etfParms is the configuration of an ETF qdisc
+type etfParms struct {
+	delta   int32
+	clockID int32
+	flags   uint32
+}
+
+// parseEtfOptions decodes the TCA_OPTIONS of an ETF qdisc
+func parseEtfOptions(b []byte) (etfParms, error) {
+	attrs, err := parseAttrs(b)
+	if err != nil {
+		return etfParms{}, err
+	}
+	p := attrs[tcaEtfParms]
+	if len(p) < etfQoptLen {
+		return etfParms{}, fmt.Errorf("ETF parameters are too short: %d bytes", len(p))
+	}
+	return etfParms{
+		delta:   int32(binary.NativeEndian.Uint32(p[0:4])),
+		clockID: int32(binary.NativeEndian.Uint32(p[4:8])),
+		flags:   binary.NativeEndian.Uint32(p[8:12]),
+	}, nil
+}
+
+// EtfCollector is the object that will collect ETF qdisc data for the interface
+type EtfCollector struct {
+	logger slog.Logger
+	netns  map[string][]rtnetlink.LinkMessage
+
+	delta        *prometheus.Desc
+	clockID      *prometheus.Desc
+	deadlineMode *prometheus.Desc
+	offload      *prometheus.Desc
+}
+
+// NewEtfCollector create a new EtfCollector given a network interface
+func NewEtfCollector(netns map[string][]rtnetlink.LinkMessage, log *slog.Logger) (ObjectCollector, error) {
+	// Setup logger for etf collector
+	log = log.With("collector", "etf")
+	log.Info("making etf collector")
+
+	return &EtfCollector{
+		logger: *log,
+		netns:  netns,
+		delta: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "etf", "delta_seconds"),
+			"ETF time before the txtime of a packet at which it is dequeued",
+			etfLabels, nil,
+		),
+		clockID: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "etf", "clockid"),
+			"ETF clock the txtime of the packets refers to",
+			etfLabels, nil,
+		),
+		deadlineMode: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "etf", "deadline_mode"),
+			"Whether ETF runs in deadline mode",
+			etfLabels, nil,
+		),
+		offload: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "etf", "offload"),
+			"Whether the ETF launch time is offloaded to the hardware",
+			etfLabels, nil,
+		),
+	}, nil
+}
+
+// Describe implements Collector
+func (col *EtfCollector) Describe(ch chan<- *prometheus.Desc) {
+	ds := []*prometheus.Desc{
+		col.delta,
+		col.clockID,
+		col.deadlineMode,
+		col.offload,
+	}
+
+	for _, d := range ds {
+		ch <- d
+	}
+}
+
+// CollectObject fetches and updates the data the collector is exporting
+func (col *EtfCollector) CollectObject(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, qd tc.Object) {
+	col.collectRaw(ch, host, ns, interf, qd, newRawDump(ns, unix.RTM_GETQDISC, interf.Index))
+}
+
+// collectRaw exports the ETF qdisc from the dump of the interface
+func (col *EtfCollector) collectRaw(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, qd tc.Object, raws *rawDump) {
+	raw, err := raws.get(qd)
+	if err != nil {
+		col.logger.Error("failed to get ETF qdisc", "interface", interf.Attributes.Name, "err", err)
+		return
+	}
+	parms, err := parseEtfOptions(raw.Attrs[tcaOptions])
+	if err != nil {
+		col.logger.Error("failed to parse ETF options", "interface", interf.Attributes.Name, "err", err)
+		return
+	}
+
+	handleMaj, handleMin := HandleStr(qd.Handle)
+	parentMaj, parentMin := HandleStr(qd.Parent)
+	labels := []string{
+		host,
+		ns,
+		fmt.Sprintf("%d", interf.Index),
+		interf.Attributes.Name,
+		qd.Kind,
+		fmt.Sprintf("%x:%x", handleMaj, handleMin),
+		fmt.Sprintf("%x:%x", parentMaj, parentMin),
+	}
+	flag := func(f uint32) float64 {
+		if parms.flags&f != 0 {
+			return 1
+		}
+		return 0
+	}
+
+	// the kernel reports the delta in nanoseconds
+	ch <- prometheus.MustNewConstMetric(col.delta, prometheus.GaugeValue, float64(parms.delta)/1e9, labels...)
+	ch <- prometheus.MustNewConstMetric(col.clockID, prometheus.GaugeValue, float64(parms.clockID), labels...)
+	ch <- prometheus.MustNewConstMetric(col.deadlineMode, prometheus.GaugeValue, flag(etfFlagDeadlineMode), labels...)
+	ch <- prometheus.MustNewConstMetric(col.offload, prometheus.GaugeValue, flag(etfFlagOffload), labels...)
+}
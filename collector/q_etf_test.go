@@ -0,0 +1,108 @@
+package tccollector_test
+
+import (
+	"encoding/binary"
+	"log/slog"
+	"os"
+	"testing"
+
+	tcexporter "github.com/fbegyn/tc_exporter/collector"
+	"github.com/florianl/go-tc"
+	"github.com/florianl/go-tc/core"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/mdlayher/netlink"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// encodeEtfOptions encodes the TCA_OPTIONS of an ETF qdisc with a struct tc_etf_qopt
+func encodeEtfOptions(t *testing.T, delta, clockID int32, flags uint32) []byte {
+	t.Helper()
+	p := make([]byte, 12)
+	binary.NativeEndian.PutUint32(p[0:], uint32(delta))
+	binary.NativeEndian.PutUint32(p[4:], uint32(clockID))
+	binary.NativeEndian.PutUint32(p[8:], flags)
+	ae := netlink.NewAttributeEncoder()
+	ae.Bytes(1, p)
+	b, err := ae.Encode()
+	if err != nil {
+		t.Fatalf("failed to encode ETF options: %v", err)
+	}
+	return b
+}
+
+func TestEtfCollector(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	col, err := tcexporter.NewEtfCollector(nil, logger)
+	if err != nil {
+		t.Fatalf("failed to create ETF collector: %v", err)
+	}
+	interf := rtnetlink.LinkMessage{Index: 2, Attributes: &rtnetlink.LinkAttributes{Name: "eth0"}}
+
+	// CLOCK_TAI is clockid 11
+	dump := tcexporter.NewTestRawDump(2,
+		tcexporter.TestRawObject{Handle: core.BuildHandle(0x100, 0), Parent: core.BuildHandle(1, 1), Kind: "etf", Options: encodeEtfOptions(t, 300000, 11, 1<<0|1<<1)},
+		tcexporter.TestRawObject{Handle: core.BuildHandle(0x200, 0), Parent: core.BuildHandle(1, 2), Kind: "etf", Options: encodeEtfOptions(t, 200000, 11, 0)},
+		tcexporter.TestRawObject{Handle: core.BuildHandle(0x300, 0), Parent: core.BuildHandle(1, 3), Kind: "etf", Options: []byte{}},
+	)
+
+	tests := []struct {
+		name   string
+		handle string
+		parent string
+		want   map[string]float64
+	}{
+		{
+			name:   "deadline mode offloaded",
+			handle: "100:0",
+			parent: "1:1",
+			want: map[string]float64{
+				"tc_etf_delta_seconds": 0.0003,
+				"tc_etf_clockid":       11,
+				"tc_etf_deadline_mode": 1,
+				"tc_etf_offload":       1,
+			},
+		},
+		{
+			name:   "strict",
+			handle: "200:0",
+			parent: "1:2",
+			want: map[string]float64{
+				"tc_etf_delta_seconds": 0.0002,
+				"tc_etf_clockid":       11,
+				"tc_etf_deadline_mode": 0,
+				"tc_etf_offload":       0,
+			},
+		},
+		{
+			// the parameters are missing, nothing is exported
+			name:   "no parameters",
+			handle: "300:0",
+			parent: "1:3",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handle, err := tcexporter.ParseHandle(tt.handle)
+			if err != nil {
+				t.Fatalf("failed to parse handle: %v", err)
+			}
+			parent, err := tcexporter.ParseHandle(tt.parent)
+			if err != nil {
+				t.Fatalf("failed to parse parent: %v", err)
+			}
+			qd := tc.Object{Msg: tc.Msg{Handle: handle, Parent: parent}, Attribute: tc.Attribute{Kind: "etf"}}
+			metrics := collectMetrics(t, func(ch chan<- prometheus.Metric) {
+				dump.Collect(col, ch, "host", "default", interf, qd)
+			})
+			if len(metrics) != len(tt.want) {
+				t.Fatalf("unexpected number of metrics: got %d, want %d", len(metrics), len(tt.want))
+			}
+			for name, want := range tt.want {
+				got, ok := findMetric(metrics, name, map[string]string{"handle": tt.handle, "parent": tt.parent})
+				if !ok || got != want {
+					t.Fatalf("unexpected %s: got %v (%t), want %v", name, got, ok, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package tccollector
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+
+	"github.com/florianl/go-tc"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	fifoLabels    []string = []string{"host", "netns", "linkindex", "link", "type", "handle", "parent"}
+	priomapLabels []string = []string{"host", "netns", "linkindex", "link", "type", "handle", "parent", "priority"}
+)
+
+// prioQoptLen is the size of struct tc_prio_qopt, the options of pfifo_fast
+const prioQoptLen = 20
+
+// fifoParms is the configuration of a FIFO qdisc
+type fifoParms struct {
+	// limit is in bytes for bfifo and in packets for the others
+	limit   uint32
+	bands   uint32
+	priomap []uint8
+}
+
+// parseFifoOptions decodes the TCA_OPTIONS of a FIFO qdisc. Unlike most qdiscs, the options are
+// a plain struct and not nested attributes. pfifo_fast has no limit of its own, it queues up to
+// the txqueuelen of the link.
+func parseFifoOptions(kind string, b []byte, txQueueLen uint32) (fifoParms, error) {
+	switch kind {
+	case "pfifo", "bfifo", "pfifo_head_drop":
+		if len(b) < 4 {
+			return fifoParms{}, fmt.Errorf("%s options are too short: %d bytes", kind, len(b))
+		}
+		return fifoParms{limit: binary.NativeEndian.Uint32(b[0:4])}, nil
+	case "pfifo_fast":
+		if len(b) < prioQoptLen {
+			return fifoParms{}, fmt.Errorf("%s options are too short: %d bytes", kind, len(b))
+		}
+		return fifoParms{
+			limit:   txQueueLen,
+			bands:   binary.NativeEndian.Uint32(b[0:4]),
+			priomap: b[4:prioQoptLen],
+		}, nil
+	}
+	return fifoParms{}, fmt.Errorf("%s is not a fifo qdisc", kind)
+}
+
+// FifoCollector is the object that will collect the data of the FIFO qdiscs (pfifo, bfifo,
+// pfifo_head_drop and pfifo_fast) for the interface. noqueue has nothing to export, but is
+// handled so it is not reported as unsupported.
+type FifoCollector struct {
+	logger slog.Logger
+	netns  map[string][]rtnetlink.LinkMessage
+
+	limitPackets *prometheus.Desc
+	limitBytes   *prometheus.Desc
+	utilization  *prometheus.Desc
+	bands        *prometheus.Desc
+	priomap      *prometheus.Desc
+}
+
+// NewFifoCollector create a new FifoCollector given a network interface
+func NewFifoCollector(netns map[string][]rtnetlink.LinkMessage, log *slog.Logger) (ObjectCollector, error) {
+	// Setup logger for fifo collector
+	log = log.With("collector", "fifo")
+	log.Info("making fifo collector")
+
+	return &FifoCollector{
+		logger: *log,
+		netns:  netns,
+		limitPackets: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "fifo", "limit_packets"),
+			"FIFO queue limit in packets",
+			fifoLabels, nil,
+		),
+		limitBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "fifo", "limit_bytes"),
+			"FIFO queue limit in bytes",
+			fifoLabels, nil,
+		),
+		utilization: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "qdisc", "limit_utilization_ratio"),
+			"Current queue length (backlog for bfifo) relative to the limit of the qdisc",
+			fifoLabels, nil,
+		),
+		bands: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pfifo_fast", "bands"),
+			"Number of bands of the pfifo_fast qdisc",
+			fifoLabels, nil,
+		),
+		priomap: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pfifo_fast", "priomap_band"),
+			"Band the packets of the priority are queued in",
+			priomapLabels, nil,
+		),
+	}, nil
+}
+
+// Describe implements Collector
+func (col *FifoCollector) Describe(ch chan<- *prometheus.Desc) {
+	ds := []*prometheus.Desc{
+		col.limitPackets,
+		col.limitBytes,
+		col.utilization,
+		col.bands,
+		col.priomap,
+	}
+
+	for _, d := range ds {
+		ch <- d
+	}
+}
+
+// CollectObject fetches and updates the data the collector is exporting
+func (col *FifoCollector) CollectObject(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, qd tc.Object) {
+	col.collectRaw(ch, host, ns, interf, qd, newRawDump(ns, unix.RTM_GETQDISC, interf.Index))
+}
+
+// collectRaw exports the FIFO qdisc from the dump of the interface
+func (col *FifoCollector) collectRaw(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, qd tc.Object, raws *rawDump) {
+	if qd.Kind == "noqueue" {
+		return
+	}
+	raw, err := raws.get(qd)
+	if err != nil {
+		col.logger.Error("failed to get fifo qdisc", "interface", interf.Attributes.Name, "err", err)
+		return
+	}
+	var txQueueLen uint32
+	if interf.Attributes.TxQueueLen != nil {
+		txQueueLen = *interf.Attributes.TxQueueLen
+	}
+	parms, err := parseFifoOptions(qd.Kind, raw.Attrs[tcaOptions], txQueueLen)
+	if err != nil {
+		col.logger.Error("failed to parse fifo options", "interface", interf.Attributes.Name, "err", err)
+		return
+	}
+
+	handleMaj, handleMin := HandleStr(qd.Handle)
+	parentMaj, parentMin := HandleStr(qd.Parent)
+	labels := []string{
+		host,
+		ns,
+		fmt.Sprintf("%d", interf.Index),
+		interf.Attributes.Name,
+		qd.Kind,
+		fmt.Sprintf("%x:%x", handleMaj, handleMin),
+		fmt.Sprintf("%x:%x", parentMaj, parentMin),
+	}
+
+	limit := col.limitPackets
+	if qd.Kind == "bfifo" {
+		limit = col.limitBytes
+	}
+	ch <- prometheus.MustNewConstMetric(limit, prometheus.GaugeValue, float64(parms.limit), labels...)
+	if s, ok := readStats(qd); ok && parms.limit > 0 {
+		used := s.qlen
+		if qd.Kind == "bfifo" {
+			used = s.backlog
+		}
+		ch <- prometheus.MustNewConstMetric(col.utilization, prometheus.GaugeValue, used/float64(parms.limit), labels...)
+	}
+
+	if qd.Kind != "pfifo_fast" {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(col.bands, prometheus.GaugeValue, float64(parms.bands), labels...)
+	for prio, band := range parms.priomap {
+		ch <- prometheus.MustNewConstMetric(
+			col.priomap,
+			prometheus.GaugeValue,
+			float64(band),
+			append(labels, fmt.Sprintf("%d", prio))...,
+		)
+	}
+}
@@ -0,0 +1,153 @@
+package tccollector_test
+
+import (
+	"encoding/binary"
+	"log/slog"
+	"os"
+	"testing"
+
+	tcexporter "github.com/fbegyn/tc_exporter/collector"
+	"github.com/florianl/go-tc"
+	"github.com/florianl/go-tc/core"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// encodeFifoLimit encodes the struct tc_fifo_qopt options of pfifo, bfifo and pfifo_head_drop
+func encodeFifoLimit(limit uint32) []byte {
+	b := make([]byte, 4)
+	binary.NativeEndian.PutUint32(b, limit)
+	return b
+}
+
+// encodePrioOptions encodes the struct tc_prio_qopt options of pfifo_fast
+func encodePrioOptions(bands uint32, priomap [16]uint8) []byte {
+	b := make([]byte, 20)
+	binary.NativeEndian.PutUint32(b, bands)
+	copy(b[4:], priomap[:])
+	return b
+}
+
+func TestFifoCollector(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	col, err := tcexporter.NewFifoCollector(nil, logger)
+	if err != nil {
+		t.Fatalf("failed to create fifo collector: %v", err)
+	}
+	txQueueLen := uint32(1000)
+	interf := rtnetlink.LinkMessage{Index: 2, Attributes: &rtnetlink.LinkAttributes{Name: "eth0", TxQueueLen: &txQueueLen}}
+
+	// the default pfifo_fast children of mq all have handle 0:, only their parents and, in this
+	// test, their priomaps differ
+	defaultMap := [16]uint8{1, 2, 2, 2, 1, 2, 0, 0, 1, 1, 1, 1, 1, 1, 1, 1}
+	otherMap := [16]uint8{2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 0}
+	dump := tcexporter.NewTestRawDump(2,
+		tcexporter.TestRawObject{Parent: core.BuildHandle(1, 1), Kind: "pfifo_fast", Options: encodePrioOptions(3, defaultMap)},
+		tcexporter.TestRawObject{Parent: core.BuildHandle(1, 2), Kind: "pfifo_fast", Options: encodePrioOptions(3, otherMap)},
+		tcexporter.TestRawObject{Handle: core.BuildHandle(2, 0), Parent: tc.HandleRoot, Kind: "pfifo", Options: encodeFifoLimit(100)},
+		tcexporter.TestRawObject{Handle: core.BuildHandle(3, 0), Parent: tc.HandleRoot, Kind: "bfifo", Options: encodeFifoLimit(30000)},
+		tcexporter.TestRawObject{Handle: core.BuildHandle(4, 0), Parent: tc.HandleRoot, Kind: "pfifo_head_drop", Options: []byte{1, 2}},
+	)
+
+	tests := []struct {
+		name    string
+		kind    string
+		handle  string
+		parent  string
+		stats   *tc.Stats2
+		want    map[string]float64
+		priomap map[string]float64
+		metrics int
+	}{
+		{
+			name:   "first mq child",
+			kind:   "pfifo_fast",
+			handle: "0:0",
+			parent: "1:1",
+			stats:  &tc.Stats2{Qlen: 250},
+			want: map[string]float64{
+				"tc_fifo_limit_packets":            1000,
+				"tc_qdisc_limit_utilization_ratio": 0.25,
+				"tc_pfifo_fast_bands":              3,
+			},
+			priomap: map[string]float64{"0": 1, "6": 0, "15": 1},
+			metrics: 19,
+		},
+		{
+			name:   "second mq child",
+			kind:   "pfifo_fast",
+			handle: "0:0",
+			parent: "1:2",
+			want: map[string]float64{
+				"tc_fifo_limit_packets": 1000,
+				"tc_pfifo_fast_bands":   3,
+			},
+			priomap: map[string]float64{"0": 2, "6": 2, "15": 0},
+			metrics: 18,
+		},
+		{
+			name:    "pfifo",
+			kind:    "pfifo",
+			handle:  "2:0",
+			parent:  "ffff:ffff",
+			stats:   &tc.Stats2{Qlen: 10, Backlog: 15000},
+			want:    map[string]float64{"tc_fifo_limit_packets": 100, "tc_qdisc_limit_utilization_ratio": 0.1},
+			metrics: 2,
+		},
+		{
+			// the limit of bfifo is in bytes, so the utilization is based on the backlog
+			name:    "bfifo",
+			kind:    "bfifo",
+			handle:  "3:0",
+			parent:  "ffff:ffff",
+			stats:   &tc.Stats2{Qlen: 10, Backlog: 15000},
+			want:    map[string]float64{"tc_fifo_limit_bytes": 30000, "tc_qdisc_limit_utilization_ratio": 0.5},
+			metrics: 2,
+		},
+		{
+			name:    "options too short",
+			kind:    "pfifo_head_drop",
+			handle:  "4:0",
+			parent:  "ffff:ffff",
+			metrics: 0,
+		},
+		{
+			name:    "noqueue",
+			kind:    "noqueue",
+			handle:  "0:0",
+			parent:  "ffff:ffff",
+			metrics: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handle, err := tcexporter.ParseHandle(tt.handle)
+			if err != nil {
+				t.Fatalf("failed to parse handle: %v", err)
+			}
+			parent, err := tcexporter.ParseHandle(tt.parent)
+			if err != nil {
+				t.Fatalf("failed to parse parent: %v", err)
+			}
+			qd := tc.Object{Msg: tc.Msg{Handle: handle, Parent: parent}, Attribute: tc.Attribute{Kind: tt.kind, Stats2: tt.stats}}
+			metrics := collectMetrics(t, func(ch chan<- prometheus.Metric) {
+				dump.Collect(col, ch, "host", "default", interf, qd)
+			})
+			if len(metrics) != tt.metrics {
+				t.Fatalf("unexpected number of metrics: got %d, want %d", len(metrics), tt.metrics)
+			}
+			for name, want := range tt.want {
+				got, ok := findMetric(metrics, name, map[string]string{"handle": tt.handle, "parent": tt.parent})
+				if !ok || got != want {
+					t.Fatalf("unexpected %s: got %v (%t), want %v", name, got, ok, want)
+				}
+			}
+			for prio, want := range tt.priomap {
+				got, ok := findMetric(metrics, "tc_pfifo_fast_priomap_band", map[string]string{"parent": tt.parent, "priority": prio})
+				if !ok || got != want {
+					t.Fatalf("unexpected band of priority %s: got %v (%t), want %v", prio, got, ok, want)
+				}
+			}
+		})
+	}
+}
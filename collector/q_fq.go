@@ -340,3 +340,18 @@ func (col *FqCollector) CollectObject(ch chan<- prometheus.Metric, host, ns stri
 		fmt.Sprintf("%x:%x", parentMaj, parentMin),
 	)
 }
+
+// counters implements counterCollector
+func (col *FqCollector) counters() []*prometheus.Desc {
+	return []*prometheus.Desc{
+		col.gcFlows,
+		col.highPrioPackets,
+		col.flowsPlimit,
+		col.pktsTooLong,
+		col.allocationErrors,
+		col.ceMark,
+		col.horizonDrops,
+		col.horizonCaps,
+		col.fastpathPackets,
+	}
+}
@@ -218,3 +218,14 @@ func (col *FqCodelQdiscCollector) CollectObject(ch chan<- prometheus.Metric, hos
 		fmt.Sprintf("%x:%x", parentMaj, parentMin),
 	)
 }
+
+// counters implements counterCollector
+func (col *FqCodelQdiscCollector) counters() []*prometheus.Desc {
+	return []*prometheus.Desc{
+		col.dropOverlimit,
+		col.dropOvermemory,
+		col.ecnMark,
+		col.ceMark,
+		col.newFlowCount,
+	}
+}
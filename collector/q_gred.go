@@ -0,0 +1,353 @@
+package tccollector
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/florianl/go-tc"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	gredLabels []string = []string{"host", "netns", "linkindex", "link", "type", "handle", "parent", "dp"}
+)
+
+// GRED netlink attributes, go-tc does not decode the GRED options so they are read from the raw
+// messages
+const (
+	tcaGredParms  = 1
+	tcaGredMaxP   = 4
+	tcaGredVqList = 6
+
+	tcaGredVqEntry = 1
+
+	tcaGredVqDP         = 2
+	tcaGredVqStatBytes  = 3
+	tcaGredVqStatPkts   = 4
+	tcaGredVqStatBklog  = 5
+	tcaGredVqProbDrop   = 6
+	tcaGredVqProbMark   = 7
+	tcaGredVqForcedDrop = 8
+	tcaGredVqForcedMark = 9
+	tcaGredVqPDrop      = 10
+	tcaGredVqOther      = 11
+
+	// gredMaxDPs is the number of virtual queues a GRED qdisc can have
+	gredMaxDPs = 16
+	// gredQoptLen is the size of struct tc_gred_qopt
+	gredQoptLen = 52
+)
+
+// gredVQ are the parameters and stats of a GRED virtual queue
+type gredVQ struct {
+	dp         uint32
+	limit      uint32
+	qthMin     uint32
+	qthMax     uint32
+	maxP       uint32
+	prio       uint8
+	backlog    uint32
+	qave       uint32
+	early      uint32
+	pdrop      uint32
+	other      uint32
+	forced     uint32
+	probMark   uint32
+	forcedMark uint32
+	packets    uint32
+	bytes      uint64
+}
+
+// parseGredOptions decodes the TCA_OPTIONS of a GRED qdisc into its configured virtual queues
+func parseGredOptions(b []byte) ([]gredVQ, error) {
+	attrs, err := parseAttrs(b)
+	if err != nil {
+		return nil, err
+	}
+	parms := attrs[tcaGredParms]
+	if len(parms) < gredMaxDPs*gredQoptLen {
+		return nil, fmt.Errorf("GRED parameters are too short: %d bytes", len(parms))
+	}
+
+	vqs := make(map[uint32]*gredVQ)
+	for i := range gredMaxDPs {
+		q := parms[i*gredQoptLen : (i+1)*gredQoptLen]
+		vq := gredVQ{
+			limit:   binary.NativeEndian.Uint32(q[0:4]),
+			qthMin:  binary.NativeEndian.Uint32(q[4:8]),
+			qthMax:  binary.NativeEndian.Uint32(q[8:12]),
+			dp:      binary.NativeEndian.Uint32(q[12:16]),
+			backlog: binary.NativeEndian.Uint32(q[16:20]),
+			qave:    binary.NativeEndian.Uint32(q[20:24]),
+			forced:  binary.NativeEndian.Uint32(q[24:28]),
+			early:   binary.NativeEndian.Uint32(q[28:32]),
+			other:   binary.NativeEndian.Uint32(q[32:36]),
+			pdrop:   binary.NativeEndian.Uint32(q[36:40]),
+			prio:    q[43],
+			packets: binary.NativeEndian.Uint32(q[44:48]),
+			bytes:   uint64(binary.NativeEndian.Uint32(q[48:52])),
+		}
+		// the kernel marks the unused virtual queues with a DP out of range
+		if vq.dp >= gredMaxDPs {
+			continue
+		}
+		if maxP := attrs[tcaGredMaxP]; len(maxP) >= (i+1)*4 {
+			vq.maxP = binary.NativeEndian.Uint32(maxP[i*4 : (i+1)*4])
+		}
+		vqs[vq.dp] = &vq
+	}
+
+	// newer kernels send the stats of the virtual queues in a separate list, with 64 bit byte
+	// counters and the mark counters
+	if list, ok := attrs[tcaGredVqList]; ok {
+		entries, err := parseAttrList(list)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.Type != tcaGredVqEntry {
+				continue
+			}
+			ea, err := parseAttrs(entry.Data)
+			if err != nil {
+				return nil, err
+			}
+			dp, ok := ea[tcaGredVqDP]
+			if !ok || len(dp) < 4 {
+				continue
+			}
+			vq, found := vqs[binary.NativeEndian.Uint32(dp)]
+			if !found {
+				continue
+			}
+			u32 := func(typ uint16, v *uint32) {
+				if b, ok := ea[typ]; ok && len(b) >= 4 {
+					*v = binary.NativeEndian.Uint32(b)
+				}
+			}
+			if b, ok := ea[tcaGredVqStatBytes]; ok && len(b) >= 8 {
+				vq.bytes = binary.NativeEndian.Uint64(b)
+			}
+			u32(tcaGredVqStatPkts, &vq.packets)
+			u32(tcaGredVqStatBklog, &vq.backlog)
+			u32(tcaGredVqProbDrop, &vq.early)
+			u32(tcaGredVqProbMark, &vq.probMark)
+			u32(tcaGredVqForcedDrop, &vq.forced)
+			u32(tcaGredVqForcedMark, &vq.forcedMark)
+			u32(tcaGredVqPDrop, &vq.pdrop)
+			u32(tcaGredVqOther, &vq.other)
+		}
+	}
+
+	out := make([]gredVQ, 0, len(vqs))
+	for _, vq := range vqs {
+		out = append(out, *vq)
+	}
+	slices.SortFunc(out, func(a, b gredVQ) int {
+		return int(a.dp) - int(b.dp)
+	})
+	return out, nil
+}
+
+// GredCollector is the object that will collect GRED qdisc data for the interface
+type GredCollector struct {
+	logger slog.Logger
+	netns  map[string][]rtnetlink.LinkMessage
+
+	limit      *prometheus.Desc
+	qthMin     *prometheus.Desc
+	qthMax     *prometheus.Desc
+	maxP       *prometheus.Desc
+	prio       *prometheus.Desc
+	backlog    *prometheus.Desc
+	qave       *prometheus.Desc
+	early      *prometheus.Desc
+	pDrop      *prometheus.Desc
+	other      *prometheus.Desc
+	forced     *prometheus.Desc
+	probMark   *prometheus.Desc
+	forcedMark *prometheus.Desc
+	packets    *prometheus.Desc
+	bytes      *prometheus.Desc
+}
+
+// NewGredCollector create a new GredCollector given a network interface
+func NewGredCollector(netns map[string][]rtnetlink.LinkMessage, log *slog.Logger) (ObjectCollector, error) {
+	// Setup logger for gred collector
+	log = log.With("collector", "gred")
+	log.Info("making gred collector")
+
+	return &GredCollector{
+		logger: *log,
+		netns:  netns,
+		limit: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "gred", "limit_bytes"),
+			"GRED virtual queue limit",
+			gredLabels, nil,
+		),
+		qthMin: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "gred", "min_threshold_bytes"),
+			"GRED virtual queue minimum threshold",
+			gredLabels, nil,
+		),
+		qthMax: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "gred", "max_threshold_bytes"),
+			"GRED virtual queue maximum threshold",
+			gredLabels, nil,
+		),
+		maxP: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "gred", "max_probability"),
+			"GRED virtual queue drop probability at the maximum threshold",
+			gredLabels, nil,
+		),
+		prio: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "gred", "prio"),
+			"GRED virtual queue priority",
+			gredLabels, nil,
+		),
+		backlog: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "gred", "backlog_bytes"),
+			"GRED virtual queue backlog",
+			gredLabels, nil,
+		),
+		qave: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "gred", "average_queue_bytes"),
+			"GRED virtual queue average queue size",
+			gredLabels, nil,
+		),
+		early: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "gred", "early"),
+			"GRED virtual queue early drops",
+			gredLabels, nil,
+		),
+		pDrop: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "gred", "pdrop"),
+			"GRED virtual queue drops because the limit was reached",
+			gredLabels, nil,
+		),
+		other: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "gred", "other"),
+			"GRED virtual queue other drops",
+			gredLabels, nil,
+		),
+		forced: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "gred", "forced"),
+			"GRED virtual queue forced drops above the maximum threshold",
+			gredLabels, nil,
+		),
+		probMark: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "gred", "prob_mark"),
+			"GRED virtual queue probabilistic ECN marks",
+			gredLabels, nil,
+		),
+		forcedMark: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "gred", "forced_mark"),
+			"GRED virtual queue forced ECN marks above the maximum threshold",
+			gredLabels, nil,
+		),
+		packets: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "gred", "packets"),
+			"GRED virtual queue packets",
+			gredLabels, nil,
+		),
+		bytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "gred", "bytes"),
+			"GRED virtual queue bytes",
+			gredLabels, nil,
+		),
+	}, nil
+}
+
+// Describe implements Collector
+func (col *GredCollector) Describe(ch chan<- *prometheus.Desc) {
+	ds := []*prometheus.Desc{
+		col.limit,
+		col.qthMin,
+		col.qthMax,
+		col.maxP,
+		col.prio,
+		col.backlog,
+		col.qave,
+		col.early,
+		col.pDrop,
+		col.other,
+		col.forced,
+		col.probMark,
+		col.forcedMark,
+		col.packets,
+		col.bytes,
+	}
+
+	for _, d := range ds {
+		ch <- d
+	}
+}
+
+// CollectObject fetches and updates the data the collector is exporting
+func (col *GredCollector) CollectObject(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, qd tc.Object) {
+	col.collectRaw(ch, host, ns, interf, qd, newRawDump(ns, unix.RTM_GETQDISC, interf.Index))
+}
+
+// collectRaw exports the GRED qdisc from the dump of the interface
+func (col *GredCollector) collectRaw(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, qd tc.Object, raws *rawDump) {
+	raw, err := raws.get(qd)
+	if err != nil {
+		col.logger.Error("failed to get GRED qdisc", "interface", interf.Attributes.Name, "err", err)
+		return
+	}
+	vqs, err := parseGredOptions(raw.Attrs[tcaOptions])
+	if err != nil {
+		col.logger.Error("failed to parse GRED options", "interface", interf.Attributes.Name, "err", err)
+		return
+	}
+
+	handleMaj, handleMin := HandleStr(qd.Handle)
+	parentMaj, parentMin := HandleStr(qd.Parent)
+	for _, vq := range vqs {
+		labels := []string{
+			host,
+			ns,
+			fmt.Sprintf("%d", interf.Index),
+			interf.Attributes.Name,
+			qd.Kind,
+			fmt.Sprintf("%x:%x", handleMaj, handleMin),
+			fmt.Sprintf("%x:%x", parentMaj, parentMin),
+			fmt.Sprintf("%d", vq.dp),
+		}
+
+		ch <- prometheus.MustNewConstMetric(col.limit, prometheus.GaugeValue, float64(vq.limit), labels...)
+		ch <- prometheus.MustNewConstMetric(col.qthMin, prometheus.GaugeValue, float64(vq.qthMin), labels...)
+		ch <- prometheus.MustNewConstMetric(col.qthMax, prometheus.GaugeValue, float64(vq.qthMax), labels...)
+		// the kernel scales the probability to 2^32
+		ch <- prometheus.MustNewConstMetric(col.maxP, prometheus.GaugeValue, float64(vq.maxP)/(1<<32), labels...)
+		ch <- prometheus.MustNewConstMetric(col.prio, prometheus.GaugeValue, float64(vq.prio), labels...)
+		ch <- prometheus.MustNewConstMetric(col.backlog, prometheus.GaugeValue, float64(vq.backlog), labels...)
+		ch <- prometheus.MustNewConstMetric(col.qave, prometheus.GaugeValue, float64(vq.qave), labels...)
+		ch <- prometheus.MustNewConstMetric(col.early, prometheus.CounterValue, float64(vq.early), labels...)
+		ch <- prometheus.MustNewConstMetric(col.pDrop, prometheus.CounterValue, float64(vq.pdrop), labels...)
+		ch <- prometheus.MustNewConstMetric(col.other, prometheus.CounterValue, float64(vq.other), labels...)
+		ch <- prometheus.MustNewConstMetric(col.forced, prometheus.CounterValue, float64(vq.forced), labels...)
+		ch <- prometheus.MustNewConstMetric(col.probMark, prometheus.CounterValue, float64(vq.probMark), labels...)
+		ch <- prometheus.MustNewConstMetric(col.forcedMark, prometheus.CounterValue, float64(vq.forcedMark), labels...)
+		ch <- prometheus.MustNewConstMetric(col.packets, prometheus.CounterValue, float64(vq.packets), labels...)
+		ch <- prometheus.MustNewConstMetric(col.bytes, prometheus.CounterValue, float64(vq.bytes), labels...)
+	}
+}
+
+// counters implements counterCollector
+func (col *GredCollector) counters() []*prometheus.Desc {
+	return []*prometheus.Desc{
+		col.early,
+		col.pDrop,
+		col.other,
+		col.forced,
+		col.probMark,
+		col.forcedMark,
+		col.packets,
+		col.bytes,
+	}
+}
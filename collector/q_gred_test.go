@@ -0,0 +1,131 @@
+package tccollector_test
+
+import (
+	"encoding/binary"
+	"log/slog"
+	"os"
+	"testing"
+
+	tcexporter "github.com/fbegyn/tc_exporter/collector"
+	"github.com/florianl/go-tc"
+	"github.com/florianl/go-tc/core"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/mdlayher/netlink"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// gredVQ are the fields of a struct tc_gred_qopt that are set in the tests
+type gredVQ struct {
+	limit, qthMin, qthMax, dp, backlog, early, packets, bytes uint32
+	prio                                                      uint8
+}
+
+// encodeGredOptions encodes the TCA_OPTIONS of a GRED qdisc with the virtual queues in the
+// legacy parameters and the 64 bit bytes and mark counters of the stats list
+func encodeGredOptions(t *testing.T, vqs []gredVQ, maxP map[uint32]uint32, statBytes map[uint32]uint64, probMark map[uint32]uint32) []byte {
+	t.Helper()
+	parms := make([]byte, 16*52)
+	maxPs := make([]byte, 16*4)
+	for i := range 16 {
+		// the unused virtual queues have a DP out of range
+		binary.NativeEndian.PutUint32(parms[i*52+12:], uint32(16+i))
+	}
+	for i, vq := range vqs {
+		q := parms[i*52 : (i+1)*52]
+		binary.NativeEndian.PutUint32(q[0:], vq.limit)
+		binary.NativeEndian.PutUint32(q[4:], vq.qthMin)
+		binary.NativeEndian.PutUint32(q[8:], vq.qthMax)
+		binary.NativeEndian.PutUint32(q[12:], vq.dp)
+		binary.NativeEndian.PutUint32(q[16:], vq.backlog)
+		binary.NativeEndian.PutUint32(q[28:], vq.early)
+		q[43] = vq.prio
+		binary.NativeEndian.PutUint32(q[44:], vq.packets)
+		binary.NativeEndian.PutUint32(q[48:], vq.bytes)
+		binary.NativeEndian.PutUint32(maxPs[i*4:], maxP[vq.dp])
+	}
+
+	ae := netlink.NewAttributeEncoder()
+	ae.Bytes(1, parms)
+	ae.Bytes(4, maxPs)
+	ae.Nested(6, func(list *netlink.AttributeEncoder) error {
+		for dp, b := range statBytes {
+			list.Nested(1, func(entry *netlink.AttributeEncoder) error {
+				entry.Uint32(2, dp)
+				entry.Uint64(3, b)
+				entry.Uint32(7, probMark[dp])
+				return nil
+			})
+		}
+		return nil
+	})
+	b, err := ae.Encode()
+	if err != nil {
+		t.Fatalf("failed to encode GRED options: %v", err)
+	}
+	return b
+}
+
+func TestGredCollector(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	col, err := tcexporter.NewGredCollector(nil, logger)
+	if err != nil {
+		t.Fatalf("failed to create GRED collector: %v", err)
+	}
+	interf := rtnetlink.LinkMessage{Index: 2, Attributes: &rtnetlink.LinkAttributes{Name: "eth0"}}
+
+	opts := encodeGredOptions(t,
+		[]gredVQ{
+			{limit: 60000, qthMin: 10000, qthMax: 30000, dp: 0, backlog: 1500, early: 3, packets: 100, bytes: 150000, prio: 1},
+			{limit: 90000, qthMin: 20000, qthMax: 60000, dp: 2, packets: 7, bytes: 9000, prio: 4},
+		},
+		map[uint32]uint32{0: 1 << 31, 2: 1 << 30},
+		// the stats list overrides the 32 bit byte counter of the legacy parameters
+		map[uint32]uint64{2: 1 << 33},
+		map[uint32]uint32{2: 5},
+	)
+	handle := core.BuildHandle(1, 0)
+	dump := tcexporter.NewTestRawDump(2, tcexporter.TestRawObject{Handle: handle, Parent: tc.HandleRoot, Kind: "gred", Options: opts})
+	qd := tc.Object{Msg: tc.Msg{Handle: handle, Parent: tc.HandleRoot}, Attribute: tc.Attribute{Kind: "gred"}}
+	metrics := collectMetrics(t, func(ch chan<- prometheus.Metric) {
+		dump.Collect(col, ch, "host", "default", interf, qd)
+	})
+
+	if _, ok := findMetric(metrics, "tc_gred_limit_bytes", map[string]string{"dp": "1"}); ok {
+		t.Fatalf("unused virtual queue was exported")
+	}
+	tests := []struct {
+		name   string
+		dp     string
+		metric string
+		want   float64
+	}{
+		{"limit", "0", "tc_gred_limit_bytes", 60000},
+		{"min threshold", "0", "tc_gred_min_threshold_bytes", 10000},
+		{"max threshold", "2", "tc_gred_max_threshold_bytes", 60000},
+		{"max probability", "0", "tc_gred_max_probability", 0.5},
+		{"prio", "2", "tc_gred_prio", 4},
+		{"backlog", "0", "tc_gred_backlog_bytes", 1500},
+		{"early", "0", "tc_gred_early", 3},
+		{"legacy bytes", "0", "tc_gred_bytes", 150000},
+		{"list bytes", "2", "tc_gred_bytes", 1 << 33},
+		{"list prob mark", "2", "tc_gred_prob_mark", 5},
+		{"packets", "2", "tc_gred_packets", 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := findMetric(metrics, tt.metric, map[string]string{"handle": "1:0", "dp": tt.dp})
+			if !ok || got != tt.want {
+				t.Fatalf("unexpected %s of dp %s: got %v (%t), want %v", tt.metric, tt.dp, got, ok, tt.want)
+			}
+		})
+	}
+
+	// a qdisc that is not in the dump is not exported
+	other := tc.Object{Msg: tc.Msg{Handle: handle, Parent: core.BuildHandle(2, 1)}, Attribute: tc.Attribute{Kind: "gred"}}
+	metrics = collectMetrics(t, func(ch chan<- prometheus.Metric) {
+		dump.Collect(col, ch, "host", "default", interf, other)
+	})
+	if len(metrics) != 0 {
+		t.Fatalf("expected no metrics for a qdisc missing from the dump, got %d", len(metrics))
+	}
+}
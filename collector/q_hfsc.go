@@ -329,3 +329,8 @@ func (c *ServiceCurveCollector) CollectObject(ch chan<- prometheus.Metric, host,
 	}
 	c.Mutex.Unlock()
 }
+
+// counters implements counterCollector
+func (col *HfscCollector) counters() []*prometheus.Desc {
+	return []*prometheus.Desc{col.work, col.rtWork}
+}
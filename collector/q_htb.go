@@ -142,3 +142,8 @@ func (col *HtbCollector) CollectObject(ch chan<- prometheus.Metric, host, ns str
 		fmt.Sprintf("%x:%x", parentMaj, parentMin),
 	)
 }
+
+// counters implements counterCollector
+func (col *HtbCollector) counters() []*prometheus.Desc {
+	return []*prometheus.Desc{col.lends, col.borrows, col.giants}
+}
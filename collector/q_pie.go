@@ -200,3 +200,13 @@ func (col *PieCollector) CollectObject(ch chan<- prometheus.Metric, host, ns str
 		fmt.Sprintf("%x:%x", parentMaj, parentMin),
 	)
 }
+
+// counters implements counterCollector
+func (col *PieCollector) counters() []*prometheus.Desc {
+	return []*prometheus.Desc{
+		col.packetsIn,
+		col.dropped,
+		col.overlimit,
+		col.ecnMark,
+	}
+}
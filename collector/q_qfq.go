@@ -0,0 +1,120 @@
+package tccollector
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+
+	"github.com/florianl/go-tc"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	qfqLabels []string = []string{"host", "netns", "linkindex", "link", "type", "handle", "parent"}
+)
+
+const (
+	tcaQfqWeight = 1
+	tcaQfqLmax   = 2
+)
+
+// qfqParms is the configuration of a QFQ class
+type qfqParms struct {
+	weight uint32
+	lmax   uint32
+}
+
+// parseQfqOptions decodes the TCA_OPTIONS of a QFQ class
+func parseQfqOptions(b []byte) (qfqParms, error) {
+	attrs, err := parseAttrs(b)
+	if err != nil {
+		return qfqParms{}, err
+	}
+	var p qfqParms
+	if w, ok := attrs[tcaQfqWeight]; ok && len(w) >= 4 {
+		p.weight = binary.NativeEndian.Uint32(w)
+	}
+	if l, ok := attrs[tcaQfqLmax]; ok && len(l) >= 4 {
+		p.lmax = binary.NativeEndian.Uint32(l)
+	}
+	return p, nil
+}
+
+// QfqCollector is the object that will collect QFQ class data for the interface
+type QfqCollector struct {
+	logger slog.Logger
+	netns  map[string][]rtnetlink.LinkMessage
+
+	weight *prometheus.Desc
+	lmax   *prometheus.Desc
+}
+
+// NewQfqCollector create a new QfqCollector given a network interface
+func NewQfqCollector(netns map[string][]rtnetlink.LinkMessage, log *slog.Logger) (ObjectCollector, error) {
+	// Setup logger for qfq collector
+	log = log.With("collector", "qfq")
+	log.Info("making qfq collector")
+
+	return &QfqCollector{
+		logger: *log,
+		netns:  netns,
+		weight: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "qfq", "weight"),
+			"QFQ class weight",
+			qfqLabels, nil,
+		),
+		lmax: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "qfq", "lmax_bytes"),
+			"QFQ class maximum packet size",
+			qfqLabels, nil,
+		),
+	}, nil
+}
+
+// Describe implements Collector
+func (col *QfqCollector) Describe(ch chan<- *prometheus.Desc) {
+	ds := []*prometheus.Desc{
+		col.weight,
+		col.lmax,
+	}
+
+	for _, d := range ds {
+		ch <- d
+	}
+}
+
+// CollectObject fetches and updates the data the collector is exporting
+func (col *QfqCollector) CollectObject(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, cl tc.Object) {
+	col.collectRaw(ch, host, ns, interf, cl, newRawDump(ns, unix.RTM_GETTCLASS, interf.Index))
+}
+
+// collectRaw exports the QFQ class from the class dump of the interface
+func (col *QfqCollector) collectRaw(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, cl tc.Object, raws *rawDump) {
+	raw, err := raws.get(cl)
+	if err != nil {
+		col.logger.Error("failed to get QFQ class", "interface", interf.Attributes.Name, "err", err)
+		return
+	}
+	parms, err := parseQfqOptions(raw.Attrs[tcaOptions])
+	if err != nil {
+		col.logger.Error("failed to parse QFQ options", "interface", interf.Attributes.Name, "err", err)
+		return
+	}
+
+	handleMaj, handleMin := HandleStr(cl.Handle)
+	parentMaj, parentMin := HandleStr(cl.Parent)
+	labels := []string{
+		host,
+		ns,
+		fmt.Sprintf("%d", interf.Index),
+		interf.Attributes.Name,
+		cl.Kind,
+		fmt.Sprintf("%x:%x", handleMaj, handleMin),
+		fmt.Sprintf("%x:%x", parentMaj, parentMin),
+	}
+
+	ch <- prometheus.MustNewConstMetric(col.weight, prometheus.GaugeValue, float64(parms.weight), labels...)
+	ch <- prometheus.MustNewConstMetric(col.lmax, prometheus.GaugeValue, float64(parms.lmax), labels...)
+}
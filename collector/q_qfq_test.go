@@ -0,0 +1,70 @@
+package tccollector_test
+
+import (
+	"log/slog"
+	"os"
+	"testing"
+
+	tcexporter "github.com/fbegyn/tc_exporter/collector"
+	"github.com/florianl/go-tc"
+	"github.com/florianl/go-tc/core"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/mdlayher/netlink"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// encodeQfqOptions encodes the TCA_OPTIONS of a QFQ class
+func encodeQfqOptions(t *testing.T, weight, lmax uint32) []byte {
+	t.Helper()
+	ae := netlink.NewAttributeEncoder()
+	ae.Uint32(1, weight)
+	ae.Uint32(2, lmax)
+	b, err := ae.Encode()
+	if err != nil {
+		t.Fatalf("failed to encode QFQ options: %v", err)
+	}
+	return b
+}
+
+func TestQfqCollector(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	col, err := tcexporter.NewQfqCollector(nil, logger)
+	if err != nil {
+		t.Fatalf("failed to create QFQ collector: %v", err)
+	}
+	interf := rtnetlink.LinkMessage{Index: 2, Attributes: &rtnetlink.LinkAttributes{Name: "eth0"}}
+
+	dump := tcexporter.NewTestRawDump(2,
+		tcexporter.TestRawObject{Handle: core.BuildHandle(1, 1), Parent: core.BuildHandle(1, 0), Kind: "qfq", Options: encodeQfqOptions(t, 10, 1514)},
+		tcexporter.TestRawObject{Handle: core.BuildHandle(1, 2), Parent: core.BuildHandle(1, 0), Kind: "qfq", Options: encodeQfqOptions(t, 1, 9000)},
+	)
+
+	tests := []struct {
+		handle string
+		weight float64
+		lmax   float64
+	}{
+		{"1:1", 10, 1514},
+		{"1:2", 1, 9000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.handle, func(t *testing.T) {
+			handle, err := tcexporter.ParseHandle(tt.handle)
+			if err != nil {
+				t.Fatalf("failed to parse handle: %v", err)
+			}
+			cl := tc.Object{Msg: tc.Msg{Handle: handle, Parent: core.BuildHandle(1, 0)}, Attribute: tc.Attribute{Kind: "qfq"}}
+			metrics := collectMetrics(t, func(ch chan<- prometheus.Metric) {
+				dump.Collect(col, ch, "host", "default", interf, cl)
+			})
+			weight, ok := findMetric(metrics, "tc_qfq_weight", map[string]string{"handle": tt.handle})
+			if !ok || weight != tt.weight {
+				t.Fatalf("unexpected weight: got %v (%t), want %v", weight, ok, tt.weight)
+			}
+			lmax, ok := findMetric(metrics, "tc_qfq_lmax_bytes", map[string]string{"handle": tt.handle})
+			if !ok || lmax != tt.lmax {
+				t.Fatalf("unexpected lmax: got %v (%t), want %v", lmax, ok, tt.lmax)
+			}
+		})
+	}
+}
@@ -124,3 +124,13 @@ func (col *RedCollector) CollectObject(ch chan<- prometheus.Metric, host, ns str
 		fmt.Sprintf("%x:%x", parentMaj, parentMin),
 	)
 }
+
+// counters implements counterCollector
+func (col *RedCollector) counters() []*prometheus.Desc {
+	return []*prometheus.Desc{
+		col.early,
+		col.pDrop,
+		col.other,
+		col.marked,
+	}
+}
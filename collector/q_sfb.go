@@ -219,3 +219,15 @@ func (col *SfbCollector) CollectObject(ch chan<- prometheus.Metric, host, ns str
 		fmt.Sprintf("%x:%x", parentMaj, parentMin),
 	)
 }
+
+// counters implements counterCollector
+func (col *SfbCollector) counters() []*prometheus.Desc {
+	return []*prometheus.Desc{
+		col.earlyDrop,
+		col.penaltyDrop,
+		col.bucketDrop,
+		col.queueDrop,
+		col.childDrop,
+		col.marked,
+	}
+}
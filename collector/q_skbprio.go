@@ -0,0 +1,138 @@
+package tccollector
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+
+	"github.com/florianl/go-tc"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	skbprioLabels         []string = []string{"host", "netns", "linkindex", "link", "type", "handle", "parent"}
+	skbprioPriorityLabels []string = []string{"host", "netns", "linkindex", "link", "type", "handle", "priority"}
+)
+
+// SkbprioCollector is the object that will collect skbprio qdisc data for the interface. skbprio
+// dumps a class per priority, the stats of the priorities are read from those classes.
+type SkbprioCollector struct {
+	logger slog.Logger
+	netns  map[string][]rtnetlink.LinkMessage
+
+	limit   *prometheus.Desc
+	drops   *prometheus.Desc
+	qlen    *prometheus.Desc
+	backlog *prometheus.Desc
+}
+
+// NewSkbprioCollector create a new SkbprioCollector given a network interface
+func NewSkbprioCollector(netns map[string][]rtnetlink.LinkMessage, log *slog.Logger) (ObjectCollector, error) {
+	// Setup logger for skbprio collector
+	log = log.With("collector", "skbprio")
+	log.Info("making skbprio collector")
+
+	return &SkbprioCollector{
+		logger: *log,
+		netns:  netns,
+		limit: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "skbprio", "limit_packets"),
+			"skbprio queue limit",
+			skbprioLabels, nil,
+		),
+		drops: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "skbprio", "drops"),
+			"skbprio packets of the priority that were dropped",
+			skbprioPriorityLabels, nil,
+		),
+		qlen: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "skbprio", "qlen"),
+			"skbprio packets of the priority that are queued",
+			skbprioPriorityLabels, nil,
+		),
+		backlog: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "skbprio", "backlog_bytes"),
+			"skbprio bytes of the priority that are queued",
+			skbprioPriorityLabels, nil,
+		),
+	}, nil
+}
+
+// Describe implements Collector
+func (col *SkbprioCollector) Describe(ch chan<- *prometheus.Desc) {
+	ds := []*prometheus.Desc{
+		col.limit,
+		col.drops,
+		col.qlen,
+		col.backlog,
+	}
+
+	for _, d := range ds {
+		ch <- d
+	}
+}
+
+// CollectObject fetches and updates the data the collector is exporting
+func (col *SkbprioCollector) CollectObject(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, obj tc.Object) {
+	col.collectRaw(ch, host, ns, interf, obj, newRawDump(ns, unix.RTM_GETQDISC, interf.Index))
+}
+
+// collectRaw exports the skbprio qdisc or class. Only the qdisc is read from the dump, which is
+// the qdisc dump of the interface, the classes have all they need in their stats.
+func (col *SkbprioCollector) collectRaw(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, obj tc.Object, raws *rawDump) {
+	handleMaj, handleMin := HandleStr(obj.Handle)
+	parentMaj, parentMin := HandleStr(obj.Parent)
+
+	// the qdisc has the limit, the classes the stats of a priority
+	if handleMin == 0 {
+		raw, err := raws.get(obj)
+		if err != nil {
+			col.logger.Error("failed to get skbprio qdisc", "interface", interf.Attributes.Name, "err", err)
+			return
+		}
+		// the options are a plain struct tc_skbprio_qopt
+		opts := raw.Attrs[tcaOptions]
+		if len(opts) < 4 {
+			col.logger.Error("skbprio options are too short", "interface", interf.Attributes.Name, "length", len(opts))
+			return
+		}
+		ch <- prometheus.MustNewConstMetric(
+			col.limit,
+			prometheus.GaugeValue,
+			float64(binary.NativeEndian.Uint32(opts[0:4])),
+			host,
+			ns,
+			fmt.Sprintf("%d", interf.Index),
+			interf.Attributes.Name,
+			obj.Kind,
+			fmt.Sprintf("%x:%x", handleMaj, handleMin),
+			fmt.Sprintf("%x:%x", parentMaj, parentMin),
+		)
+		return
+	}
+
+	s, ok := readStats(obj)
+	if !ok {
+		return
+	}
+	// class minor N holds priority N-1
+	labels := []string{
+		host,
+		ns,
+		fmt.Sprintf("%d", interf.Index),
+		interf.Attributes.Name,
+		obj.Kind,
+		fmt.Sprintf("%x:%x", handleMaj, 0),
+		fmt.Sprintf("%d", handleMin-1),
+	}
+	ch <- prometheus.MustNewConstMetric(col.drops, prometheus.CounterValue, s.drops, labels...)
+	ch <- prometheus.MustNewConstMetric(col.qlen, prometheus.GaugeValue, s.qlen, labels...)
+	ch <- prometheus.MustNewConstMetric(col.backlog, prometheus.GaugeValue, s.backlog, labels...)
+}
+
+// counters implements counterCollector
+func (col *SkbprioCollector) counters() []*prometheus.Desc {
+	return []*prometheus.Desc{col.drops}
+}
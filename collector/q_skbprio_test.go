@@ -0,0 +1,76 @@
+package tccollector_test
+
+import (
+	"encoding/binary"
+	"log/slog"
+	"os"
+	"testing"
+
+	tcexporter "github.com/fbegyn/tc_exporter/collector"
+	"github.com/florianl/go-tc"
+	"github.com/florianl/go-tc/core"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestSkbprioCollector(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	col, err := tcexporter.NewSkbprioCollector(nil, logger)
+	if err != nil {
+		t.Fatalf("failed to create skbprio collector: %v", err)
+	}
+	interf := rtnetlink.LinkMessage{Index: 2, Attributes: &rtnetlink.LinkAttributes{Name: "eth0"}}
+
+	// the options are a plain struct tc_skbprio_qopt
+	opts := make([]byte, 4)
+	binary.NativeEndian.PutUint32(opts, 64)
+	dump := tcexporter.NewTestRawDump(2, tcexporter.TestRawObject{Handle: core.BuildHandle(1, 0), Parent: tc.HandleRoot, Kind: "skbprio", Options: opts})
+
+	t.Run("qdisc", func(t *testing.T) {
+		qd := tc.Object{Msg: tc.Msg{Handle: core.BuildHandle(1, 0), Parent: tc.HandleRoot}, Attribute: tc.Attribute{Kind: "skbprio"}}
+		metrics := collectMetrics(t, func(ch chan<- prometheus.Metric) {
+			dump.Collect(col, ch, "host", "default", interf, qd)
+		})
+		limit, ok := findMetric(metrics, "tc_skbprio_limit_packets", map[string]string{"handle": "1:0", "parent": "ffff:ffff"})
+		if !ok || limit != 64 {
+			t.Fatalf("unexpected limit: got %v (%t), want 64", limit, ok)
+		}
+	})
+
+	// class minor N holds the stats of priority N-1
+	tests := []struct {
+		minor    uint32
+		priority string
+		stats    tc.Stats2
+	}{
+		{1, "0", tc.Stats2{Drops: 3, Qlen: 2, Backlog: 3000}},
+		{0x20, "31", tc.Stats2{Drops: 7, Qlen: 1, Backlog: 64}},
+		{0x40, "63", tc.Stats2{}},
+	}
+	for _, tt := range tests {
+		t.Run("priority "+tt.priority, func(t *testing.T) {
+			cl := tc.Object{
+				Msg:       tc.Msg{Handle: core.BuildHandle(1, tt.minor), Parent: core.BuildHandle(1, 0)},
+				Attribute: tc.Attribute{Kind: "skbprio", Stats2: &tt.stats},
+			}
+			metrics := collectMetrics(t, func(ch chan<- prometheus.Metric) {
+				dump.Collect(col, ch, "host", "default", interf, cl)
+			})
+			labels := map[string]string{"handle": "1:0", "priority": tt.priority}
+			want := map[string]float64{
+				"tc_skbprio_drops":         float64(tt.stats.Drops),
+				"tc_skbprio_qlen":          float64(tt.stats.Qlen),
+				"tc_skbprio_backlog_bytes": float64(tt.stats.Backlog),
+			}
+			if len(metrics) != len(want) {
+				t.Fatalf("unexpected number of metrics: got %d, want %d", len(metrics), len(want))
+			}
+			for name, w := range want {
+				got, ok := findMetric(metrics, name, labels)
+				if !ok || got != w {
+					t.Fatalf("unexpected %s: got %v (%t), want %v", name, got, ok, w)
+				}
+			}
+		})
+	}
+}
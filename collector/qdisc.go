@@ -235,3 +235,13 @@ func (qc *QdiscCollector) CollectObject(ch chan<- prometheus.Metric, host, ns st
 		)
 	}
 }
+
+// counters implements counterCollector
+func (qc *QdiscCollector) counters() []*prometheus.Desc {
+	return qc.stats.counters()
+}
+
+// byteCounter implements byteCollector
+func (qc *QdiscCollector) byteCounter() *prometheus.Desc {
+	return qc.stats.bytes
+}
@@ -0,0 +1,332 @@
+package tccollector
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/florianl/go-tc"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	rateLabels []string = []string{"host", "netns", "linkindex", "link", "type", "handle", "parent", "window"}
+)
+
+// RateConfig configures the rates that are calculated by the exporter itself
+type RateConfig struct {
+	Enabled  bool            `mapstructure:"enabled"`
+	Interval time.Duration   `mapstructure:"interval"`
+	Windows  []time.Duration `mapstructure:"windows"`
+}
+
+// rateKey identifies a qdisc or class in the rate history. The parent is part of it as the
+// children of mq and mqprio all have handle 0:.
+type rateKey struct {
+	ns      string
+	ifindex uint32
+	object  string
+	handle  uint32
+	parent  uint32
+}
+
+type rateSample struct {
+	ts      time.Time
+	bytes   uint64
+	packets uint64
+}
+
+type rateHistory struct {
+	kind      string
+	estimator bool
+	round     uint64
+	samples   []rateSample
+}
+
+// RateSampler periodically samples the counters of all qdiscs and classes so byte and packet rates
+// can be calculated without a kernel rate estimator
+type RateSampler struct {
+	logger   slog.Logger
+	netns    map[string][]rtnetlink.LinkMessage
+	interval time.Duration
+	windows  []time.Duration
+	keep     time.Duration
+
+	mu      sync.Mutex
+	round   uint64
+	history map[rateKey]*rateHistory
+}
+
+// NewRateSampler creates a new RateSampler for the configured windows
+func NewRateSampler(netns map[string][]rtnetlink.LinkMessage, cfg RateConfig, log *slog.Logger) *RateSampler {
+	log = log.With("collector", "rate")
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	windows := cfg.Windows
+	if len(windows) == 0 {
+		windows = []time.Duration{10 * time.Second, time.Minute}
+	}
+	var keep time.Duration
+	for _, w := range windows {
+		if w < interval {
+			log.Warn("rate window is shorter than the sampling interval", "window", w, "interval", interval)
+		}
+		keep = max(keep, w)
+	}
+
+	return &RateSampler{
+		logger:   *log,
+		netns:    netns,
+		interval: interval,
+		windows:  windows,
+		keep:     keep + interval,
+		history:  make(map[rateKey]*rateHistory),
+	}
+}
+
+// Run samples the counters every interval, it does not return
+func (rs *RateSampler) Run() {
+	rs.logger.Info("starting rate sampler", "interval", rs.interval, "windows", rs.windows)
+	ticker := time.NewTicker(rs.interval)
+	defer ticker.Stop()
+	for {
+		rs.sample()
+		<-ticker.C
+	}
+}
+
+// sample takes one sample of every qdisc and class on the monitored interfaces
+func (rs *RateSampler) sample() {
+	type dump struct {
+		ns     string
+		object string
+		objs   []rawObject
+	}
+	var dumps []dump
+	for ns, devices := range rs.netns {
+		for _, interf := range devices {
+			// the sampler runs on its own clock, between the scrapes, so it has its own dumps
+			qdiscs, err := newRawDump(ns, unix.RTM_GETQDISC, interf.Index).all()
+			if err != nil {
+				rs.logger.Error("failed to get qdiscs", "interface", interf.Attributes.Name, "err", err)
+			}
+			classes, err := newRawDump(ns, unix.RTM_GETTCLASS, interf.Index).all()
+			if err != nil {
+				rs.logger.Error("failed to get classes", "interface", interf.Attributes.Name, "err", err)
+			}
+			dumps = append(dumps, dump{ns, "qdisc", qdiscs}, dump{ns, "class", classes})
+		}
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.round++
+	now := time.Now()
+	for _, d := range dumps {
+		rs.record(now, d.ns, d.object, d.objs)
+	}
+	// forget the objects that have disappeared
+	for key, h := range rs.history {
+		if h.round != rs.round {
+			delete(rs.history, key)
+		}
+	}
+}
+
+// record adds the counters of the objects at time now to their history
+func (rs *RateSampler) record(now time.Time, ns, object string, objs []rawObject) {
+	for _, obj := range objs {
+		bytes, packets, ok := obj.basic()
+		if !ok {
+			continue
+		}
+		key := rateKey{ns: ns, ifindex: obj.Ifindex, object: object, handle: obj.Handle, parent: obj.Parent}
+		h, found := rs.history[key]
+		if !found || h.kind != obj.Kind {
+			h = &rateHistory{kind: obj.Kind}
+			rs.history[key] = h
+		}
+		h.round = rs.round
+		h.estimator = obj.hasRateEstimator()
+
+		// a counter that went backwards means the object was reset, the old samples are useless
+		if n := len(h.samples); n > 0 && (bytes < h.samples[n-1].bytes || packets < h.samples[n-1].packets) {
+			h.samples = h.samples[:0]
+		}
+		h.samples = append(h.samples, rateSample{ts: now, bytes: bytes, packets: packets})
+
+		drop := 0
+		for drop < len(h.samples)-1 && now.Sub(h.samples[drop].ts) > rs.keep {
+			drop++
+		}
+		h.samples = h.samples[drop:]
+	}
+}
+
+// rates returns the byte and packet rate of the object over the window. ok is false when there is
+// not enough history to cover the window yet.
+func (h *rateHistory) rates(window time.Duration) (bps, pps float64, ok bool) {
+	if len(h.samples) < 2 {
+		return 0, 0, false
+	}
+	last := h.samples[len(h.samples)-1]
+	if last.ts.Sub(h.samples[0].ts) < window {
+		return 0, 0, false
+	}
+	first := last
+	for _, s := range h.samples {
+		if last.ts.Sub(s.ts) <= window {
+			first = s
+			break
+		}
+	}
+	dt := last.ts.Sub(first.ts).Seconds()
+	if dt <= 0 {
+		return 0, 0, false
+	}
+	return float64(last.bytes-first.bytes) / dt, float64(last.packets-first.packets) / dt, true
+}
+
+// RateCollector exports the rates calculated by a RateSampler for either qdiscs or classes
+type RateCollector struct {
+	logger    slog.Logger
+	sampler   *RateSampler
+	object    string
+	bps       *prometheus.Desc
+	pps       *prometheus.Desc
+	estimator *prometheus.Desc
+}
+
+// NewRateCollector create a new RateCollector for the given object type (qdisc or class)
+func NewRateCollector(sampler *RateSampler, object string, log *slog.Logger) (ObjectCollector, error) {
+	log = log.With("collector", "rate", "object", object)
+	log.Info("making rate collector")
+
+	return &RateCollector{
+		logger:  *log,
+		sampler: sampler,
+		object:  object,
+		bps: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, object, "rate_bps"),
+			fmt.Sprintf("Byte rate of the %s calculated by the exporter over the window", object),
+			rateLabels, nil,
+		),
+		pps: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, object, "rate_pps"),
+			fmt.Sprintf("Packet rate of the %s calculated by the exporter over the window", object),
+			rateLabels, nil,
+		),
+		estimator: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, object, "rate_estimator"),
+			fmt.Sprintf("Whether a kernel rate estimator is attached to the %s", object),
+			qdisclabels, nil,
+		),
+	}, nil
+}
+
+// Describe implements Collector
+func (col *RateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ds := []*prometheus.Desc{
+		col.bps,
+		col.pps,
+		col.estimator,
+	}
+
+	for _, d := range ds {
+		ch <- d
+	}
+}
+
+// CollectObject fetches and updates the data the collector is exporting
+func (col *RateCollector) CollectObject(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, obj tc.Object) {
+	handleMaj, handleMin := HandleStr(obj.Handle)
+	parentMaj, parentMin := HandleStr(obj.Parent)
+
+	type rate struct {
+		window   time.Duration
+		bps, pps float64
+	}
+	var rates []rate
+	col.sampler.mu.Lock()
+	h, found := col.sampler.history[rateKey{ns: ns, ifindex: interf.Index, object: col.object, handle: obj.Handle, parent: obj.Parent}]
+	found = found && h.kind == obj.Kind
+	var hasEstimator bool
+	if found {
+		hasEstimator = h.estimator
+		for _, window := range col.sampler.windows {
+			if bps, pps, ok := h.rates(window); ok {
+				rates = append(rates, rate{window, bps, pps})
+			}
+		}
+	}
+	col.sampler.mu.Unlock()
+	if !found {
+		col.logger.Debug("no rate history for object", "object", obj)
+		return
+	}
+
+	estimator := 0.0
+	if hasEstimator {
+		estimator = 1
+	}
+	ch <- prometheus.MustNewConstMetric(
+		col.estimator,
+		prometheus.GaugeValue,
+		estimator,
+		host,
+		ns,
+		fmt.Sprintf("%d", interf.Index),
+		interf.Attributes.Name,
+		obj.Kind,
+		fmt.Sprintf("%x:%x", handleMaj, handleMin),
+		fmt.Sprintf("%x:%x", parentMaj, parentMin),
+	)
+
+	for _, r := range rates {
+		ch <- prometheus.MustNewConstMetric(
+			col.bps,
+			prometheus.GaugeValue,
+			r.bps,
+			host,
+			ns,
+			fmt.Sprintf("%d", interf.Index),
+			interf.Attributes.Name,
+			obj.Kind,
+			fmt.Sprintf("%x:%x", handleMaj, handleMin),
+			fmt.Sprintf("%x:%x", parentMaj, parentMin),
+			fmtWindow(r.window),
+		)
+		ch <- prometheus.MustNewConstMetric(
+			col.pps,
+			prometheus.GaugeValue,
+			r.pps,
+			host,
+			ns,
+			fmt.Sprintf("%d", interf.Index),
+			interf.Attributes.Name,
+			obj.Kind,
+			fmt.Sprintf("%x:%x", handleMaj, handleMin),
+			fmt.Sprintf("%x:%x", parentMaj, parentMin),
+			fmtWindow(r.window),
+		)
+	}
+}
+
+// fmtWindow formats a window duration the way Prometheus writes ranges (10s, 1m, 1h)
+func fmtWindow(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	case d%time.Second == 0:
+		return fmt.Sprintf("%ds", d/time.Second)
+	default:
+		return d.String()
+	}
+}
@@ -0,0 +1,117 @@
+package tccollector_test
+
+import (
+	"testing"
+	"time"
+
+	tcexporter "github.com/fbegyn/tc_exporter/collector"
+	"github.com/florianl/go-tc/core"
+)
+
+func TestRateSamplerWindows(t *testing.T) {
+	rs := tcexporter.NewTestRateSampler(tcexporter.RateConfig{
+		Interval: 5 * time.Second,
+		Windows:  []time.Duration{10 * time.Second, time.Minute},
+	})
+	qd := tcexporter.TestObject{Ifindex: 2, Handle: core.BuildHandle(1, 0), Kind: "htb"}
+
+	start := time.Unix(1000, 0)
+	if _, _, ok := rs.Rates(qd, 10*time.Second); ok {
+		t.Fatalf("expected no rate without samples")
+	}
+	// 1000 bytes and 10 packets per second, sampled every 5 seconds for 90 seconds
+	for i := range 19 {
+		qd.Bytes = uint64(i) * 5000
+		qd.Packets = uint32(i) * 50
+		rs.Record(start.Add(time.Duration(i)*5*time.Second), qd)
+		if i == 0 {
+			if _, _, ok := rs.Rates(qd, 10*time.Second); ok {
+				t.Fatalf("expected no rate with a single sample")
+			}
+		}
+	}
+
+	tests := []struct {
+		window time.Duration
+		bps    float64
+		pps    float64
+		ok     bool
+	}{
+		{10 * time.Second, 1000, 10, true},
+		{time.Minute, 1000, 10, true},
+		// the samples older than the longest window are dropped, they never cover an hour
+		{time.Hour, 0, 0, false},
+	}
+	for _, tt := range tests {
+		bps, pps, ok := rs.Rates(qd, tt.window)
+		if ok != tt.ok {
+			t.Fatalf("unexpected rate over %s: got ok %t, want %t", tt.window, ok, tt.ok)
+		}
+		if bps != tt.bps || pps != tt.pps {
+			t.Fatalf("unexpected rate over %s: got %v bps %v pps, want %v bps %v pps", tt.window, bps, pps, tt.bps, tt.pps)
+		}
+	}
+
+	// a faster last interval only shows in the short window
+	qd.Bytes += 11000
+	qd.Packets += 110
+	rs.Record(start.Add(95*time.Second), qd)
+	if bps, _, _ := rs.Rates(qd, 10*time.Second); bps != 1600 {
+		t.Fatalf("unexpected rate over 10s: got %v, want 1600", bps)
+	}
+	if bps, _, _ := rs.Rates(qd, time.Minute); bps != 1100 {
+		t.Fatalf("unexpected rate over 1m: got %v, want 1100", bps)
+	}
+}
+
+func TestRateSamplerReset(t *testing.T) {
+	rs := tcexporter.NewTestRateSampler(tcexporter.RateConfig{Interval: time.Second, Windows: []time.Duration{time.Minute}})
+	qd := tcexporter.TestObject{Ifindex: 2, Handle: core.BuildHandle(1, 0), Kind: "htb", Bytes: 1e6, Packets: 1000}
+
+	start := time.Unix(1000, 0)
+	rs.Record(start, qd)
+	qd.Bytes, qd.Packets = 2e6, 2000
+	rs.Record(start.Add(time.Second), qd)
+	// the counters went backwards, the qdisc was replaced
+	qd.Bytes, qd.Packets = 100, 1
+	rs.Record(start.Add(2*time.Second), qd)
+	if _, _, ok := rs.Rates(qd, time.Minute); ok {
+		t.Fatalf("expected no rate right after a reset")
+	}
+	// the history after the reset does not cover the window yet
+	qd.Bytes, qd.Packets = 600, 6
+	rs.Record(start.Add(3*time.Second), qd)
+	if _, _, ok := rs.Rates(qd, time.Minute); ok {
+		t.Fatalf("expected no rate before the window is covered after a reset")
+	}
+	if bps, pps, ok := rs.Rates(qd, time.Second); !ok || bps != 500 || pps != 5 {
+		t.Fatalf("unexpected rate over 1s after a reset: got %v bps %v pps (%t), want 500 bps 5 pps", bps, pps, ok)
+	}
+	qd.Bytes, qd.Packets = 30100, 301
+	rs.Record(start.Add(62*time.Second), qd)
+	if bps, pps, ok := rs.Rates(qd, time.Minute); !ok || bps != 500 || pps != 5 {
+		t.Fatalf("unexpected rate over 1m after a reset: got %v bps %v pps (%t), want 500 bps 5 pps", bps, pps, ok)
+	}
+}
+
+func TestRateSamplerMqChildren(t *testing.T) {
+	rs := tcexporter.NewTestRateSampler(tcexporter.RateConfig{Interval: time.Second, Windows: []time.Duration{time.Second}})
+	// the children of mq all have handle 0:, only their parents differ
+	children := []tcexporter.TestObject{
+		{Ifindex: 2, Parent: core.BuildHandle(1, 1), Kind: "fq_codel", Bytes: 1000},
+		{Ifindex: 2, Parent: core.BuildHandle(1, 2), Kind: "fq_codel", Bytes: 500},
+	}
+
+	start := time.Unix(1000, 0)
+	rs.Record(start, children...)
+	children[0].Bytes += 1000
+	children[1].Bytes += 100
+	rs.Record(start.Add(time.Second), children...)
+
+	for i, want := range []float64{1000, 100} {
+		bps, _, ok := rs.Rates(children[i], time.Second)
+		if !ok || bps != want {
+			t.Fatalf("unexpected rate of child %d: got %v (%t), want %v", i, bps, ok, want)
+		}
+	}
+}
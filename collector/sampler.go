@@ -0,0 +1,476 @@
+package tccollector
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/florianl/go-tc"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	samplerLabels   []string = []string{"host", "netns", "linkindex", "link"}
+	thresholdLabels []string = []string{"host", "netns", "linkindex", "link", "type", "handle", "parent", "threshold"}
+)
+
+// SamplerConfig configures the high frequency queue depth sampler
+type SamplerConfig struct {
+	Enabled  bool          `mapstructure:"enabled"`
+	Interval time.Duration `mapstructure:"interval"`
+	MaxCPU   float64       `mapstructure:"max-cpu"`
+}
+
+// depthKey identifies a qdisc or class for the depth sampler. The parent is part of it as the
+// children of mq and mqprio all have handle 0:.
+type depthKey struct {
+	ns      string
+	ifindex uint32
+	object  string
+	handle  uint32
+	parent  uint32
+}
+
+type depthState struct {
+	kind       string
+	labels     []string
+	round      uint64
+	ts         time.Time
+	qlen       uint32
+	backlog    uint32
+	maxQlen    uint32
+	maxBacklog uint32
+	// seconds spent above the thresholds that apply to the object
+	above map[thresholdKey]float64
+}
+
+// linkKey identifies an interface in a netns
+type linkKey struct {
+	ns      string
+	ifindex uint32
+}
+
+type samplerStats struct {
+	round     uint64
+	lag       time.Duration
+	duration  time.Duration
+	polls     uint64
+	throttled uint64
+}
+
+// DepthSampler polls the queue length and backlog of all qdiscs and classes at a high frequency.
+// Scrapes only see the instantaneous queue depth, which is almost always zero, so bursts that cause
+// drops go unnoticed. The sampler keeps the maximum depth between scrapes and a histogram of all the
+// observed backlogs instead. It also counts the time objects spend above the configured thresholds.
+type DepthSampler struct {
+	logger     slog.Logger
+	netns      map[string][]rtnetlink.LinkMessage
+	host       string
+	interval   time.Duration
+	maxCPU     float64
+	thresholds []threshold
+
+	mu         sync.Mutex
+	objects    map[depthKey]*depthState
+	links      map[linkKey]*samplerStats
+	histograms map[string]*prometheus.HistogramVec
+
+	lag       *prometheus.Desc
+	duration  *prometheus.Desc
+	polls     *prometheus.Desc
+	throttled *prometheus.Desc
+}
+
+// NewDepthSampler creates a new DepthSampler, the sampling starts when Run is called
+func NewDepthSampler(netns map[string][]rtnetlink.LinkMessage, cfg SamplerConfig, thresholds []Threshold, log *slog.Logger) (*DepthSampler, error) {
+	log = log.With("collector", "sampler")
+	ths, err := compileThresholds(thresholds)
+	if err != nil {
+		return nil, err
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		log.Error("failed to fetch hostname", "err", err)
+	}
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = 100 * time.Millisecond
+	}
+	maxCPU := cfg.MaxCPU
+	if maxCPU <= 0 || maxCPU > 1 {
+		maxCPU = 0.1
+	}
+
+	histograms := make(map[string]*prometheus.HistogramVec)
+	for _, object := range []string{"qdisc", "class"} {
+		histograms[object] = prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:                       namespace,
+				Subsystem:                       object,
+				Name:                            "backlog_sampled_bytes",
+				Help:                            fmt.Sprintf("Backlog of the %s as observed by the depth sampler", object),
+				Buckets:                         prometheus.ExponentialBuckets(1500, 2, 12),
+				NativeHistogramBucketFactor:     1.1,
+				NativeHistogramMaxBucketNumber:  160,
+				NativeHistogramMinResetDuration: time.Hour,
+			},
+			qdisclabels,
+		)
+	}
+
+	return &DepthSampler{
+		logger:     *log,
+		netns:      netns,
+		host:       host,
+		interval:   interval,
+		maxCPU:     maxCPU,
+		thresholds: ths,
+		objects:    make(map[depthKey]*depthState),
+		links:      make(map[linkKey]*samplerStats),
+		histograms: histograms,
+		lag: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "sampler", "lag_seconds"),
+			"How late the last poll of the depth sampler started",
+			samplerLabels, nil,
+		),
+		duration: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "sampler", "poll_duration_seconds"),
+			"Duration of the last poll of the depth sampler",
+			samplerLabels, nil,
+		),
+		polls: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "sampler", "polls_total"),
+			"Number of polls done by the depth sampler",
+			samplerLabels, nil,
+		),
+		throttled: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "sampler", "throttled_total"),
+			"Number of polls delayed to stay within the CPU budget of the depth sampler",
+			samplerLabels, nil,
+		),
+	}, nil
+}
+
+// Run starts a sampling loop for every monitored interface
+func (ds *DepthSampler) Run() {
+	ds.logger.Info("starting depth sampler", "interval", ds.interval, "max-cpu", ds.maxCPU)
+	for ns, devices := range ds.netns {
+		for _, interf := range devices {
+			go ds.run(ns, interf)
+		}
+	}
+}
+
+// run polls a single interface. When a poll takes longer than the CPU budget allows for, the next
+// poll is delayed until the budget is respected again.
+func (ds *DepthSampler) run(ns string, interf rtnetlink.LinkMessage) {
+	next := time.Now()
+	for {
+		start := time.Now()
+		ds.poll(ns, interf, start.Sub(next))
+		took := time.Since(start)
+
+		wait := ds.interval
+		budget := time.Duration(float64(took) / ds.maxCPU)
+		if budget > wait {
+			wait = budget
+			ds.mu.Lock()
+			ds.links[linkKey{ns, interf.Index}].throttled++
+			ds.mu.Unlock()
+		}
+		next = start.Add(wait)
+		time.Sleep(time.Until(next))
+	}
+}
+
+// poll takes one sample of the queue depth of every qdisc and class of the interface
+func (ds *DepthSampler) poll(ns string, interf rtnetlink.LinkMessage, lag time.Duration) {
+	start := time.Now()
+	// the sampler polls many times between two scrapes, so it has its own dumps
+	qdiscs, err := newRawDump(ns, unix.RTM_GETQDISC, interf.Index).all()
+	if err != nil {
+		ds.logger.Error("failed to get qdiscs", "interface", interf.Attributes.Name, "err", err)
+	}
+	classes, err := newRawDump(ns, unix.RTM_GETTCLASS, interf.Index).all()
+	if err != nil {
+		ds.logger.Error("failed to get classes", "interface", interf.Attributes.Name, "err", err)
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	lk := linkKey{ns, interf.Index}
+	st, found := ds.links[lk]
+	if !found {
+		st = &samplerStats{}
+		ds.links[lk] = st
+	}
+	st.round++
+	now := time.Now()
+	ds.record(ns, interf, "qdisc", qdiscs, st.round, now)
+	ds.record(ns, interf, "class", classes, st.round, now)
+
+	// forget the objects of the interface that have disappeared
+	for key, obj := range ds.objects {
+		if key.ns == ns && key.ifindex == interf.Index && obj.round != st.round {
+			ds.histograms[key.object].DeleteLabelValues(obj.labels...)
+			delete(ds.objects, key)
+		}
+	}
+
+	st.lag = lag
+	st.duration = time.Since(start)
+	st.polls++
+}
+
+// record updates the depth of the objects and observes their backlog
+func (ds *DepthSampler) record(ns string, interf rtnetlink.LinkMessage, object string, objs []rawObject, round uint64, now time.Time) {
+	for _, obj := range objs {
+		qlen, backlog, ok := obj.queue()
+		if !ok {
+			continue
+		}
+		handleMaj, handleMin := HandleStr(obj.Handle)
+		parentMaj, parentMin := HandleStr(obj.Parent)
+		labels := []string{
+			ds.host,
+			ns,
+			fmt.Sprintf("%d", interf.Index),
+			interf.Attributes.Name,
+			obj.Kind,
+			fmt.Sprintf("%x:%x", handleMaj, handleMin),
+			fmt.Sprintf("%x:%x", parentMaj, parentMin),
+		}
+
+		key := depthKey{ns: ns, ifindex: obj.Ifindex, object: object, handle: obj.Handle, parent: obj.Parent}
+		st, found := ds.objects[key]
+		if !found || !slices.Equal(st.labels, labels) {
+			if found {
+				ds.histograms[object].DeleteLabelValues(st.labels...)
+			}
+			st = &depthState{
+				kind:   obj.Kind,
+				labels: labels,
+				above:  matchThresholds(ds.thresholds, ns, interf.Attributes.Name, obj.Kind, obj.Handle),
+			}
+			ds.objects[key] = st
+		}
+		// the time since the previous poll is accounted to the state the object is in now
+		if !st.ts.IsZero() {
+			dt := now.Sub(st.ts).Seconds()
+			for th := range st.above {
+				if (th.metric == "backlog" && backlog > th.value) || (th.metric == "qlen" && qlen > th.value) {
+					st.above[th] += dt
+				}
+			}
+		}
+		st.ts = now
+		st.round = round
+		st.qlen = qlen
+		st.backlog = backlog
+		st.maxQlen = max(st.maxQlen, qlen)
+		st.maxBacklog = max(st.maxBacklog, backlog)
+		ds.histograms[object].WithLabelValues(labels...).Observe(float64(backlog))
+	}
+}
+
+// Describe implements Collector
+func (ds *DepthSampler) Describe(ch chan<- *prometheus.Desc) {
+	descs := []*prometheus.Desc{
+		ds.lag,
+		ds.duration,
+		ds.polls,
+		ds.throttled,
+	}
+
+	for _, d := range descs {
+		ch <- d
+	}
+}
+
+// CollectInterface exports the statistics of the sampling loop of the interface
+func (ds *DepthSampler) CollectInterface(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, qdiscs, classes []tc.Object) {
+	ds.mu.Lock()
+	st, found := ds.links[linkKey{ns, interf.Index}]
+	var stats samplerStats
+	if found {
+		stats = *st
+	}
+	ds.mu.Unlock()
+	if !found {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		ds.lag,
+		prometheus.GaugeValue,
+		stats.lag.Seconds(),
+		host,
+		ns,
+		fmt.Sprintf("%d", interf.Index),
+		interf.Attributes.Name,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		ds.duration,
+		prometheus.GaugeValue,
+		stats.duration.Seconds(),
+		host,
+		ns,
+		fmt.Sprintf("%d", interf.Index),
+		interf.Attributes.Name,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		ds.polls,
+		prometheus.CounterValue,
+		float64(stats.polls),
+		host,
+		ns,
+		fmt.Sprintf("%d", interf.Index),
+		interf.Attributes.Name,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		ds.throttled,
+		prometheus.CounterValue,
+		float64(stats.throttled),
+		host,
+		ns,
+		fmt.Sprintf("%d", interf.Index),
+		interf.Attributes.Name,
+	)
+}
+
+// DepthCollector exports the queue depth observed by a DepthSampler for either qdiscs or classes
+type DepthCollector struct {
+	logger       slog.Logger
+	sampler      *DepthSampler
+	object       string
+	maxQlen      *prometheus.Desc
+	maxBacklog   *prometheus.Desc
+	aboveQlen    *prometheus.Desc
+	aboveBacklog *prometheus.Desc
+}
+
+// NewDepthCollector create a new DepthCollector for the given object type (qdisc or class)
+func NewDepthCollector(sampler *DepthSampler, object string, log *slog.Logger) (ObjectCollector, error) {
+	log = log.With("collector", "depth", "object", object)
+	log.Info("making depth collector")
+
+	return &DepthCollector{
+		logger:  *log,
+		sampler: sampler,
+		object:  object,
+		maxQlen: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, object, "qlen_max"),
+			fmt.Sprintf("Maximum queue length of the %s sampled since the last scrape", object),
+			qdisclabels, nil,
+		),
+		maxBacklog: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, object, "backlog_max_bytes"),
+			fmt.Sprintf("Maximum backlog of the %s sampled since the last scrape", object),
+			qdisclabels, nil,
+		),
+		aboveQlen: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, object, "qlen_above_threshold_seconds_total"),
+			fmt.Sprintf("Time the queue length of the %s was above the threshold", object),
+			thresholdLabels, nil,
+		),
+		aboveBacklog: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, object, "backlog_above_threshold_seconds_total"),
+			fmt.Sprintf("Time the backlog of the %s was above the threshold", object),
+			thresholdLabels, nil,
+		),
+	}, nil
+}
+
+// Describe implements Collector
+func (col *DepthCollector) Describe(ch chan<- *prometheus.Desc) {
+	ds := []*prometheus.Desc{
+		col.maxQlen,
+		col.maxBacklog,
+		col.aboveQlen,
+		col.aboveBacklog,
+	}
+
+	for _, d := range ds {
+		ch <- d
+	}
+	col.sampler.histograms[col.object].Describe(ch)
+}
+
+// CollectObject fetches and updates the data the collector is exporting
+func (col *DepthCollector) CollectObject(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, obj tc.Object) {
+	handleMaj, handleMin := HandleStr(obj.Handle)
+	parentMaj, parentMin := HandleStr(obj.Parent)
+
+	col.sampler.mu.Lock()
+	st, found := col.sampler.objects[depthKey{ns: ns, ifindex: interf.Index, object: col.object, handle: obj.Handle, parent: obj.Parent}]
+	found = found && st.kind == obj.Kind
+	var maxQlen, maxBacklog uint32
+	var hist prometheus.Observer
+	above := make(map[thresholdKey]float64)
+	if found {
+		maxQlen, maxBacklog = st.maxQlen, st.maxBacklog
+		for th, seconds := range st.above {
+			above[th] = seconds
+		}
+		// start the next period from the current depth
+		st.maxQlen, st.maxBacklog = st.qlen, st.backlog
+		hist = col.sampler.histograms[col.object].WithLabelValues(st.labels...)
+	}
+	col.sampler.mu.Unlock()
+	if !found {
+		col.logger.Debug("object has not been sampled yet", "object", obj)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		col.maxQlen,
+		prometheus.GaugeValue,
+		float64(maxQlen),
+		host,
+		ns,
+		fmt.Sprintf("%d", interf.Index),
+		interf.Attributes.Name,
+		obj.Kind,
+		fmt.Sprintf("%x:%x", handleMaj, handleMin),
+		fmt.Sprintf("%x:%x", parentMaj, parentMin),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		col.maxBacklog,
+		prometheus.GaugeValue,
+		float64(maxBacklog),
+		host,
+		ns,
+		fmt.Sprintf("%d", interf.Index),
+		interf.Attributes.Name,
+		obj.Kind,
+		fmt.Sprintf("%x:%x", handleMaj, handleMin),
+		fmt.Sprintf("%x:%x", parentMaj, parentMin),
+	)
+	if m, ok := hist.(prometheus.Metric); ok {
+		ch <- m
+	}
+	for th, seconds := range above {
+		desc := col.aboveBacklog
+		if th.metric == "qlen" {
+			desc = col.aboveQlen
+		}
+		ch <- prometheus.MustNewConstMetric(
+			desc,
+			prometheus.CounterValue,
+			seconds,
+			host,
+			ns,
+			fmt.Sprintf("%d", interf.Index),
+			interf.Attributes.Name,
+			obj.Kind,
+			fmt.Sprintf("%x:%x", handleMaj, handleMin),
+			fmt.Sprintf("%x:%x", parentMaj, parentMin),
+			fmt.Sprintf("%d", th.value),
+		)
+	}
+}
@@ -0,0 +1,63 @@
+package tccollector_test
+
+import (
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	tcexporter "github.com/fbegyn/tc_exporter/collector"
+	"github.com/florianl/go-tc"
+	"github.com/florianl/go-tc/core"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestDepthSamplerMqChildren(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	ds, err := tcexporter.NewDepthSampler(nil, tcexporter.SamplerConfig{}, []tcexporter.Threshold{
+		{Link: "eth0", Backlog: 1000},
+	}, logger)
+	if err != nil {
+		t.Fatalf("failed to create depth sampler: %v", err)
+	}
+	col, err := tcexporter.NewDepthCollector(ds, "qdisc", logger)
+	if err != nil {
+		t.Fatalf("failed to create depth collector: %v", err)
+	}
+	interf := rtnetlink.LinkMessage{Index: 2, Attributes: &rtnetlink.LinkAttributes{Name: "eth0"}}
+
+	// the children of mq all have handle 0:, only their parents differ
+	busy := tcexporter.TestObject{Ifindex: 2, Parent: core.BuildHandle(1, 1), Kind: "fq_codel", Qlen: 2, Backlog: 3000}
+	idle := tcexporter.TestObject{Ifindex: 2, Parent: core.BuildHandle(1, 2), Kind: "fq_codel"}
+	start := time.Unix(1000, 0)
+	ds.RecordDepth(start, "default", interf, busy, idle)
+	ds.RecordDepth(start.Add(time.Second), "default", interf, busy, idle)
+
+	tests := []struct {
+		name    string
+		parent  uint32
+		label   string
+		backlog float64
+		above   float64
+	}{
+		{"busy", core.BuildHandle(1, 1), "1:1", 3000, 1},
+		{"idle", core.BuildHandle(1, 2), "1:2", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := tc.Object{Msg: tc.Msg{Parent: tt.parent}, Attribute: tc.Attribute{Kind: "fq_codel"}}
+			metrics := collectMetrics(t, func(ch chan<- prometheus.Metric) {
+				col.CollectObject(ch, "host", "default", interf, obj)
+			})
+			backlog, ok := findMetric(metrics, "tc_qdisc_backlog_max_bytes", map[string]string{"parent": tt.label})
+			if !ok || backlog != tt.backlog {
+				t.Fatalf("unexpected max backlog: got %v (%t), want %v", backlog, ok, tt.backlog)
+			}
+			above, ok := findMetric(metrics, "tc_qdisc_backlog_above_threshold_seconds_total", map[string]string{"parent": tt.label, "threshold": "1000"})
+			if !ok || above != tt.above {
+				t.Fatalf("unexpected time above threshold: got %v (%t), want %v", above, ok, tt.above)
+			}
+		})
+	}
+}
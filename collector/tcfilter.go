@@ -0,0 +1,696 @@
+package tccollector
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+
+	"github.com/florianl/go-tc"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/mdlayher/netlink"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	filterLabels       []string = []string{"host", "netns", "linkindex", "link", "block", "type", "parent", "chain", "prio", "protocol", "handle"}
+	filterSourceLabels []string = []string{"host", "netns", "linkindex", "link", "block", "type", "parent", "chain", "prio", "protocol", "handle", "source"}
+	filterInfoLabels   []string = []string{"host", "netns", "linkindex", "link", "block", "type", "parent", "chain", "prio", "protocol", "handle", "match"}
+	bpfInfoLabels      []string = []string{"host", "netns", "linkindex", "link", "block", "type", "parent", "chain", "prio", "protocol", "handle", "attach", "id", "name", "tag", "direct_action"}
+	bpfStatsLabels     []string = []string{"host", "netns", "linkindex", "link", "block", "type", "parent", "chain", "prio", "protocol", "handle", "attach", "id"}
+	chainLabels        []string = []string{"host", "netns", "linkindex", "link", "block", "parent", "chain"}
+	blockLabels        []string = []string{"host", "netns", "block", "linkindex", "link", "direction"}
+)
+
+// tc filter and action netlink attributes
+const (
+	tcaChain     = 11
+	tcaHwOffload = 12
+
+	tcaStatsBasicHw = 7
+
+	tcaActKind      = 1
+	tcaActOptions   = 2
+	tcaActIndex     = 3
+	tcaActStats     = 4
+	tcaActInHwCount = 10
+
+	// TCA_CLS_FLAGS_*
+	tcaClsFlagsSkipHw = 1 << 0
+	tcaClsFlagsSkipSw = 1 << 1
+	tcaClsFlagsInHw   = 1 << 2
+
+	tcaFlowerInHwCount = 86
+
+	// tcfTLen is the size of struct tcf_t, the timestamps of an action
+	tcfTLen = 32
+	// userHz is the unit of the timestamps of the actions
+	userHz = 100
+
+	// the minors of the ingress and egress filter blocks of the ingress and clsact qdiscs
+	tcHMinIngress = 0xfff2
+	tcHMinEgress  = 0xfff3
+
+	// tcmIfindexMagicBlock is the interface index of the requests and messages that address a
+	// shared block instead of a qdisc, the parent is the index of the block
+	tcmIfindexMagicBlock = 0xffffffff
+
+	// ethPAll is ETH_P_ALL, the protocol of the filters that match every packet
+	ethPAll = 0x0003
+)
+
+// filterActAttrs is the attribute that holds the actions, per classifier
+var filterActAttrs = map[string]uint16{
+	"basic":    3,
+	"bpf":      1,
+	"cgroup":   1,
+	"flower":   3,
+	"fw":       4,
+	"matchall": 2,
+	"u32":      7,
+}
+
+// filterFlagsAttrs is the attribute that holds the TCA_CLS_FLAGS_*, per classifier
+var filterFlagsAttrs = map[string]uint16{
+	"bpf":      9,
+	"flower":   22,
+	"matchall": 3,
+	"u32":      11,
+}
+
+// actTmAttrs is the attribute that holds the tcf_t timestamps, per action
+var actTmAttrs = map[string]uint16{
+	"bpf":        1,
+	"connmark":   2,
+	"csum":       2,
+	"ct":         2,
+	"gact":       1,
+	"gate":       1,
+	"mirred":     1,
+	"mpls":       1,
+	"nat":        2,
+	"pedit":      1,
+	"police":     6,
+	"sample":     1,
+	"skbedit":    1,
+	"skbmod":     1,
+	"tunnel_key": 1,
+	"vlan":       1,
+}
+
+// rawAction is an action attached to a filter
+type rawAction struct {
+	Kind      string
+	Index     uint32
+	Options   map[uint16][]byte
+	Stats     map[uint16][]byte
+	InHwCount uint32
+}
+
+// rawFilter is a filter as it was received from the kernel, with its options and actions decoded
+// into attributes
+type rawFilter struct {
+	rawObject
+	Chain   uint32
+	Options map[uint16][]byte
+	Actions []rawAction
+}
+
+// newRawFilter decodes the options and actions of a filter
+func newRawFilter(obj rawObject) (rawFilter, error) {
+	fl := rawFilter{rawObject: obj}
+	if chain, ok := obj.Attrs[tcaChain]; ok && len(chain) >= 4 {
+		fl.Chain = binary.NativeEndian.Uint32(chain)
+	}
+	opts, ok := obj.Attrs[tcaOptions]
+	if !ok {
+		return fl, nil
+	}
+	var err error
+	fl.Options, err = parseAttrs(opts)
+	if err != nil {
+		return rawFilter{}, err
+	}
+	if attr, found := filterActAttrs[obj.Kind]; found {
+		if acts, ok := fl.Options[attr]; ok {
+			fl.Actions, err = parseActions(acts)
+			if err != nil {
+				return rawFilter{}, err
+			}
+		}
+	}
+	return fl, nil
+}
+
+// parseActions decodes a list of actions, the entries are ordered by their position in the list
+func parseActions(b []byte) ([]rawAction, error) {
+	entries, err := parseAttrList(b)
+	if err != nil {
+		return nil, err
+	}
+	var actions []rawAction
+	for _, entry := range entries {
+		attrs, err := parseAttrs(entry.Data)
+		if err != nil {
+			return nil, err
+		}
+		act := rawAction{Kind: nullStr(attrs[tcaActKind])}
+		if idx, ok := attrs[tcaActIndex]; ok && len(idx) >= 4 {
+			act.Index = binary.NativeEndian.Uint32(idx)
+		}
+		if count, ok := attrs[tcaActInHwCount]; ok && len(count) >= 4 {
+			act.InHwCount = binary.NativeEndian.Uint32(count)
+		}
+		if opts, ok := attrs[tcaActOptions]; ok {
+			act.Options, err = parseAttrs(opts)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if stats, ok := attrs[tcaActStats]; ok {
+			act.Stats, err = parseAttrs(stats)
+			if err != nil {
+				return nil, err
+			}
+		}
+		actions = append(actions, act)
+	}
+	return actions, nil
+}
+
+// lastUsed returns the time since the action was last used in seconds, ok is false when the
+// kernel does not report the timestamps of the action
+func (act rawAction) lastUsed() (float64, bool) {
+	attr, found := actTmAttrs[act.Kind]
+	if !found {
+		return 0, false
+	}
+	tm, ok := act.Options[attr]
+	if !ok || len(tm) < tcfTLen {
+		return 0, false
+	}
+	// the kernel reports how long ago, in USER_HZ ticks
+	return float64(binary.NativeEndian.Uint64(tm[8:16])) / userHz, true
+}
+
+// prio returns the priority of the filter
+func (fl rawFilter) prio() uint32 {
+	return fl.Info >> 16
+}
+
+// protocol returns the protocol of the filter, the kernel stores it in network byte order
+func (fl rawFilter) protocol() uint16 {
+	b := make([]byte, 2)
+	binary.NativeEndian.PutUint16(b, uint16(fl.Info))
+	return binary.BigEndian.Uint16(b)
+}
+
+// clsFlags returns the TCA_CLS_FLAGS_* of the filter, ok is false for classifiers without flags
+func (fl rawFilter) clsFlags() (uint32, bool) {
+	attr, found := filterFlagsAttrs[fl.Kind]
+	if !found {
+		return 0, false
+	}
+	flags, ok := fl.Options[attr]
+	if !ok || len(flags) < 4 {
+		return 0, false
+	}
+	return binary.NativeEndian.Uint32(flags), true
+}
+
+// protocolName returns the name tc uses for the ethernet protocol
+func protocolName(proto uint16) string {
+	switch proto {
+	case ethPAll:
+		return "all"
+	case 0x0800:
+		return "ip"
+	case 0x86dd:
+		return "ipv6"
+	case 0x0806:
+		return "arp"
+	case 0x8100:
+		return "802.1Q"
+	case 0x88a8:
+		return "802.1ad"
+	case 0x8847:
+		return "mpls_uc"
+	}
+	return fmt.Sprintf("0x%04x", proto)
+}
+
+// splitCounters are the counters of an object split in what the hardware and the software handled
+type splitCounters struct {
+	hwBytes   float64
+	hwPackets float64
+	swBytes   float64
+	swPackets float64
+}
+
+// add adds the gnet stats of an object. The basic stats count all packets, the hardware stats only
+// the ones the hardware handled. ok is false when there are no hardware stats.
+func (s *splitCounters) add(stats map[uint16][]byte) bool {
+	basic, ok := stats[tcaStatsBasic]
+	if !ok || len(basic) < 12 {
+		return false
+	}
+	bytes := float64(binary.NativeEndian.Uint64(basic[0:8]))
+	packets := float64(binary.NativeEndian.Uint32(basic[8:12]))
+	hw, ok := stats[tcaStatsBasicHw]
+	if !ok || len(hw) < 12 {
+		s.swBytes += bytes
+		s.swPackets += packets
+		return false
+	}
+	hwBytes := float64(binary.NativeEndian.Uint64(hw[0:8]))
+	hwPackets := float64(binary.NativeEndian.Uint32(hw[8:12]))
+	s.hwBytes += hwBytes
+	s.hwPackets += hwPackets
+	s.swBytes += max(bytes-hwBytes, 0)
+	s.swPackets += max(packets-hwPackets, 0)
+	return true
+}
+
+// filterParents returns the parents the filters of the interface can be attached to: the qdiscs,
+// the classes and the ingress and egress blocks of the ingress and clsact qdiscs. The blocks that
+// are shared are left out, their filters are exported once per block by CollectBlocks.
+func filterParents(qdiscs, classes []tc.Object) []uint32 {
+	var parents []uint32
+	for _, qd := range qdiscs {
+		switch {
+		case qd.Kind == "ingress" || qd.Kind == "clsact":
+			if sharedBlock(qd.IngressBlock) == 0 {
+				parents = append(parents, tc.HandleIngress&0xffff0000|tcHMinIngress)
+			}
+			if qd.Kind == "clsact" && sharedBlock(qd.EgressBlock) == 0 {
+				parents = append(parents, tc.HandleIngress&0xffff0000|tcHMinEgress)
+			}
+		case qd.Handle != 0:
+			parents = append(parents, qd.Handle)
+		}
+	}
+	for _, cl := range classes {
+		parents = append(parents, cl.Handle)
+	}
+	return parents
+}
+
+// sharedBlock returns the index of the shared block, 0 when the block is not shared
+func sharedBlock(block *uint32) uint32 {
+	if block == nil {
+		return 0
+	}
+	return *block
+}
+
+// blockBinding is an interface a shared block is bound to
+type blockBinding struct {
+	interf    rtnetlink.LinkMessage
+	direction string
+}
+
+// addBlockBindings adds the shared blocks the ingress and clsact qdiscs of the interface are bound
+// to, by block index
+func addBlockBindings(blocks map[uint32][]blockBinding, interf rtnetlink.LinkMessage, qdiscs []tc.Object) {
+	for _, qd := range qdiscs {
+		if qd.Kind != "ingress" && qd.Kind != "clsact" {
+			continue
+		}
+		if block := sharedBlock(qd.IngressBlock); block != 0 {
+			blocks[block] = append(blocks[block], blockBinding{interf: interf, direction: "ingress"})
+		}
+		if block := sharedBlock(qd.EgressBlock); block != 0 {
+			blocks[block] = append(blocks[block], blockBinding{interf: interf, direction: "egress"})
+		}
+	}
+}
+
+// FilterCollector exports the filters attached to the qdiscs and classes of the interface
+type FilterCollector struct {
+	logger    slog.Logger
+	netns     map[string][]rtnetlink.LinkMessage
+	match     MatchConfig
+	info      *prometheus.Desc
+	offloaded *prometheus.Desc
+	bytes     *prometheus.Desc
+	packets   *prometheus.Desc
+	lastUsed  *prometheus.Desc
+	inHwCount *prometheus.Desc
+	skipHw    *prometheus.Desc
+	skipSw    *prometheus.Desc
+	bpfInfo   *prometheus.Desc
+	bpfRuns   *prometheus.Desc
+	bpfTime   *prometheus.Desc
+	chains    *prometheus.Desc
+	blockInfo *prometheus.Desc
+}
+
+// NewFilterCollector create a new FilterCollector given a network interface
+func NewFilterCollector(netns map[string][]rtnetlink.LinkMessage, match MatchConfig, log *slog.Logger) (InterfaceCollector, error) {
+	log = log.With("collector", "filter")
+	log.Info("making filter collector", "match", match.Enabled)
+
+	return &FilterCollector{
+		logger: *log,
+		netns:  netns,
+		match:  match,
+		info: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "filter", "info"),
+			"Filter attached to the interface, with the decoded match keys of u32 and flower filters",
+			filterInfoLabels, nil,
+		),
+		offloaded: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "filter", "offloaded"),
+			"Whether the filter is offloaded to the hardware",
+			filterLabels, nil,
+		),
+		bytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "filter", "bytes"),
+			"Bytes handled by the actions of the filter, by the hardware or the software",
+			filterSourceLabels, nil,
+		),
+		packets: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "filter", "packets"),
+			"Packets handled by the actions of the filter, by the hardware or the software",
+			filterSourceLabels, nil,
+		),
+		lastUsed: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "filter", "last_used_seconds"),
+			"Time since the most recently used action of the filter was last used",
+			filterLabels, nil,
+		),
+		inHwCount: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "flower", "in_hw_count"),
+			"Number of devices the flower filter is offloaded to",
+			filterLabels, nil,
+		),
+		skipHw: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "flower", "skip_hw"),
+			"Whether the flower filter is not offloaded to the hardware (skip_hw)",
+			filterLabels, nil,
+		),
+		skipSw: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "flower", "skip_sw"),
+			"Whether the flower filter only runs in the hardware (skip_sw)",
+			filterLabels, nil,
+		),
+		bpfInfo: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "bpf", "program_info"),
+			"BPF program attached by a bpf classifier or action",
+			bpfInfoLabels, nil,
+		),
+		bpfRuns: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "bpf", "program_run_count"),
+			"Number of runs of the BPF program, only when kernel.bpf_stats_enabled is set",
+			bpfStatsLabels, nil,
+		),
+		bpfTime: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "bpf", "program_run_time_seconds"),
+			"Total run time of the BPF program, only when kernel.bpf_stats_enabled is set",
+			bpfStatsLabels, nil,
+		),
+		chains: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "chain", "filters"),
+			"Number of filters in the chain, chains without filters are exported too",
+			chainLabels, nil,
+		),
+		blockInfo: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "block", "info"),
+			"Shared filter block bound to the ingress or egress of the interface",
+			blockLabels, nil,
+		),
+	}, nil
+}
+
+// Describe implements Collector
+func (col *FilterCollector) Describe(ch chan<- *prometheus.Desc) {
+	ds := []*prometheus.Desc{
+		col.info,
+		col.offloaded,
+		col.bytes,
+		col.packets,
+		col.lastUsed,
+		col.inHwCount,
+		col.skipHw,
+		col.skipSw,
+		col.bpfInfo,
+		col.bpfRuns,
+		col.bpfTime,
+		col.chains,
+		col.blockInfo,
+	}
+
+	for _, d := range ds {
+		ch <- d
+	}
+}
+
+// CollectInterface exports the filters of the qdiscs and classes of the interface
+func (col *FilterCollector) CollectInterface(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, qdiscs, classes []tc.Object) {
+	conn, err := GetRouteConn(ns)
+	if err != nil {
+		col.logger.Error("failed to get filters", "interface", interf.Attributes.Name, "err", err)
+		return
+	}
+	defer conn.Close()
+	// a parent that fails, like a class that was deleted since the qdiscs were dumped, only skips
+	// the filters of that parent
+	for _, parent := range filterParents(qdiscs, classes) {
+		filters, err := getFilters(conn, interf.Index, parent)
+		if err != nil {
+			col.logger.Error("failed to get filters", "interface", interf.Attributes.Name, "parent", parent, "err", err)
+			continue
+		}
+		col.collectFilters(ch, host, ns, interf, filters)
+		col.collectChains(ch, conn, host, ns, interf, interf.Index, parent, filters)
+	}
+}
+
+// CollectBlocks exports the shared blocks of the netns and the interfaces they are bound to. The
+// filters of a block are exported once, without link, instead of for every interface.
+func (col *FilterCollector) CollectBlocks(ch chan<- prometheus.Metric, host, ns string, blocks map[uint32][]blockBinding) {
+	// the bindings are still exported when the filters can not be fetched
+	conn, err := GetRouteConn(ns)
+	if err != nil {
+		col.logger.Error("failed to get filters", "netns", ns, "err", err)
+	} else {
+		defer conn.Close()
+	}
+	for block, bindings := range blocks {
+		col.collectBindings(ch, host, ns, block, bindings)
+		if conn == nil {
+			continue
+		}
+		filters, err := getFilters(conn, tcmIfindexMagicBlock, block)
+		if err != nil {
+			col.logger.Error("failed to get filters", "block", block, "err", err)
+			continue
+		}
+		col.collectFilters(ch, host, ns, rtnetlink.LinkMessage{}, filters)
+		col.collectChains(ch, conn, host, ns, rtnetlink.LinkMessage{}, tcmIfindexMagicBlock, block, filters)
+	}
+}
+
+// collectBindings exports the interfaces the shared block is bound to
+func (col *FilterCollector) collectBindings(ch chan<- prometheus.Metric, host, ns string, block uint32, bindings []blockBinding) {
+	for _, b := range bindings {
+		ch <- prometheus.MustNewConstMetric(
+			col.blockInfo,
+			prometheus.GaugeValue,
+			1,
+			host,
+			ns,
+			fmt.Sprintf("%d", block),
+			fmt.Sprintf("%d", b.interf.Index),
+			b.interf.Attributes.Name,
+			b.direction,
+		)
+	}
+}
+
+// collectFilters exports the filters of an interface or a shared block
+func (col *FilterCollector) collectFilters(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, filters []rawFilter) {
+	bpfStats := bpfStatsEnabled()
+	for _, fl := range filters {
+		// the kernel dumps a message without handle for every priority of some classifiers
+		if fl.Handle == 0 && len(fl.Actions) == 0 {
+			continue
+		}
+		col.collectFilter(ch, host, ns, interf, fl)
+		col.collectBpf(ch, host, ns, interf, fl, bpfStats)
+	}
+}
+
+// collectChains exports the number of filters of every chain of a parent, or of a shared block
+// when devid is tcmIfindexMagicBlock. A parent whose chains can not be fetched is skipped.
+func (col *FilterCollector) collectChains(ch chan<- prometheus.Metric, conn *netlink.Conn, host, ns string, interf rtnetlink.LinkMessage, devid, parent uint32, filters []rawFilter) {
+	chains, err := getChains(conn, devid, parent)
+	if err != nil {
+		col.logger.Error("failed to get filter chains", "devid", devid, "parent", parent, "err", err)
+		return
+	}
+	col.exportChains(ch, host, ns, interf, devid, parent, chains, filters)
+}
+
+// exportChains exports the number of filters of the chains of a parent. The chains that only show
+// up in the filters are exported too.
+func (col *FilterCollector) exportChains(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, devid, parent uint32, chains []uint32, filters []rawFilter) {
+	counts := make(map[uint32]int, len(chains))
+	for _, chain := range chains {
+		counts[chain] = 0
+	}
+	for _, fl := range filters {
+		if fl.Parent != parent || (fl.Handle == 0 && len(fl.Actions) == 0) {
+			continue
+		}
+		counts[fl.Chain]++
+	}
+	linkindex, link, block, parentStr := objectLocation(interf, devid, parent)
+	for chain, count := range counts {
+		ch <- prometheus.MustNewConstMetric(
+			col.chains,
+			prometheus.GaugeValue,
+			float64(count),
+			host,
+			ns,
+			linkindex,
+			link,
+			block,
+			parentStr,
+			fmt.Sprintf("%d", chain),
+		)
+	}
+}
+
+// collectFilter exports the metrics of a single filter
+func (col *FilterCollector) collectFilter(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, fl rawFilter) {
+	labels := filterLabelValues(host, ns, interf, fl)
+
+	var match string
+	if col.match.Enabled {
+		match = decodeMatch(fl, col.match.MaxLength)
+	}
+	ch <- prometheus.MustNewConstMetric(col.info, prometheus.GaugeValue, 1, append(labels, match)...)
+
+	flags, hasFlags := fl.clsFlags()
+	offloaded := 0.0
+	if hasFlags && flags&tcaClsFlagsInHw != 0 {
+		offloaded = 1
+	}
+	ch <- prometheus.MustNewConstMetric(col.offloaded, prometheus.GaugeValue, offloaded, labels...)
+	if fl.Kind == "flower" {
+		col.collectFlower(ch, labels, fl, flags)
+	}
+
+	if len(fl.Actions) == 0 {
+		return
+	}
+	var counters splitCounters
+	var hasHw, hasLastUsed bool
+	var lastUsed float64
+	for _, act := range fl.Actions {
+		if counters.add(act.Stats) {
+			hasHw = true
+		}
+		if age, ok := act.lastUsed(); ok && (!hasLastUsed || age < lastUsed) {
+			lastUsed = age
+			hasLastUsed = true
+		}
+	}
+	if hasLastUsed {
+		ch <- prometheus.MustNewConstMetric(col.lastUsed, prometheus.GaugeValue, lastUsed, labels...)
+	}
+	ch <- prometheus.MustNewConstMetric(col.bytes, prometheus.CounterValue, counters.swBytes, append(labels, "sw")...)
+	ch <- prometheus.MustNewConstMetric(col.packets, prometheus.CounterValue, counters.swPackets, append(labels, "sw")...)
+	if hasHw {
+		ch <- prometheus.MustNewConstMetric(col.bytes, prometheus.CounterValue, counters.hwBytes, append(labels, "hw")...)
+		ch <- prometheus.MustNewConstMetric(col.packets, prometheus.CounterValue, counters.hwPackets, append(labels, "hw")...)
+	}
+}
+
+// collectFlower exports the offload state of a flower filter
+func (col *FilterCollector) collectFlower(ch chan<- prometheus.Metric, labels []string, fl rawFilter, flags uint32) {
+	flag := func(f uint32) float64 {
+		if flags&f != 0 {
+			return 1
+		}
+		return 0
+	}
+	ch <- prometheus.MustNewConstMetric(col.skipHw, prometheus.GaugeValue, flag(tcaClsFlagsSkipHw), labels...)
+	ch <- prometheus.MustNewConstMetric(col.skipSw, prometheus.GaugeValue, flag(tcaClsFlagsSkipSw), labels...)
+	// older kernels do not report the count, only whether the filter is in hardware
+	if count, ok := fl.Options[tcaFlowerInHwCount]; ok && len(count) >= 4 {
+		ch <- prometheus.MustNewConstMetric(col.inHwCount, prometheus.GaugeValue, float64(binary.NativeEndian.Uint32(count)), labels...)
+	}
+}
+
+// collectBpf exports the BPF programs of the filter and its actions
+func (col *FilterCollector) collectBpf(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, fl rawFilter, stats bool) {
+	labels := filterLabelValues(host, ns, interf, fl)
+	for _, prog := range filterBpfPrograms(fl) {
+		id := fmt.Sprintf("%d", prog.id)
+		ch <- prometheus.MustNewConstMetric(
+			col.bpfInfo,
+			prometheus.GaugeValue,
+			1,
+			append(labels, prog.attach, id, prog.name, prog.tag, prog.directAction)...,
+		)
+		if !stats || prog.id == 0 {
+			continue
+		}
+		runCnt, runTimeNs, err := bpfProgStats(prog.id)
+		if err != nil {
+			col.logger.Debug("failed to get bpf program stats", "id", prog.id, "err", err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(col.bpfRuns, prometheus.CounterValue, float64(runCnt), append(labels, prog.attach, id)...)
+		ch <- prometheus.MustNewConstMetric(col.bpfTime, prometheus.CounterValue, float64(runTimeNs)/1e9, append(labels, prog.attach, id)...)
+	}
+}
+
+// objectLocation returns the linkindex, link, block and parent labels of an object of an interface,
+// or of a shared block when devid is tcmIfindexMagicBlock. The objects of a shared block have no
+// link and no parent.
+func objectLocation(interf rtnetlink.LinkMessage, devid, parent uint32) (string, string, string, string) {
+	if devid == tcmIfindexMagicBlock {
+		return "", "", fmt.Sprintf("%d", parent), ""
+	}
+	parentMaj, parentMin := HandleStr(parent)
+	return fmt.Sprintf("%d", interf.Index), interf.Attributes.Name, "", fmt.Sprintf("%x:%x", parentMaj, parentMin)
+}
+
+// filterLabelValues returns the values of the filterLabels of the filter
+func filterLabelValues(host, ns string, interf rtnetlink.LinkMessage, fl rawFilter) []string {
+	linkindex, link, block, parent := objectLocation(interf, fl.Ifindex, fl.Parent)
+	return []string{
+		host,
+		ns,
+		linkindex,
+		link,
+		block,
+		fl.Kind,
+		parent,
+		fmt.Sprintf("%d", fl.Chain),
+		fmt.Sprintf("%d", fl.prio()),
+		protocolName(fl.protocol()),
+		filterHandle(fl),
+	}
+}
+
+// filterHandle returns the handle of the filter like tc shows it. u32 handles are split in the hash
+// table, the bucket and the node, like 800::800, other handles are in hex.
+func filterHandle(fl rawFilter) string {
+	if fl.Kind != "u32" || fl.Handle == 0 {
+		return fmt.Sprintf("%x", fl.Handle)
+	}
+	var handle string
+	if htid := fl.Handle >> 20; htid != 0 {
+		handle = fmt.Sprintf("%x:", htid)
+	}
+	if hash := (fl.Handle >> 12) & 0xff; hash != 0 {
+		handle += fmt.Sprintf("%x", hash)
+	}
+	if node := fl.Handle & 0xfff; node != 0 {
+		handle += fmt.Sprintf(":%x", node)
+	}
+	return handle
+}
@@ -0,0 +1,681 @@
+package tccollector_test
+
+import (
+	"encoding/binary"
+	"log/slog"
+	"os"
+	"slices"
+	"testing"
+
+	tcexporter "github.com/fbegyn/tc_exporter/collector"
+	"github.com/florianl/go-tc"
+	"github.com/florianl/go-tc/core"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/mdlayher/netlink"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// gnetBasic encodes a struct gnet_stats_basic
+func gnetBasic(bytes uint64, packets uint32) []byte {
+	b := make([]byte, 16)
+	binary.NativeEndian.PutUint64(b[0:], bytes)
+	binary.NativeEndian.PutUint32(b[8:], packets)
+	return b
+}
+
+// filterInfo returns the tcm_info of a filter, the prio is in the upper half and the protocol in
+// network byte order in the lower half
+func filterInfo(prio uint32, proto uint16) uint32 {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, proto)
+	return prio<<16 | uint32(binary.NativeEndian.Uint16(b))
+}
+
+// u32s encodes the values as native endian u32 fields
+func u32s(vs ...uint32) []byte {
+	b := make([]byte, 4*len(vs))
+	for i, v := range vs {
+		binary.NativeEndian.PutUint32(b[i*4:], v)
+	}
+	return b
+}
+
+// tcfT encodes a struct tcf_t with the time since the last use in USER_HZ ticks
+func tcfT(lastUse uint64) []byte {
+	b := make([]byte, 32)
+	binary.NativeEndian.PutUint64(b[8:], lastUse)
+	return b
+}
+
+// testAction is an action to encode in an action list
+type testAction struct {
+	kind      string
+	index     uint32
+	inHwCount uint32
+	options   func(ae *netlink.AttributeEncoder)
+	basic     []byte
+	basicHw   []byte
+	queue     []byte
+}
+
+// encodeActions encodes a list of actions like the kernel nests them in the filter options
+func encodeActions(t *testing.T, acts ...testAction) []byte {
+	t.Helper()
+	ae := netlink.NewAttributeEncoder()
+	for i, act := range acts {
+		ae.Nested(uint16(i+1), func(a *netlink.AttributeEncoder) error {
+			a.String(1, act.kind)
+			if act.options != nil {
+				a.Nested(2, func(o *netlink.AttributeEncoder) error {
+					act.options(o)
+					return nil
+				})
+			}
+			if act.index > 0 {
+				a.Uint32(3, act.index)
+			}
+			a.Nested(4, func(s *netlink.AttributeEncoder) error {
+				if act.basic != nil {
+					s.Bytes(1, act.basic)
+				}
+				if act.basicHw != nil {
+					s.Bytes(7, act.basicHw)
+				}
+				if act.queue != nil {
+					s.Bytes(3, act.queue)
+				}
+				return nil
+			})
+			if act.inHwCount > 0 {
+				a.Uint32(10, act.inHwCount)
+			}
+			return nil
+		})
+	}
+	b, err := ae.Encode()
+	if err != nil {
+		t.Fatalf("failed to encode actions: %v", err)
+	}
+	return b
+}
+
+func TestSplitCounters(t *testing.T) {
+	tests := []struct {
+		name      string
+		stats     []map[uint16][]byte
+		hwBytes   float64
+		hwPackets float64
+		swBytes   float64
+		swPackets float64
+		ok        bool
+	}{
+		{
+			name:  "no stats",
+			stats: []map[uint16][]byte{{}},
+		},
+		{
+			name:      "software only",
+			stats:     []map[uint16][]byte{{1: gnetBasic(1500, 10)}},
+			swBytes:   1500,
+			swPackets: 10,
+		},
+		{
+			name:      "hardware and software",
+			stats:     []map[uint16][]byte{{1: gnetBasic(1500, 10), 7: gnetBasic(1000, 6)}},
+			hwBytes:   1000,
+			hwPackets: 6,
+			swBytes:   500,
+			swPackets: 4,
+			ok:        true,
+		},
+		{
+			// the hardware counters can be ahead of the basic ones, the software part is never
+			// negative
+			name:      "hardware ahead",
+			stats:     []map[uint16][]byte{{1: gnetBasic(100, 1), 7: gnetBasic(200, 2)}},
+			hwBytes:   200,
+			hwPackets: 2,
+			ok:        true,
+		},
+		{
+			name: "summed",
+			stats: []map[uint16][]byte{
+				{1: gnetBasic(1500, 10), 7: gnetBasic(1000, 6)},
+				{1: gnetBasic(300, 3), 7: gnetBasic(100, 1)},
+			},
+			hwBytes:   1100,
+			hwPackets: 7,
+			swBytes:   700,
+			swPackets: 6,
+			ok:        true,
+		},
+		{
+			name:  "basic too short",
+			stats: []map[uint16][]byte{{1: {1, 2, 3}, 7: gnetBasic(1000, 6)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hwBytes, hwPackets, swBytes, swPackets, ok := tcexporter.SplitCounters(tt.stats...)
+			if ok != tt.ok {
+				t.Fatalf("unexpected ok: got %t, want %t", ok, tt.ok)
+			}
+			got := []float64{hwBytes, hwPackets, swBytes, swPackets}
+			want := []float64{tt.hwBytes, tt.hwPackets, tt.swBytes, tt.swPackets}
+			if !slices.Equal(got, want) {
+				t.Fatalf("unexpected counters (hw bytes, hw packets, sw bytes, sw packets): got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestParseActions(t *testing.T) {
+	b := encodeActions(t,
+		testAction{
+			kind:  "gact",
+			index: 3,
+			options: func(ae *netlink.AttributeEncoder) {
+				ae.Bytes(1, tcfT(250))
+				ae.Bytes(2, make([]byte, 24))
+			},
+			basic: gnetBasic(1500, 10),
+		},
+		testAction{
+			kind:      "mirred",
+			index:     7,
+			inHwCount: 2,
+			options: func(ae *netlink.AttributeEncoder) {
+				ae.Bytes(1, tcfT(100))
+				ae.Bytes(2, make([]byte, 28))
+			},
+			basic:   gnetBasic(3000, 20),
+			basicHw: gnetBasic(2000, 12),
+		},
+		// a kind without known timestamps
+		testAction{kind: "simple", index: 1},
+	)
+	acts, err := tcexporter.ParseActions(b)
+	if err != nil {
+		t.Fatalf("failed to parse actions: %v", err)
+	}
+	want := []tcexporter.TestAction{
+		{Kind: "gact", Index: 3, Options: []uint16{1, 2}, LastUsed: 2.5},
+		{Kind: "mirred", Index: 7, InHwCount: 2, Options: []uint16{1, 2}, LastUsed: 1},
+		{Kind: "simple", Index: 1},
+	}
+	if len(acts) != len(want) {
+		t.Fatalf("unexpected number of actions: got %d, want %d", len(acts), len(want))
+	}
+	for i := range want {
+		if acts[i].Kind != want[i].Kind || acts[i].Index != want[i].Index || acts[i].InHwCount != want[i].InHwCount ||
+			!slices.Equal(acts[i].Options, want[i].Options) || acts[i].LastUsed != want[i].LastUsed {
+			t.Fatalf("unexpected action %d: got %+v, want %+v", i, acts[i], want[i])
+		}
+	}
+
+	if _, err := tcexporter.ParseActions([]byte{8, 0, 1}); err == nil {
+		t.Fatalf("expected an error for a truncated action list")
+	}
+}
+
+func TestNewRawFilter(t *testing.T) {
+	encode := func(f func(ae *netlink.AttributeEncoder)) []byte {
+		ae := netlink.NewAttributeEncoder()
+		f(ae)
+		b, err := ae.Encode()
+		if err != nil {
+			t.Fatalf("failed to encode options: %v", err)
+		}
+		return b
+	}
+	chain := func(c uint32) map[uint16][]byte {
+		b := make([]byte, 4)
+		binary.NativeEndian.PutUint32(b, c)
+		return map[uint16][]byte{11: b}
+	}
+	gact := testAction{kind: "gact", index: 1, basic: gnetBasic(100, 1)}
+
+	tests := []struct {
+		name    string
+		obj     tcexporter.TestRawObject
+		want    tcexporter.TestFilter
+		actions []string
+		err     bool
+	}{
+		{
+			name: "matchall",
+			obj: tcexporter.TestRawObject{
+				Handle: 1, Info: filterInfo(49152, 0x0003), Kind: "matchall",
+				Options: encode(func(ae *netlink.AttributeEncoder) {
+					ae.Bytes(2, encodeActions(t, gact, testAction{kind: "mirred", index: 2}))
+					ae.Uint32(3, 1)
+				}),
+			},
+			want:    tcexporter.TestFilter{Prio: 49152, Protocol: "all", Options: []uint16{2, 3}},
+			actions: []string{"gact", "mirred"},
+		},
+		{
+			name: "u32 in a chain",
+			obj: tcexporter.TestRawObject{
+				Handle: 0x80000800, Info: filterInfo(1, 0x0800), Kind: "u32", Attrs: chain(5),
+				Options: encode(func(ae *netlink.AttributeEncoder) {
+					ae.Uint32(2, 0x80000000)
+					ae.Bytes(7, encodeActions(t, gact))
+				}),
+			},
+			want:    tcexporter.TestFilter{Chain: 5, Prio: 1, Protocol: "ip", Options: []uint16{2, 7}},
+			actions: []string{"gact"},
+		},
+		{
+			// the actions of a classifier without an action attribute are not decoded
+			name: "route",
+			obj: tcexporter.TestRawObject{
+				Handle: 1, Info: filterInfo(2, 0x86dd), Kind: "route",
+				Options: encode(func(ae *netlink.AttributeEncoder) {
+					ae.Uint32(2, 10)
+				}),
+			},
+			want: tcexporter.TestFilter{Prio: 2, Protocol: "ipv6", Options: []uint16{2}},
+		},
+		{
+			name: "no options",
+			obj:  tcexporter.TestRawObject{Info: filterInfo(3, 0x8100), Kind: "flower"},
+			want: tcexporter.TestFilter{Prio: 3, Protocol: "802.1Q"},
+		},
+		{
+			name: "invalid options",
+			obj:  tcexporter.TestRawObject{Info: filterInfo(3, 0x0800), Kind: "flower", Options: []byte{8, 0, 1}},
+			err:  true,
+		},
+		{
+			name: "invalid actions",
+			obj: tcexporter.TestRawObject{
+				Info: filterInfo(3, 0x0800), Kind: "flower",
+				Options: encode(func(ae *netlink.AttributeEncoder) {
+					ae.Bytes(3, []byte{8, 0, 1})
+				}),
+			},
+			err: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fl, err := tcexporter.NewRawFilter(2, tt.obj)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", fl)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to decode filter: %v", err)
+			}
+			if fl.Chain != tt.want.Chain || fl.Prio != tt.want.Prio || fl.Protocol != tt.want.Protocol || !slices.Equal(fl.Options, tt.want.Options) {
+				t.Fatalf("unexpected filter: got %+v, want %+v", fl, tt.want)
+			}
+			var kinds []string
+			for _, act := range fl.Actions {
+				kinds = append(kinds, act.Kind)
+			}
+			if !slices.Equal(kinds, tt.actions) {
+				t.Fatalf("unexpected actions: got %v, want %v", kinds, tt.actions)
+			}
+		})
+	}
+}
+
+func TestFilterCollectorFlower(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	col, err := tcexporter.NewFilterCollector(nil, tcexporter.MatchConfig{}, logger)
+	if err != nil {
+		t.Fatalf("failed to create filter collector: %v", err)
+	}
+	interf := rtnetlink.LinkMessage{Index: 2, Attributes: &rtnetlink.LinkAttributes{Name: "eth0"}}
+
+	tests := []struct {
+		name      string
+		kind      string
+		options   func(ae *netlink.AttributeEncoder)
+		offloaded float64
+		skipHw    float64
+		skipSw    float64
+		inHwCount float64
+		hasCount  bool
+		flower    bool
+	}{
+		{
+			name: "skip_sw offloaded to two devices",
+			kind: "flower",
+			options: func(ae *netlink.AttributeEncoder) {
+				ae.Uint32(22, 2|4)
+				ae.Uint32(86, 2)
+			},
+			offloaded: 1,
+			skipSw:    1,
+			inHwCount: 2,
+			hasCount:  true,
+			flower:    true,
+		},
+		{
+			name: "skip_hw",
+			kind: "flower",
+			options: func(ae *netlink.AttributeEncoder) {
+				ae.Uint32(22, 1)
+				ae.Uint32(86, 0)
+			},
+			skipHw:   1,
+			hasCount: true,
+			flower:   true,
+		},
+		{
+			// older kernels only report whether the filter is in hardware
+			name: "in hardware without count",
+			kind: "flower",
+			options: func(ae *netlink.AttributeEncoder) {
+				ae.Uint32(22, 4)
+			},
+			offloaded: 1,
+			flower:    true,
+		},
+		{
+			name: "no flags",
+			kind: "flower",
+			options: func(ae *netlink.AttributeEncoder) {
+				ae.Uint16(8, 0x0800)
+			},
+			flower: true,
+		},
+		{
+			name: "in hardware matchall",
+			kind: "matchall",
+			options: func(ae *netlink.AttributeEncoder) {
+				ae.Uint32(3, 2|4)
+			},
+			offloaded: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ae := netlink.NewAttributeEncoder()
+			tt.options(ae)
+			options, err := ae.Encode()
+			if err != nil {
+				t.Fatalf("failed to encode options: %v", err)
+			}
+			obj := tcexporter.TestRawObject{Handle: 1, Parent: 0xfffffff2, Info: filterInfo(1, 0x0800), Kind: tt.kind, Options: options}
+			metrics := collectMetrics(t, func(ch chan<- prometheus.Metric) {
+				if err := tcexporter.CollectFilters(col, ch, "host", "default", interf, interf.Index, obj); err != nil {
+					t.Errorf("failed to decode filter: %v", err)
+				}
+			})
+			labels := map[string]string{"link": "eth0", "type": tt.kind, "parent": "ffff:fff2", "handle": "1", "protocol": "ip"}
+			if got, ok := findMetric(metrics, "tc_filter_offloaded", labels); !ok || got != tt.offloaded {
+				t.Fatalf("unexpected offloaded: got %v (%t), want %v", got, ok, tt.offloaded)
+			}
+			got, ok := findMetric(metrics, "tc_flower_skip_hw", labels)
+			if ok != tt.flower || got != tt.skipHw {
+				t.Fatalf("unexpected skip_hw: got %v (%t), want %v (%t)", got, ok, tt.skipHw, tt.flower)
+			}
+			got, ok = findMetric(metrics, "tc_flower_skip_sw", labels)
+			if ok != tt.flower || got != tt.skipSw {
+				t.Fatalf("unexpected skip_sw: got %v (%t), want %v (%t)", got, ok, tt.skipSw, tt.flower)
+			}
+			got, ok = findMetric(metrics, "tc_flower_in_hw_count", labels)
+			if ok != tt.hasCount || got != tt.inHwCount {
+				t.Fatalf("unexpected in_hw_count: got %v (%t), want %v (%t)", got, ok, tt.inHwCount, tt.hasCount)
+			}
+		})
+	}
+}
+
+func TestFilterParents(t *testing.T) {
+	block := func(b uint32) *uint32 { return &b }
+	htb := tc.Object{Msg: tc.Msg{Handle: core.BuildHandle(1, 0), Parent: tc.HandleRoot}, Attribute: tc.Attribute{Kind: "htb"}}
+	class := tc.Object{Msg: tc.Msg{Handle: core.BuildHandle(1, 0x10), Parent: core.BuildHandle(1, 0)}, Attribute: tc.Attribute{Kind: "htb"}}
+	clsact := func(ingress, egress *uint32) tc.Object {
+		return tc.Object{
+			Msg:       tc.Msg{Handle: core.BuildHandle(0xffff, 0), Parent: tc.HandleIngress},
+			Attribute: tc.Attribute{Kind: "clsact", IngressBlock: ingress, EgressBlock: egress},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		qdiscs  []tc.Object
+		classes []tc.Object
+		want    []uint32
+	}{
+		{
+			name:    "qdiscs and classes",
+			qdiscs:  []tc.Object{htb},
+			classes: []tc.Object{class},
+			want:    []uint32{0x10000, 0x10010},
+		},
+		{
+			name:   "clsact",
+			qdiscs: []tc.Object{clsact(nil, nil)},
+			want:   []uint32{0xfffffff2, 0xfffffff3},
+		},
+		{
+			// the filters of the shared block are exported once for the block
+			name:   "clsact with a shared ingress block",
+			qdiscs: []tc.Object{htb, clsact(block(5), block(0))},
+			want:   []uint32{0x10000, 0xfffffff3},
+		},
+		{
+			name:   "clsact with shared blocks",
+			qdiscs: []tc.Object{clsact(block(5), block(7))},
+		},
+		{
+			name: "ingress",
+			qdiscs: []tc.Object{{
+				Msg:       tc.Msg{Handle: core.BuildHandle(0xffff, 0), Parent: tc.HandleIngress},
+				Attribute: tc.Attribute{Kind: "ingress"},
+			}},
+			want: []uint32{0xfffffff2},
+		},
+		{
+			name:   "default qdisc without handle",
+			qdiscs: []tc.Object{{Msg: tc.Msg{Parent: tc.HandleRoot}, Attribute: tc.Attribute{Kind: "noqueue"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tcexporter.FilterParents(tt.qdiscs, tt.classes)
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("unexpected parents: got %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterCollectorBlocks(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	col, err := tcexporter.NewFilterCollector(nil, tcexporter.MatchConfig{}, logger)
+	if err != nil {
+		t.Fatalf("failed to create filter collector: %v", err)
+	}
+	block := func(b uint32) *uint32 { return &b }
+	link := func(index uint32, name string) rtnetlink.LinkMessage {
+		return rtnetlink.LinkMessage{Index: index, Attributes: &rtnetlink.LinkAttributes{Name: name}}
+	}
+	clsact := func(ingress, egress *uint32) tc.Object {
+		return tc.Object{
+			Msg:       tc.Msg{Handle: core.BuildHandle(0xffff, 0), Parent: tc.HandleIngress},
+			Attribute: tc.Attribute{Kind: "clsact", IngressBlock: ingress, EgressBlock: egress},
+		}
+	}
+	htb := tc.Object{Msg: tc.Msg{Handle: core.BuildHandle(1, 0), Parent: tc.HandleRoot}, Attribute: tc.Attribute{Kind: "htb"}}
+
+	t.Run("bindings", func(t *testing.T) {
+		links := []rtnetlink.LinkMessage{link(2, "eth0"), link(3, "eth1"), link(4, "eth2")}
+		qdiscs := [][]tc.Object{
+			{htb, clsact(block(5), nil)},
+			{clsact(block(5), block(7))},
+			{clsact(nil, nil)},
+		}
+		metrics := collectMetrics(t, func(ch chan<- prometheus.Metric) {
+			tcexporter.CollectBlockBindings(col, ch, "host", "default", links, qdiscs)
+		})
+		want := []map[string]string{
+			{"block": "5", "linkindex": "2", "link": "eth0", "direction": "ingress"},
+			{"block": "5", "linkindex": "3", "link": "eth1", "direction": "ingress"},
+			{"block": "7", "linkindex": "3", "link": "eth1", "direction": "egress"},
+		}
+		if len(metrics) != len(want) {
+			t.Fatalf("unexpected number of bindings: got %d, want %d", len(metrics), len(want))
+		}
+		for _, labels := range want {
+			if _, ok := findMetric(metrics, "tc_block_info", labels); !ok {
+				t.Fatalf("missing binding %v", labels)
+			}
+		}
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		// the filters of a shared block are dumped with the magic ifindex and the block index as
+		// parent
+		objs := []tcexporter.TestRawObject{
+			{Handle: 1, Parent: 5, Info: filterInfo(1, 0x0800), Kind: "flower"},
+			{Handle: 2, Parent: 5, Info: filterInfo(2, 0x0003), Kind: "matchall", Attrs: map[uint16][]byte{11: u32s(1)}},
+		}
+		metrics := collectMetrics(t, func(ch chan<- prometheus.Metric) {
+			if err := tcexporter.CollectFilters(col, ch, "host", "default", rtnetlink.LinkMessage{}, 0xffffffff, objs...); err != nil {
+				t.Errorf("failed to decode filters: %v", err)
+			}
+			if err := tcexporter.CollectChains(col, ch, "host", "default", rtnetlink.LinkMessage{}, 0xffffffff, 5, []uint32{0, 1, 2}, objs...); err != nil {
+				t.Errorf("failed to decode filters: %v", err)
+			}
+		})
+		for _, labels := range []map[string]string{
+			{"block": "5", "linkindex": "", "link": "", "parent": "", "chain": "0", "type": "flower", "handle": "1"},
+			{"block": "5", "linkindex": "", "link": "", "parent": "", "chain": "1", "type": "matchall", "handle": "2"},
+		} {
+			if _, ok := findMetric(metrics, "tc_filter_info", labels); !ok {
+				t.Fatalf("missing filter %v: %v", labels, metrics)
+			}
+		}
+		for chain, want := range map[string]float64{"0": 1, "1": 1, "2": 0} {
+			labels := map[string]string{"block": "5", "linkindex": "", "link": "", "parent": "", "chain": chain}
+			if got, ok := findMetric(metrics, "tc_chain_filters", labels); !ok || got != want {
+				t.Fatalf("unexpected filters in chain %s: got %v (%t), want %v", chain, got, ok, want)
+			}
+		}
+	})
+}
+
+func TestFilterCollectorChains(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	col, err := tcexporter.NewFilterCollector(nil, tcexporter.MatchConfig{}, logger)
+	if err != nil {
+		t.Fatalf("failed to create filter collector: %v", err)
+	}
+	interf := rtnetlink.LinkMessage{Index: 2, Attributes: &rtnetlink.LinkAttributes{Name: "eth0"}}
+	chain := func(c uint32) map[uint16][]byte {
+		return map[uint16][]byte{11: u32s(c)}
+	}
+	parent := core.BuildHandle(1, 0)
+
+	tests := []struct {
+		name   string
+		chains []uint32
+		objs   []tcexporter.TestRawObject
+		want   map[string]float64
+	}{
+		{
+			name:   "chains without filters",
+			chains: []uint32{0, 3},
+			want:   map[string]float64{"0": 0, "3": 0},
+		},
+		{
+			name:   "filters per chain",
+			chains: []uint32{0, 3},
+			objs: []tcexporter.TestRawObject{
+				{Handle: 1, Parent: parent, Info: filterInfo(1, 0x0800), Kind: "flower"},
+				{Handle: 2, Parent: parent, Info: filterInfo(1, 0x0800), Kind: "flower", Attrs: chain(3)},
+				{Handle: 3, Parent: parent, Info: filterInfo(2, 0x0800), Kind: "flower", Attrs: chain(3)},
+			},
+			want: map[string]float64{"0": 1, "3": 2},
+		},
+		{
+			// the kernel dumps a message without handle for every priority of some classifiers
+			name:   "priorities without handle",
+			chains: []uint32{0},
+			objs: []tcexporter.TestRawObject{
+				{Parent: parent, Info: filterInfo(1, 0x0800), Kind: "u32"},
+				{Handle: 0x80000800, Parent: parent, Info: filterInfo(1, 0x0800), Kind: "u32"},
+			},
+			want: map[string]float64{"0": 1},
+		},
+		{
+			name:   "filters of another parent",
+			chains: []uint32{0},
+			objs: []tcexporter.TestRawObject{
+				{Handle: 1, Parent: core.BuildHandle(1, 0x10), Info: filterInfo(1, 0x0800), Kind: "flower"},
+			},
+			want: map[string]float64{"0": 0},
+		},
+		{
+			// a chain created after the chains were dumped
+			name:   "chain missing from the chain dump",
+			chains: []uint32{0},
+			objs: []tcexporter.TestRawObject{
+				{Handle: 1, Parent: parent, Info: filterInfo(1, 0x0800), Kind: "flower", Attrs: chain(4)},
+			},
+			want: map[string]float64{"0": 0, "4": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics := collectMetrics(t, func(ch chan<- prometheus.Metric) {
+				if err := tcexporter.CollectChains(col, ch, "host", "default", interf, interf.Index, parent, tt.chains, tt.objs...); err != nil {
+					t.Errorf("failed to decode filters: %v", err)
+				}
+			})
+			if len(metrics) != len(tt.want) {
+				t.Fatalf("unexpected number of chains: got %d, want %d", len(metrics), len(tt.want))
+			}
+			for chain, want := range tt.want {
+				labels := map[string]string{"link": "eth0", "block": "", "parent": "1:0", "chain": chain}
+				if got, ok := findMetric(metrics, "tc_chain_filters", labels); !ok || got != want {
+					t.Fatalf("unexpected filters in chain %s: got %v (%t), want %v", chain, got, ok, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFilterCollectorHandles(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	col, err := tcexporter.NewFilterCollector(nil, tcexporter.MatchConfig{}, logger)
+	if err != nil {
+		t.Fatalf("failed to create filter collector: %v", err)
+	}
+	interf := rtnetlink.LinkMessage{Index: 2, Attributes: &rtnetlink.LinkAttributes{Name: "eth0"}}
+
+	tests := []struct {
+		name   string
+		kind   string
+		handle uint32
+		want   string
+	}{
+		{name: "u32 node", kind: "u32", handle: 0x80000800, want: "800::800"},
+		{name: "u32 hash table", kind: "u32", handle: 0x80000000, want: "800:"},
+		{name: "u32 bucket", kind: "u32", handle: 0x10001801, want: "100:1:801"},
+		{name: "u32 without hash table", kind: "u32", handle: 0x00000800, want: ":800"},
+		{name: "flower", kind: "flower", handle: 0x1a, want: "1a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := tcexporter.TestRawObject{Handle: tt.handle, Parent: 0xfffffff2, Info: filterInfo(1, 0x0800), Kind: tt.kind}
+			metrics := collectMetrics(t, func(ch chan<- prometheus.Metric) {
+				if err := tcexporter.CollectFilters(col, ch, "host", "default", interf, interf.Index, obj); err != nil {
+					t.Errorf("failed to decode filter: %v", err)
+				}
+			})
+			if _, ok := findMetric(metrics, "tc_filter_info", map[string]string{"type": tt.kind, "handle": tt.want}); !ok {
+				t.Fatalf("missing filter with handle %s: %v", tt.want, metrics)
+			}
+		})
+	}
+}
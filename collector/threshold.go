@@ -0,0 +1,99 @@
+package tccollector
+
+import (
+	"fmt"
+	"path"
+)
+
+// Threshold configures a backlog and/or queue length threshold for the qdiscs and classes matching
+// the selector. An empty selector field matches everything, so a threshold with only a link applies
+// to every object of that interface. The depth sampler counts the time the objects spend above the
+// thresholds.
+type Threshold struct {
+	NetNS   string `mapstructure:"netns"`
+	Link    string `mapstructure:"link"`
+	Kind    string `mapstructure:"kind"`
+	Handle  string `mapstructure:"handle"`
+	Backlog uint32 `mapstructure:"backlog"`
+	Qlen    uint32 `mapstructure:"qlen"`
+}
+
+// thresholdKey identifies a counter of the time spent above a threshold
+type thresholdKey struct {
+	metric string
+	value  uint32
+}
+
+// threshold is a Threshold with the selector parsed
+type threshold struct {
+	netns     string
+	link      string
+	kind      string
+	anyHandle bool
+	handle    uint32
+	backlog   uint32
+	qlen      uint32
+}
+
+// compileThresholds validates the configured thresholds
+func compileThresholds(cfg []Threshold) ([]threshold, error) {
+	var ths []threshold
+	for _, c := range cfg {
+		if c.Backlog == 0 && c.Qlen == 0 {
+			return nil, fmt.Errorf("threshold for %q has neither a backlog nor a qlen", c.Link)
+		}
+		if _, err := path.Match(c.Link, ""); err != nil {
+			return nil, fmt.Errorf("invalid link pattern %q: %w", c.Link, err)
+		}
+		th := threshold{
+			netns:     c.NetNS,
+			link:      c.Link,
+			kind:      c.Kind,
+			anyHandle: c.Handle == "",
+			backlog:   c.Backlog,
+			qlen:      c.Qlen,
+		}
+		if !th.anyHandle {
+			handle, err := ParseHandle(c.Handle)
+			if err != nil {
+				return nil, err
+			}
+			th.handle = handle
+		}
+		ths = append(ths, th)
+	}
+	return ths, nil
+}
+
+// matches checks if the object is selected by the threshold
+func (th threshold) matches(ns, link, kind string, handle uint32) bool {
+	if th.netns != "" && th.netns != ns {
+		return false
+	}
+	if th.kind != "" && th.kind != kind {
+		return false
+	}
+	if th.link != "" {
+		if ok, _ := path.Match(th.link, link); !ok {
+			return false
+		}
+	}
+	return th.anyHandle || th.handle == handle
+}
+
+// matchThresholds returns the counters that have to be kept for the object, all starting at zero
+func matchThresholds(ths []threshold, ns, link, kind string, handle uint32) map[thresholdKey]float64 {
+	above := make(map[thresholdKey]float64)
+	for _, th := range ths {
+		if !th.matches(ns, link, kind, handle) {
+			continue
+		}
+		if th.backlog > 0 {
+			above[thresholdKey{"backlog", th.backlog}] = 0
+		}
+		if th.qlen > 0 {
+			above[thresholdKey{"qlen", th.qlen}] = 0
+		}
+	}
+	return above
+}
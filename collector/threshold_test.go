@@ -0,0 +1,69 @@
+package tccollector_test
+
+import (
+	"slices"
+	"testing"
+
+	tcexporter "github.com/fbegyn/tc_exporter/collector"
+	"github.com/florianl/go-tc/core"
+)
+
+func TestThresholdsMatch(t *testing.T) {
+	cfg := []tcexporter.Threshold{
+		{Link: "eth*", Backlog: 15000},
+		{Link: "eth0", Handle: "1:10", Qlen: 100},
+		{Kind: "fq_codel", Backlog: 3000, Qlen: 50},
+		{NetNS: "customers", Backlog: 1000},
+	}
+
+	tests := []struct {
+		name   string
+		ns     string
+		link   string
+		kind   string
+		handle uint32
+		want   []string
+	}{
+		{name: "link glob", ns: "default", link: "eth1", kind: "htb", handle: core.BuildHandle(1, 20),
+			want: []string{"backlog 15000"}},
+		{name: "link and handle", ns: "default", link: "eth0", kind: "htb", handle: core.BuildHandle(1, 0x10),
+			want: []string{"backlog 15000", "qlen 100"}},
+		{name: "kind", ns: "default", link: "wlan0", kind: "fq_codel", handle: core.BuildHandle(2, 0),
+			want: []string{"backlog 3000", "qlen 50"}},
+		{name: "kind and link", ns: "default", link: "eth0", kind: "fq_codel", handle: core.BuildHandle(2, 0),
+			want: []string{"backlog 15000", "backlog 3000", "qlen 50"}},
+		{name: "netns", ns: "customers", link: "wlan0", kind: "htb", handle: core.BuildHandle(1, 0),
+			want: []string{"backlog 1000"}},
+		{name: "nothing", ns: "default", link: "wlan0", kind: "htb", handle: core.BuildHandle(1, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tcexporter.MatchThresholds(cfg, tt.ns, tt.link, tt.kind, tt.handle)
+			if err != nil {
+				t.Fatalf("failed to compile thresholds: %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("unexpected thresholds: got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestThresholdsInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  tcexporter.Threshold
+	}{
+		{"no threshold", tcexporter.Threshold{Link: "eth0"}},
+		{"bad link pattern", tcexporter.Threshold{Link: "eth[", Backlog: 1000}},
+		{"bad handle", tcexporter.Threshold{Handle: "1-10", Backlog: 1000}},
+		{"handle out of range", tcexporter.Threshold{Handle: "10000:1", Qlen: 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tcexporter.MatchThresholds([]tcexporter.Threshold{tt.cfg}, "default", "eth0", "htb", 0); err == nil {
+				t.Fatalf("expected an error for %+v", tt.cfg)
+			}
+		})
+	}
+}
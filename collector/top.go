@@ -0,0 +1,128 @@
+package tccollector
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/florianl/go-tc"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TopConfig configures the top-N mode of the classes
+type TopConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// N is the number of classes exported per interface
+	N int `mapstructure:"n"`
+	// By is what the classes are ranked on: bytes (byte rate), drops (drop rate) or backlog
+	By string `mapstructure:"by"`
+}
+
+// TopSelector selects the busiest classes of an interface. The byte and drop rates are calculated
+// from the previous scrape.
+type TopSelector struct {
+	logger slog.Logger
+	n      int
+	by     string
+	deltas *deltaTracker
+	rank   *prometheus.Desc
+}
+
+// NewTopSelector creates a TopSelector for the configuration
+func NewTopSelector(cfg TopConfig, log *slog.Logger) (*TopSelector, error) {
+	log = log.With("collector", "top")
+	ts := &TopSelector{
+		n:      cfg.N,
+		by:     cfg.By,
+		deltas: newDeltaTracker(),
+	}
+	if ts.n <= 0 {
+		ts.n = 10
+	}
+	switch ts.by {
+	case "":
+		ts.by = "bytes"
+	case "bytes", "drops", "backlog":
+	default:
+		return nil, fmt.Errorf("invalid top ranking %q", cfg.By)
+	}
+	log.Info("making top class selector", "n", ts.n, "by", ts.by)
+	ts.logger = *log
+	ts.rank = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "class", "rank"),
+		fmt.Sprintf("Rank of the class on the interface by %s, 1 is the busiest", ts.by),
+		classlabels, nil,
+	)
+	return ts, nil
+}
+
+// Describe implements Collector
+func (ts *TopSelector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- ts.rank
+}
+
+// Select returns the N busiest classes, busiest first, and exports their rank. The rates of all
+// classes are updated, so every class can enter the top at the next scrape.
+func (ts *TopSelector) Select(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, classes []tc.Object) []tc.Object {
+	type ranked struct {
+		cl    tc.Object
+		score float64
+	}
+	now := time.Now()
+	scores := make([]ranked, 0, len(classes))
+	for _, cl := range classes {
+		var score float64
+		delta, ok := ts.deltas.update(ns, interf.Index, cl, now)
+		switch ts.by {
+		case "bytes":
+			if ok {
+				score = delta.bytes / delta.seconds
+			}
+		case "drops":
+			if ok {
+				score = delta.drops / delta.seconds
+			}
+		case "backlog":
+			if s, ok := readStats(cl); ok {
+				score = s.backlog
+			}
+		}
+		scores = append(scores, ranked{cl, score})
+	}
+	ts.deltas.expire(now)
+
+	// ties are broken on the handle, so the selection does not change between scrapes for idle
+	// classes
+	slices.SortFunc(scores, func(a, b ranked) int {
+		return cmp.Or(
+			cmp.Compare(b.score, a.score),
+			cmp.Compare(a.cl.Handle, b.cl.Handle),
+		)
+	})
+	if len(scores) > ts.n {
+		scores = scores[:ts.n]
+	}
+
+	top := make([]tc.Object, 0, len(scores))
+	for i, r := range scores {
+		top = append(top, r.cl)
+		handleMaj, handleMin := HandleStr(r.cl.Handle)
+		parentMaj, parentMin := HandleStr(r.cl.Parent)
+		ch <- prometheus.MustNewConstMetric(
+			ts.rank,
+			prometheus.GaugeValue,
+			float64(i+1),
+			host,
+			ns,
+			fmt.Sprintf("%d", interf.Index),
+			interf.Attributes.Name,
+			r.cl.Kind,
+			fmt.Sprintf("%x:%x", handleMaj, handleMin),
+			fmt.Sprintf("%x:%x", parentMaj, parentMin),
+		)
+	}
+	return top
+}
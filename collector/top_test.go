@@ -0,0 +1,86 @@
+package tccollector_test
+
+import (
+	"log/slog"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	tcexporter "github.com/fbegyn/tc_exporter/collector"
+	"github.com/florianl/go-tc"
+	"github.com/florianl/go-tc/core"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestTopSelectorRank(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	interf := rtnetlink.LinkMessage{Index: 1, Attributes: &rtnetlink.LinkAttributes{Name: "eth0"}}
+	class := func(minor uint32, bytes uint64, backlog uint32) tc.Object {
+		return tc.Object{
+			Msg: tc.Msg{Handle: core.BuildHandle(1, minor), Parent: core.BuildHandle(1, 1)},
+			Attribute: tc.Attribute{
+				Kind:   "htb",
+				Stats2: &tc.Stats2{Bytes: bytes, Backlog: backlog},
+			},
+		}
+	}
+	// selects the classes and returns their minors and ranks
+	selectTop := func(ts *tcexporter.TopSelector, classes []tc.Object) ([]uint32, []float64) {
+		ch := make(chan prometheus.Metric, len(classes))
+		top := ts.Select(ch, "host", "default", interf, classes)
+		close(ch)
+		var minors []uint32
+		for _, cl := range top {
+			minors = append(minors, cl.Handle&0xffff)
+		}
+		var ranks []float64
+		for m := range ch {
+			var pb dto.Metric
+			if err := m.Write(&pb); err != nil {
+				t.Fatalf("failed to write metric: %v", err)
+			}
+			ranks = append(ranks, pb.Gauge.GetValue())
+		}
+		return minors, ranks
+	}
+
+	t.Run("bytes", func(t *testing.T) {
+		ts, err := tcexporter.NewTopSelector(tcexporter.TopConfig{N: 2}, logger)
+		if err != nil {
+			t.Fatalf("failed to create top selector: %v", err)
+		}
+		// the byte totals do not matter, only the rate since the previous scrape
+		minors, _ := selectTop(ts, []tc.Object{class(0x10, 9000, 0), class(0x20, 0, 0), class(0x30, 0, 0)})
+		if !slices.Equal(minors, []uint32{0x10, 0x20}) {
+			t.Fatalf("unexpected selection without rates: %x", minors)
+		}
+		time.Sleep(10 * time.Millisecond)
+		minors, ranks := selectTop(ts, []tc.Object{class(0x10, 9000, 0), class(0x20, 100, 0), class(0x30, 500, 0)})
+		if !slices.Equal(minors, []uint32{0x30, 0x20}) {
+			t.Fatalf("unexpected selection: %x", minors)
+		}
+		if !slices.Equal(ranks, []float64{1, 2}) {
+			t.Fatalf("unexpected ranks: %v", ranks)
+		}
+	})
+
+	t.Run("backlog", func(t *testing.T) {
+		ts, err := tcexporter.NewTopSelector(tcexporter.TopConfig{N: 1, By: "backlog"}, logger)
+		if err != nil {
+			t.Fatalf("failed to create top selector: %v", err)
+		}
+		minors, _ := selectTop(ts, []tc.Object{class(0x10, 0, 100), class(0x20, 0, 3000)})
+		if !slices.Equal(minors, []uint32{0x20}) {
+			t.Fatalf("unexpected selection: %x", minors)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		if _, err := tcexporter.NewTopSelector(tcexporter.TopConfig{By: "packets"}, logger); err == nil {
+			t.Fatalf("expected an error for an invalid ranking")
+		}
+	})
+}
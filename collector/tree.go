@@ -0,0 +1,183 @@
+package tccollector
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/florianl/go-tc"
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	treeLabels []string = []string{"host", "netns", "linkindex", "link", "type", "handle", "parent", "path", "depth", "leaf_qdisc"}
+)
+
+// maxTreeDepth limits the walk up the tree, a broken dump can never make it loop forever
+const maxTreeDepth = 64
+
+// ObjectTree links the qdiscs and classes of an interface together. Qdisc handles always have a
+// minor of 0 and class handles never do, so both live in the same handle space.
+type ObjectTree struct {
+	parents map[uint32]uint32
+	// inner are the classes that have classes below them
+	inner map[uint32]bool
+}
+
+// NewObjectTree builds the tree of the qdiscs and classes of a single interface
+func NewObjectTree(qdiscs, classes []tc.Object) ObjectTree {
+	parents := make(map[uint32]uint32, len(qdiscs)+len(classes))
+	for _, qd := range qdiscs {
+		// the child qdiscs of mq and mqprio all have handle 0:, only the root one can be a parent
+		if qd.Handle == 0 && qd.Parent != tc.HandleRoot {
+			continue
+		}
+		parents[qd.Handle] = qd.Parent
+	}
+	inner := make(map[uint32]bool)
+	for _, cl := range classes {
+		parents[cl.Handle] = cl.Parent
+		if cl.Parent&0xffff != 0 {
+			inner[cl.Parent] = true
+		}
+	}
+	return ObjectTree{parents: parents, inner: inner}
+}
+
+// IsLeaf reports if no class hangs below the class. A leaf class can still have a qdisc.
+func (tr ObjectTree) IsLeaf(handle uint32) bool {
+	return !tr.inner[handle]
+}
+
+// Ancestors returns the handles of the classes and qdiscs above the object, nearest first. The
+// last one is root or ingress for a complete tree.
+func (tr ObjectTree) Ancestors(handle, parent uint32) []uint32 {
+	var ancestors []uint32
+	for range maxTreeDepth {
+		var next uint32
+		switch {
+		case handle&0xffff == 0:
+			// a qdisc hangs below a class, or below root or ingress at the top of the tree
+			next = parent
+		case parent != tc.HandleRoot && parent&0xffff != 0 && parent&0xffff0000 == handle&0xffff0000:
+			next = parent
+		default:
+			// top level classes report root or their qdisc as parent
+			next = handle & 0xffff0000
+		}
+		if next == 0 && handle&0xffff == 0 {
+			return ancestors
+		}
+		ancestors = append(ancestors, next)
+		if next == tc.HandleRoot || next == tc.HandleIngress {
+			return ancestors
+		}
+
+		p, found := tr.parents[next]
+		if !found && next&0xffff == 0 {
+			return ancestors
+		}
+		handle, parent = next, p
+	}
+	return ancestors
+}
+
+// FmtTcHandle formats a handle the way tc prints it: 1:10 for classes, 1: for qdiscs and root or
+// ingress for the top of the tree
+func FmtTcHandle(handle uint32) string {
+	switch handle {
+	case tc.HandleRoot:
+		return "root"
+	case tc.HandleIngress:
+		return "ingress"
+	}
+	maj, min := HandleStr(handle)
+	if min == 0 {
+		return fmt.Sprintf("%x:", maj)
+	}
+	return fmt.Sprintf("%x:%x", maj, min)
+}
+
+// TreeCollector exports the position of every qdisc and class in the tree of the interface
+type TreeCollector struct {
+	logger slog.Logger
+	netns  map[string][]rtnetlink.LinkMessage
+	info   *prometheus.Desc
+}
+
+// NewTreeCollector create a new TreeCollector given a network interface
+func NewTreeCollector(netns map[string][]rtnetlink.LinkMessage, log *slog.Logger) (InterfaceCollector, error) {
+	log = log.With("collector", "tree")
+	log.Info("making tree collector")
+
+	return &TreeCollector{
+		logger: *log,
+		netns:  netns,
+		info: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "object", "info"),
+			"Position of the qdisc or class in the tree of the interface",
+			treeLabels, nil,
+		),
+	}, nil
+}
+
+// Describe implements Collector
+func (col *TreeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- col.info
+}
+
+// CollectInterface exports the tree info of the qdiscs and classes of the interface. Objects that
+// are filtered out are missing from the paths of the objects below them.
+func (col *TreeCollector) CollectInterface(ch chan<- prometheus.Metric, host, ns string, interf rtnetlink.LinkMessage, qdiscs, classes []tc.Object) {
+	tree := NewObjectTree(qdiscs, classes)
+
+	// the qdisc that is attached to a class is the leaf qdisc of that class
+	leafQdiscs := make(map[uint32]uint32)
+	for _, qd := range qdiscs {
+		if qd.Handle != 0 {
+			leafQdiscs[qd.Parent] = qd.Handle
+		}
+	}
+
+	objects := make([]tc.Object, 0, len(qdiscs)+len(classes))
+	objects = append(objects, qdiscs...)
+	objects = append(objects, classes...)
+	for _, obj := range objects {
+		ancestors := tree.Ancestors(obj.Handle, obj.Parent)
+		path := make([]string, 0, len(ancestors)+1)
+		depth := 0
+		for i := len(ancestors) - 1; i >= 0; i-- {
+			if ancestors[i] != tc.HandleRoot && ancestors[i] != tc.HandleIngress {
+				depth++
+			}
+			path = append(path, FmtTcHandle(ancestors[i]))
+		}
+		path = append(path, FmtTcHandle(obj.Handle))
+
+		var leaf string
+		if obj.Handle&0xffff != 0 {
+			if qd, found := leafQdiscs[obj.Handle]; found {
+				leaf = FmtTcHandle(qd)
+			}
+		}
+
+		handleMaj, handleMin := HandleStr(obj.Handle)
+		parentMaj, parentMin := HandleStr(obj.Parent)
+		ch <- prometheus.MustNewConstMetric(
+			col.info,
+			prometheus.GaugeValue,
+			1,
+			host,
+			ns,
+			fmt.Sprintf("%d", interf.Index),
+			interf.Attributes.Name,
+			obj.Kind,
+			fmt.Sprintf("%x:%x", handleMaj, handleMin),
+			fmt.Sprintf("%x:%x", parentMaj, parentMin),
+			strings.Join(path, "/"),
+			fmt.Sprintf("%d", depth),
+			leaf,
+		)
+	}
+}
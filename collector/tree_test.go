@@ -0,0 +1,89 @@
+package tccollector_test
+
+import (
+	"slices"
+	"testing"
+
+	tcexporter "github.com/fbegyn/tc_exporter/collector"
+	"github.com/florianl/go-tc"
+	"github.com/florianl/go-tc/core"
+)
+
+// testTree is an HTB setup on egress with fq_codel leaves and an ingress qdisc
+//
+//	root -> 1: htb -> 1:1 -> 1:10 -> 10: fq_codel
+//	                      -> 1:20 -> 20: fq_codel
+//	ingress -> ffff: ingress
+func testTree() ([]tc.Object, []tc.Object) {
+	object := func(kind string, handle, parent uint32) tc.Object {
+		return tc.Object{
+			Msg:       tc.Msg{Handle: handle, Parent: parent},
+			Attribute: tc.Attribute{Kind: kind},
+		}
+	}
+	qdiscs := []tc.Object{
+		object("htb", core.BuildHandle(1, 0), tc.HandleRoot),
+		object("fq_codel", core.BuildHandle(0x10, 0), core.BuildHandle(1, 0x10)),
+		object("fq_codel", core.BuildHandle(0x20, 0), core.BuildHandle(1, 0x20)),
+		object("ingress", core.BuildHandle(0xffff, 0), tc.HandleIngress),
+	}
+	classes := []tc.Object{
+		object("htb", core.BuildHandle(1, 1), tc.HandleRoot),
+		object("htb", core.BuildHandle(1, 0x10), core.BuildHandle(1, 1)),
+		object("htb", core.BuildHandle(1, 0x20), core.BuildHandle(1, 1)),
+	}
+	return qdiscs, classes
+}
+
+func TestObjectTreeAncestors(t *testing.T) {
+	qdiscs, classes := testTree()
+	tree := tcexporter.NewObjectTree(qdiscs, classes)
+
+	tests := []struct {
+		name      string
+		handle    uint32
+		parent    uint32
+		ancestors []uint32
+	}{
+		{name: "root qdisc", handle: core.BuildHandle(1, 0), parent: tc.HandleRoot,
+			ancestors: []uint32{tc.HandleRoot}},
+		{name: "top level class", handle: core.BuildHandle(1, 1), parent: tc.HandleRoot,
+			ancestors: []uint32{core.BuildHandle(1, 0), tc.HandleRoot}},
+		{name: "leaf class", handle: core.BuildHandle(1, 0x10), parent: core.BuildHandle(1, 1),
+			ancestors: []uint32{core.BuildHandle(1, 1), core.BuildHandle(1, 0), tc.HandleRoot}},
+		{name: "leaf qdisc", handle: core.BuildHandle(0x20, 0), parent: core.BuildHandle(1, 0x20),
+			ancestors: []uint32{core.BuildHandle(1, 0x20), core.BuildHandle(1, 1), core.BuildHandle(1, 0), tc.HandleRoot}},
+		{name: "ingress", handle: core.BuildHandle(0xffff, 0), parent: tc.HandleIngress,
+			ancestors: []uint32{tc.HandleIngress}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ancestors := tree.Ancestors(tt.handle, tt.parent)
+			if !slices.Equal(ancestors, tt.ancestors) {
+				t.Fatalf("unexpected ancestors: got %x, want %x", ancestors, tt.ancestors)
+			}
+		})
+	}
+}
+
+func TestFmtTcHandle(t *testing.T) {
+	tests := []struct {
+		handle uint32
+		want   string
+	}{
+		{handle: tc.HandleRoot, want: "root"},
+		{handle: tc.HandleIngress, want: "ingress"},
+		{handle: core.BuildHandle(1, 0), want: "1:"},
+		{handle: core.BuildHandle(1, 0x1a3), want: "1:1a3"},
+		{handle: core.BuildHandle(0xffff, 0), want: "ffff:"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := tcexporter.FmtTcHandle(tt.handle); got != tt.want {
+				t.Fatalf("unexpected handle: got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}